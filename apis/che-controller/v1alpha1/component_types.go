@@ -23,6 +23,31 @@ const (
 	MultiHost  RoutingType = "multihost"
 )
 
+type GatewayConfigProvider string
+
+const (
+	// ConfigMapsConfigProvider stores the dynamic configuration of the gateway in config maps that are
+	// synced into the gateway pod by a sidecar.
+	ConfigMapsConfigProvider GatewayConfigProvider = "configmaps"
+
+	// HTTPConfigProvider makes the gateway poll the operator for its dynamic configuration over HTTP.
+	HTTPConfigProvider GatewayConfigProvider = "http"
+
+	// KubernetesCRDConfigProvider stores the dynamic configuration of the workspaces in the Traefik IngressRoute
+	// and Middleware objects in the namespaces of the workspaces.
+	KubernetesCRDConfigProvider GatewayConfigProvider = "kubernetescrd"
+)
+
+type GatewayImplementation string
+
+const (
+	// TraefikGatewayImplementation uses Traefik as the gateway server.
+	TraefikGatewayImplementation GatewayImplementation = "traefik"
+
+	// EnvoyGatewayImplementation uses Envoy as the gateway server.
+	EnvoyGatewayImplementation GatewayImplementation = "envoy"
+)
+
 // CheManagerSpec holds the configuration of the Che controller.
 // +k8s:openapi-gen=true
 type CheManagerSpec struct {
@@ -45,6 +70,243 @@ type CheManagerSpec struct {
 	// it is taken from the `RELATED_IMAGE_gateway_configurer` environment variable of the che
 	// operator deployment/pod. If not defined there it defaults to a hardcoded value.
 	GatewayConfigurerImage string `json:"gatewayConfigurerImage,omitempty"`
+
+	// MiddlewareProfiles are named sets of additional request processing that the gateway can apply
+	// to the endpoints of individual workspaces. A workspace routing selects the profiles either for
+	// all its endpoints using the `che.routing.controller.devfile.io/middleware-profiles` annotation
+	// or for individual endpoints using the `middlewareProfiles` endpoint attribute. Both contain
+	// a comma-separated list of profile names. This is only used in the singlehost mode.
+	MiddlewareProfiles []MiddlewareProfile `json:"middlewareProfiles,omitempty"`
+
+	// WorkspaceBackends configures how the gateway checks the health of the workspace backends and how
+	// it handles their failures. This is only used in the singlehost mode.
+	WorkspaceBackends *WorkspaceBackendsConfig `json:"workspaceBackends,omitempty"`
+
+	// ErrorPagesConfigMap is the name of a config map in the namespace of the Che manager containing
+	// the pages the gateway serves instead of its bare error responses. The `404.html` page is served for
+	// the paths that don't belong to any running workspace. The `502.html`, `503.html` and `504.html`
+	// pages are served when the workspace backend is not available, e.g. when the workspace is still
+	// starting. Because the gateway cannot tell these from the error responses of the application itself,
+	// the endpoints can opt out of them by setting the `errorPages` attribute to `false`. If not specified,
+	// default pages are used. This is only used in the singlehost mode.
+	ErrorPagesConfigMap string `json:"errorPagesConfigMap,omitempty"`
+
+	// Gateway contains the settings of the gateway server. This is only used in the singlehost mode.
+	Gateway GatewaySettings `json:"gateway,omitempty"`
+}
+
+// GatewaySettings configures the gateway server.
+type GatewaySettings struct {
+	// Implementation is the server used as the gateway. Defaults to "traefik". The "envoy" implementation
+	// obtains the configuration of the workspaces from the operator using the REST variant of the xDS API and
+	// therefore requires the "http" config provider. It doesn't support the tracing, the timeouts, the trusted
+	// forwarded headers IPs, the insecure forwarded headers and the circuit breaker of the workspace backends. The additional static
+	// configuration is merged into its bootstrap configuration and the error pages are served by Envoy itself
+	// for the errors it generates, so the custom error pages config map is not supported either. The additional
+	// config maps labeled as the gateway configuration are in the Traefik format and are ignored.
+	// +kubebuilder:validation:Enum=traefik;envoy
+	Implementation GatewayImplementation `json:"implementation,omitempty"`
+
+	// LogLevel is the level of the messages logged by the gateway. Defaults to INFO.
+	// +kubebuilder:validation:Enum=DEBUG;INFO;WARN;ERROR;FATAL;PANIC
+	LogLevel string `json:"logLevel,omitempty"`
+
+	// LogFormat is the format of the messages logged by the gateway. Defaults to "common".
+	// +kubebuilder:validation:Enum=common;json
+	LogFormat string `json:"logFormat,omitempty"`
+
+	// AccessLog enables the logging of the requests handled by the gateway if defined.
+	AccessLog *GatewayAccessLog `json:"accessLog,omitempty"`
+
+	// TrustedForwardedHeadersIPs is the list of IPs or CIDR ranges from which the gateway accepts
+	// the X-Forwarded-* headers. If not specified, the headers sent by the clients are not trusted
+	// and the gateway replaces them with its own.
+	TrustedForwardedHeadersIPs []string `json:"trustedForwardedHeadersIPs,omitempty"`
+
+	// InsecureForwardedHeaders makes the gateway accept the X-Forwarded-* headers from anywhere. This should
+	// only be enabled if the gateway is not reachable other than through a trusted proxy. If enabled, the
+	// TrustedForwardedHeadersIPs are ignored.
+	InsecureForwardedHeaders bool `json:"insecureForwardedHeaders,omitempty"`
+
+	// Timeouts configures the timeouts of the incoming connections.
+	Timeouts *GatewayTimeouts `json:"timeouts,omitempty"`
+
+	// ConfigProvider specifies how the gateway obtains its dynamic configuration. In the "configmaps" mode
+	// (the default), the configuration of each workspace is stored in a config map that is synced into the
+	// gateway pod by a sidecar. In the "http" mode, the gateway polls the operator for the configuration of
+	// all the workspaces over HTTP. This requires no sidecar and no permissions for the gateway to read
+	// the config maps and propagates the changes faster. The HTTP routers, services and middlewares from any
+	// additional config maps labeled as the gateway configuration of the che manager are served along with
+	// the configuration of the workspaces, as the gateway would load them in the "configmaps" mode. The gateway
+	// authenticates to the operator with a token generated into the "<name>-config-server-token" secret.
+	// The "http" mode requires Traefik 2.3 or later.
+	// In the "kubernetescrd" mode, the configuration of each workspace is stored in the Traefik IngressRoute
+	// and Middleware objects in the namespace of the workspace. This requires the Traefik CRDs to be installed
+	// in the cluster. The gateway is only granted the access to the namespaces of the workspaces and restarted
+	// when a workspace is created in a new namespace.
+	// +kubebuilder:validation:Enum=configmaps;http;kubernetescrd
+	ConfigProvider GatewayConfigProvider `json:"configProvider,omitempty"`
+
+	// Metrics enables the Prometheus metrics of the gateway if defined. The metrics are exposed on a dedicated
+	// port of the gateway service. The metrics of the workspace backends are labelled with the names of
+	// the gateway services which start with the ID of the workspace. If the Prometheus operator is installed
+	// in the cluster, a ServiceMonitor is created for the gateway.
+	Metrics *GatewayMetrics `json:"metrics,omitempty"`
+
+	// Tracing enables the distributed tracing of the requests passing through the gateway if defined.
+	// The gateway starts the traces and propagates the trace context to the workspace backends.
+	Tracing *GatewayTracing `json:"tracing,omitempty"`
+
+	// Probes configures the timings of the probes of the gateway containers. If not specified, the defaults
+	// are used.
+	Probes *GatewayProbes `json:"probes,omitempty"`
+
+	// StaticConfigSecrets is a list of the names of the secrets in the namespace of the Che manager that are
+	// mounted into the gateway container at `/etc/traefik/secrets/<secret name>`. The files from these secrets
+	// can be referenced from the additional static configuration, e.g. as TLS certificates. The secrets need to
+	// have the `che.routing.controller.devfile.io/gateway-static-config` label, the operator doesn't see any other
+	// secrets. Whenever the content of the secrets changes, the gateway is restarted to pick up the changes.
+	StaticConfigSecrets []string `json:"staticConfigSecrets,omitempty"`
+
+	// AdditionalStaticConfig is a YAML document merged into the static configuration generated for
+	// the gateway. The values from this document take precedence over the generated ones. This can be
+	// used to configure the features of the gateway that are not otherwise exposed in this resource.
+	AdditionalStaticConfig string `json:"additionalStaticConfig,omitempty"`
+}
+
+// GatewayAccessLog configures the access log of the gateway.
+type GatewayAccessLog struct {
+	// Format is the format of the access log. Defaults to "common".
+	// +kubebuilder:validation:Enum=common;json
+	Format string `json:"format,omitempty"`
+}
+
+// GatewayMetrics configures the Prometheus metrics of the gateway.
+type GatewayMetrics struct {
+	// ScrapeInterval is the interval in which Prometheus scrapes the metrics of the gateway, e.g. "30s".
+	// This is only used in the ServiceMonitor. If not specified, the default of Prometheus is used.
+	ScrapeInterval string `json:"scrapeInterval,omitempty"`
+}
+
+// GatewayTracing configures the distributed tracing in the gateway. The traces are sent using the Zipkin
+// protocol, which is also understood by e.g. the OpenTelemetry collector or Jaeger.
+type GatewayTracing struct {
+	// Endpoint is the URL to which the traces are sent, e.g. "http://otel-collector:9411/api/v2/spans".
+	Endpoint string `json:"endpoint"`
+
+	// SamplingRate is the ratio of the requests that are traced, a decimal number between 0 and 1 (inclusive).
+	// Defaults to "1", which means that all requests are traced.
+	// +kubebuilder:validation:Pattern=`^(0(\.[0-9]+)?|1(\.0+)?)$`
+	SamplingRate string `json:"samplingRate,omitempty"`
+
+	// ServiceName is the name under which the gateway reports the traces. Defaults to "che-gateway".
+	ServiceName string `json:"serviceName,omitempty"`
+}
+
+// GatewayProbes configures the timings of the liveness, readiness and startup probes of the gateway containers.
+type GatewayProbes struct {
+	// Liveness configures the probe restarting the containers that stopped working.
+	Liveness *ProbeTimings `json:"liveness,omitempty"`
+
+	// Readiness configures the probe taking the gateway out of the service when it cannot handle requests.
+	Readiness *ProbeTimings `json:"readiness,omitempty"`
+
+	// Startup configures the probe holding off the other probes until the containers have started.
+	Startup *ProbeTimings `json:"startup,omitempty"`
+}
+
+// ProbeTimings are the timings of a probe. The unspecified values are defaulted.
+type ProbeTimings struct {
+	// InitialDelaySeconds is the number of seconds after the container has started before the probe is initiated.
+	// +kubebuilder:validation:Minimum=0
+	InitialDelaySeconds *int32 `json:"initialDelaySeconds,omitempty"`
+
+	// PeriodSeconds is how often (in seconds) to perform the probe.
+	// +kubebuilder:validation:Minimum=1
+	PeriodSeconds *int32 `json:"periodSeconds,omitempty"`
+
+	// TimeoutSeconds is the number of seconds after which the probe times out.
+	// +kubebuilder:validation:Minimum=1
+	TimeoutSeconds *int32 `json:"timeoutSeconds,omitempty"`
+
+	// FailureThreshold is the number of consecutive failures of the probe after which it is considered failed.
+	// +kubebuilder:validation:Minimum=1
+	FailureThreshold *int32 `json:"failureThreshold,omitempty"`
+}
+
+// GatewayTimeouts configures the timeouts of the incoming connections to the gateway. The values are
+// durations, e.g. "60s". If not specified, the defaults of the gateway server are used.
+type GatewayTimeouts struct {
+	// ReadTimeout is the maximum duration for reading the entire request, including the body.
+	ReadTimeout string `json:"readTimeout,omitempty"`
+
+	// WriteTimeout is the maximum duration before timing out writes of the response.
+	WriteTimeout string `json:"writeTimeout,omitempty"`
+
+	// IdleTimeout is the maximum duration an idle (keep-alive) connection remains idle before closing itself.
+	IdleTimeout string `json:"idleTimeout,omitempty"`
+}
+
+// WorkspaceBackendsConfig configures the handling of the workspace backends by the gateway.
+// The health of a backend is only checked if at least one of the endpoints exposed by it specifies
+// the `healthCheckPath` attribute.
+type WorkspaceBackendsConfig struct {
+	// HealthCheckInterval is the interval between the health checks of the backend, e.g. "10s".
+	// Defaults to "10s".
+	HealthCheckInterval string `json:"healthCheckInterval,omitempty"`
+
+	// HealthCheckTimeout is the time after which an unanswered health check is considered failed, e.g. "3s".
+	// Defaults to "3s".
+	HealthCheckTimeout string `json:"healthCheckTimeout,omitempty"`
+
+	// RetryAttempts is the number of times the gateway retries a request that failed due to a network
+	// error. Defaults to 0, which means no retries.
+	RetryAttempts int `json:"retryAttempts,omitempty"`
+
+	// CircuitBreakerExpression is the condition under which the gateway stops forwarding the requests to
+	// the backend, e.g. "NetworkErrorRatio() > 0.5". See the documentation of the circuit breaker of
+	// Traefik for the syntax. If not specified, no circuit breaker is used.
+	CircuitBreakerExpression string `json:"circuitBreakerExpression,omitempty"`
+}
+
+// MiddlewareProfile is a named set of middlewares applied by the gateway to the workspace endpoints
+// that reference it.
+type MiddlewareProfile struct {
+	// Name is the name under which the workspace routings reference this profile.
+	Name string `json:"name"`
+
+	// MaxRequestBodyBytes is the maximum allowed size of the request body. Requests with larger
+	// bodies are refused by the gateway. If not specified, the request body size is not limited.
+	MaxRequestBodyBytes *int64 `json:"maxRequestBodyBytes,omitempty"`
+
+	// CORS configures the CORS response headers added by the gateway.
+	CORS *CORSPolicy `json:"cors,omitempty"`
+
+	// RequestHeaders are set on the requests before they are passed to the workspace. An empty value
+	// removes the header from the request.
+	RequestHeaders map[string]string `json:"requestHeaders,omitempty"`
+
+	// ResponseHeaders are set on the responses from the workspace. An empty value removes the header
+	// from the response.
+	ResponseHeaders map[string]string `json:"responseHeaders,omitempty"`
+}
+
+// CORSPolicy configures the CORS headers that the gateway adds to the responses.
+type CORSPolicy struct {
+	// AllowOrigin is the origin allowed to access the endpoints. Use "*" to allow any origin.
+	AllowOrigin string `json:"allowOrigin,omitempty"`
+
+	// AllowMethods is the list of HTTP methods allowed in the cross-origin requests.
+	AllowMethods []string `json:"allowMethods,omitempty"`
+
+	// AllowHeaders is the list of HTTP headers allowed in the cross-origin requests.
+	AllowHeaders []string `json:"allowHeaders,omitempty"`
+
+	// AllowCredentials specifies whether the cross-origin requests can include user credentials.
+	AllowCredentials bool `json:"allowCredentials,omitempty"`
+
+	// MaxAge is the number of seconds the result of a preflight request can be cached for.
+	MaxAge int64 `json:"maxAge,omitempty"`
 }
 
 type GatewayPhase string
@@ -59,6 +321,25 @@ const (
 type CheManagerStatus struct {
 	GatewayPhase GatewayPhase `json:"gatewayPhase,omitempty"`
 	GatewayHost  string       `json:"gatewayHost,omitempty"`
+
+	// WorkspaceRouting is the routing mode all the workspace routings of the che manager are exposed in. It differs
+	// from the routing mode in the spec while the workspace routings are being re-solved after the routing mode
+	// has changed.
+	WorkspaceRouting RoutingType `json:"workspaceRouting,omitempty"`
+
+	// PendingWorkspaceRoutings is the number of the workspace routings that are not exposed in the routing mode
+	// from the spec yet.
+	PendingWorkspaceRoutings int `json:"pendingWorkspaceRoutings,omitempty"`
+
+	// WorkspaceNamespaces are the namespaces of the workspace routings of the che manager. The gateway only reads
+	// the Traefik CRDs from these namespaces and from the namespace of the che manager if it is configured with
+	// the "kubernetescrd" config provider. The list is empty for the other config providers.
+	WorkspaceNamespaces []string `json:"workspaceNamespaces,omitempty"`
+
+	// GatewayConfigProvider is the config provider the gateway configuration of the workspaces is stored for.
+	// When it differs from the config provider in the spec, the configuration stored for the previous config
+	// provider is removed. It is empty if the che manager doesn't use the gateway.
+	GatewayConfigProvider GatewayConfigProvider `json:"gatewayConfigProvider,omitempty"`
 }
 
 // CheManager is the configuration of the CheManager layer of Devworkspace.
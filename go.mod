@@ -7,6 +7,7 @@ require (
 	github.com/devfile/devworkspace-operator v0.0.0-20210208163832-3bdccac9f3aa
 	github.com/google/go-cmp v0.5.0
 	github.com/openshift/api v0.0.0-20200205133042-34f0ec8dab87
+	github.com/prometheus/client_golang v1.0.0
 	k8s.io/api v0.18.8
 	k8s.io/apimachinery v0.18.8
 	k8s.io/client-go v0.18.8
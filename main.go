@@ -15,9 +15,11 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
 	controllerv1alpha1 "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
 	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting"
+	"github.com/devfile/devworkspace-operator/pkg/config"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
@@ -27,6 +29,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/filteredcache"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/infrastructure"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/manager"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/solver"
@@ -55,8 +59,16 @@ func init() {
 func main() {
 
 	var metricsAddr string
+	var gatewayConfigAddr string
 	var enableLeaderElection bool
+	var serverSideApply bool
+	var dryRun bool
+	var gatewayConfigSweepInterval time.Duration
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&gatewayConfigAddr, "gateway-config-addr", ":8090", "The address the gateway configuration endpoint binds to.")
+	flag.DurationVar(&gatewayConfigSweepInterval, "gateway-config-sweep-interval", 10*time.Minute, "How often to delete the gateway configuration of the no longer existing workspaces.")
+	flag.BoolVar(&serverSideApply, "server-side-apply", false, "Use the server-side apply to sync the objects managed by the operator with the cluster.")
+	flag.BoolVar(&dryRun, "dry-run", false, "Only log and record the events about the changes the operator would make to the gateways and the gateway configuration of the workspaces instead of making them.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -69,12 +81,23 @@ func main() {
 		os.Exit(1)
 	}
 
+	// the objects are compared with their blueprints by the operator itself if the server-side apply is disabled
+	fieldManager := ""
+	if serverSideApply {
+		fieldManager = "devworkspace-che-operator"
+	}
+
+	// the multihost mode exposes the workspaces using the routing solvers of the devworkspace operator
+	config.ControllerCfg.SetIsOpenShift(infrastructure.Current.Type == infrastructure.OpenShift)
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:             scheme,
 		MetricsBindAddress: metricsAddr,
 		Port:               9443,
 		LeaderElection:     enableLeaderElection,
 		LeaderElectionID:   "8d217f94.devfile.io",
+		// only cache the config maps and secrets we need, there can be very many others in the cluster
+		NewCache: filteredcache.NewCacheFunc(defaults.GetCachedConfigMapsSelector(), defaults.GetCachedSecretsSelector()),
 	})
 
 	if err != nil {
@@ -82,17 +105,30 @@ func main() {
 		os.Exit(1)
 	}
 
-	cheReconciler := &manager.CheReconciler{}
+	if err = manager.SetupIndices(mgr); err != nil {
+		setupLog.Error(err, "unable to set up the indices of the workspace routings")
+		os.Exit(1)
+	}
+
+	cheReconciler := &manager.CheReconciler{FieldManager: fieldManager, DryRun: dryRun}
 	if err = cheReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Che")
 		os.Exit(1)
 	}
 
+	solverGetter := solver.Getter(scheme)
+	solverGetter.FieldManager = fieldManager
+	solverGetter.DryRun = dryRun
+	if err = solverGetter.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to set up the routing solver")
+		os.Exit(1)
+	}
+
 	routingReconciler := &workspacerouting.WorkspaceRoutingReconciler{
 		Client:       mgr.GetClient(),
 		Log:          ctrl.Log.WithName("controllers").WithName("WorkspaceRouting"),
 		Scheme:       mgr.GetScheme(),
-		SolverGetter: solver.Getter(scheme),
+		SolverGetter: solverGetter,
 	}
 
 	if err = routingReconciler.SetupWithManager(mgr); err != nil {
@@ -100,6 +136,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err = mgr.Add(solverGetter.ConfigServer(mgr, gatewayConfigAddr)); err != nil {
+		setupLog.Error(err, "unable to set up the gateway config server")
+		os.Exit(1)
+	}
+
+	if err = mgr.Add(solverGetter.ConfigMapSweeper(mgr, gatewayConfigSweepInterval)); err != nil {
+		setupLog.Error(err, "unable to set up the sweeper of the orphaned gateway configuration")
+		os.Exit(1)
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
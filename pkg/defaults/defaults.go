@@ -1,25 +1,63 @@
 package defaults
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"runtime"
+	"strings"
 
 	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/devfile/devworkspace-operator/pkg/config"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
 const (
+	componentLabel = "app.kubernetes.io/component"
+	appNameLabel   = "app.kubernetes.io/name"
+
 	gatewayImageEnvVarName           = "RELATED_IMAGE_gateway"
 	gatewayConfigurerImageEnvVarName = "RELATED_IMAGE_gateway_configurer"
+	gatewayErrorPagesImageEnvVarName = "RELATED_IMAGE_gateway_error_pages"
+	gatewayEnvoyImageEnvVarName      = "RELATED_IMAGE_gateway_envoy"
+	gatewayConfigServerURLEnvVarName = "GATEWAY_CONFIG_SERVER_URL"
 
-	defaultGatewayImage           = "docker.io/traefik:v2.2.8"
+	defaultGatewayImage           = "docker.io/traefik:v2.4.8"
 	defaultGatewayConfigurerImage = "quay.io/che-incubator/configbump:0.1.4"
+	defaultGatewayErrorPagesImage = "docker.io/nginxinc/nginx-unprivileged:1.19-alpine"
+	defaultGatewayEnvoyImage      = "docker.io/envoyproxy/envoy:v1.18.3"
+
+	// the URL of the config server service in the namespace of the operator as created by the deployment files
+	defaultGatewayConfigServerURLPattern = "http://devworkspace-che-config-server.%s.svc:8090"
+
+	// the namespace the operator is deployed to by default, used if it cannot be detected
+	defaultOperatorNamespace = "devworkspace-che"
+	operatorNamespaceFile    = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+	// GatewayConfigPathPrefix is the path on the gateway config server under which the dynamic configuration
+	// of the gateways is served. The full path is "<prefix><che manager namespace>/<che manager name>".
+	GatewayConfigPathPrefix = "/gateway/"
 
 	configAnnotationPrefix                    = "che.routing.controller.devfile.io/"
 	ConfigAnnotationCheManagerName            = configAnnotationPrefix + "che-name"
 	ConfigAnnotationCheManagerNamespace       = configAnnotationPrefix + "che-namespace"
 	ConfigAnnotationWorkspaceRoutingName      = configAnnotationPrefix + "workspace-routing-name"
 	ConfigAnnotationWorkspaceRoutingNamespace = configAnnotationPrefix + "workspace-routing-namespace"
+	ConfigAnnotationMiddlewareProfiles        = configAnnotationPrefix + "middleware-profiles"
+
+	// ConfigAnnotationRoutingFailureReason is the annotation on the workspace routings the operator marked as failed,
+	// describing why. The routing solver reports the annotated routings as invalid.
+	ConfigAnnotationRoutingFailureReason = configAnnotationPrefix + "failure-reason"
+
+	// GatewayConfigCheNamespaceLabel is the label on the gateway configuration objects outside of the namespace
+	// of the che manager, holding the namespace of the che manager the configuration belongs to.
+	GatewayConfigCheNamespaceLabel = configAnnotationPrefix + "che-namespace"
+
+	// GatewayStaticConfigSecretLabel is the label the secrets referenced from the static configuration of
+	// the gateways need to have. The operator only caches and watches the secrets with this label.
+	GatewayStaticConfigSecretLabel = configAnnotationPrefix + "gateway-static-config"
 )
 
 var (
@@ -30,18 +68,73 @@ func GetGatewayWorkpaceConfigMapName(workspaceID string) string {
 	return workspaceID
 }
 
+// GetGatewayWorkspaceConfigMapPartName returns the name of the config map holding the given part of the gateway
+// configuration of the workspace. The first part is stored in the config map with the name returned by
+// GetGatewayWorkpaceConfigMapName, the additional parts are only used when the configuration is too large to fit
+// into a single config map.
+func GetGatewayWorkspaceConfigMapPartName(workspaceID string, part int) string {
+	if part == 0 {
+		return GetGatewayWorkpaceConfigMapName(workspaceID)
+	}
+	return fmt.Sprintf("%s.%d", GetGatewayWorkpaceConfigMapName(workspaceID), part)
+}
+
 func GetLabelsForComponent(router *v1alpha1.CheManager, component string) map[string]string {
 	return GetLabelsFromNames(router.Name, component)
 }
 
+// GetCachedConfigMapsSelector returns the selector of the config maps the operator needs to cache. These are all
+// the config maps the operator manages: the gateway configuration, including the configuration of the workspaces,
+// and the error pages.
+func GetCachedConfigMapsSelector() labels.Selector {
+	requirement, err := labels.NewRequirement(componentLabel, selection.In, []string{"gateway-config", "error-pages"})
+	if err != nil {
+		// the requirement is constant, so this can only happen due to a programming error
+		panic(err)
+	}
+	return labels.NewSelector().Add(*requirement)
+}
+
+// GetCachedSecretsSelector returns the selector of the secrets the operator needs to cache. These are the secrets
+// that can be referenced from the static configuration of the gateways.
+func GetCachedSecretsSelector() labels.Selector {
+	requirement, err := labels.NewRequirement(GatewayStaticConfigSecretLabel, selection.Exists, nil)
+	if err != nil {
+		panic(err)
+	}
+	return labels.NewSelector().Add(*requirement)
+}
+
+// GetWorkspaceGatewayConfigSelector returns the selector of the config maps with the gateway configuration of
+// the workspaces of all the che managers.
+func GetWorkspaceGatewayConfigSelector() labels.Selector {
+	gatewayConfig, err := labels.NewRequirement(componentLabel, selection.Equals, []string{"gateway-config"})
+	if err != nil {
+		panic(err)
+	}
+
+	workspaceID, err := labels.NewRequirement(config.WorkspaceIDLabel, selection.Exists, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	return labels.NewSelector().Add(*gatewayConfig, *workspaceID)
+}
+
 func GetLabelsFromNames(appName string, component string) map[string]string {
 	return map[string]string{
-		"app.kubernetes.io/name":      appName,
-		"app.kubernetes.io/part-of":   appName,
-		"app.kubernetes.io/component": component,
+		appNameLabel:                appName,
+		"app.kubernetes.io/part-of": appName,
+		componentLabel:              component,
 	}
 }
 
+// GetAppNameFromLabels returns the name of the che manager the object with the given labels belongs to, as set
+// by GetLabelsForComponent.
+func GetAppNameFromLabels(labels map[string]string) string {
+	return labels[appNameLabel]
+}
+
 func GetGatewayImage() string {
 	return read(gatewayImageEnvVarName, defaultGatewayImage)
 }
@@ -50,6 +143,40 @@ func GetGatewayConfigurerImage() string {
 	return read(gatewayConfigurerImageEnvVarName, defaultGatewayConfigurerImage)
 }
 
+func GetGatewayErrorPagesImage() string {
+	return read(gatewayErrorPagesImageEnvVarName, defaultGatewayErrorPagesImage)
+}
+
+// GetGatewayEnvoyImage returns the image of the gateway implemented by Envoy.
+func GetGatewayEnvoyImage() string {
+	return read(gatewayEnvoyImageEnvVarName, defaultGatewayEnvoyImage)
+}
+
+// GetGatewayConfigServerURL returns the base URL of the gateway config server of the operator. The gateways run
+// in the namespaces of the che managers, so the default URL is fully qualified using the namespace of the operator.
+func GetGatewayConfigServerURL() string {
+	if url := os.Getenv(gatewayConfigServerURLEnvVarName); url != "" {
+		return url
+	}
+	return fmt.Sprintf(defaultGatewayConfigServerURLPattern, getOperatorNamespace())
+}
+
+// getOperatorNamespace returns the namespace the operator runs in, as read from the service account of its pod.
+func getOperatorNamespace() string {
+	ns, err := ioutil.ReadFile(operatorNamespaceFile)
+	if err != nil || len(strings.TrimSpace(string(ns))) == 0 {
+		log.Info("Failed to detect the namespace of the operator. Will use the hardcoded default value.", "value", defaultOperatorNamespace)
+		return defaultOperatorNamespace
+	}
+	return strings.TrimSpace(string(ns))
+}
+
+// GetGatewayConfigEndpoint returns the URL on which the operator serves the dynamic configuration of the gateway
+// of the provided che manager.
+func GetGatewayConfigEndpoint(manager *v1alpha1.CheManager) string {
+	return GetGatewayConfigServerURL() + GatewayConfigPathPrefix + manager.Namespace + "/" + manager.Name
+}
+
 func read(varName string, fallback string) string {
 	ret := os.Getenv(varName)
 
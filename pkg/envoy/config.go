@@ -0,0 +1,340 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+// Package envoy contains the subset of the Envoy v3 configuration model used by the Envoy implementation of
+// the gateway. The types serialize to the JSON (and YAML) representation of the corresponding Envoy protobuf
+// messages, so that we don't have to depend on the Envoy Go API.
+package envoy
+
+const (
+	ListenerTypeURL              = "type.googleapis.com/envoy.config.listener.v3.Listener"
+	ClusterTypeURL               = "type.googleapis.com/envoy.config.cluster.v3.Cluster"
+	HTTPConnectionManagerTypeURL = "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager"
+	BufferTypeURL                = "type.googleapis.com/envoy.extensions.filters.http.buffer.v3.Buffer"
+	BufferPerRouteTypeURL        = "type.googleapis.com/envoy.extensions.filters.http.buffer.v3.BufferPerRoute"
+	StdoutAccessLogTypeURL       = "type.googleapis.com/envoy.extensions.access_loggers.stream.v3.StdoutAccessLog"
+
+	HTTPConnectionManagerFilterName = "envoy.filters.network.http_connection_manager"
+	RouterFilterName                = "envoy.filters.http.router"
+	CORSFilterName                  = "envoy.filters.http.cors"
+	BufferFilterName                = "envoy.filters.http.buffer"
+	StdoutAccessLogName             = "envoy.access_loggers.stdout"
+)
+
+type Bootstrap struct {
+	Node             Node              `json:"node"`
+	Admin            Admin             `json:"admin"`
+	StaticResources  StaticResources   `json:"static_resources"`
+	DynamicResources *DynamicResources `json:"dynamic_resources,omitempty"`
+}
+
+type Node struct {
+	ID       string            `json:"id"`
+	Cluster  string            `json:"cluster"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type Admin struct {
+	AccessLogPath string  `json:"access_log_path"`
+	Address       Address `json:"address"`
+}
+
+type StaticResources struct {
+	Listeners []Listener `json:"listeners,omitempty"`
+	Clusters  []Cluster  `json:"clusters,omitempty"`
+}
+
+type DynamicResources struct {
+	LDSConfig ConfigSource `json:"lds_config"`
+	CDSConfig ConfigSource `json:"cds_config"`
+}
+
+type ConfigSource struct {
+	ResourceAPIVersion string          `json:"resource_api_version"`
+	APIConfigSource    APIConfigSource `json:"api_config_source"`
+}
+
+type APIConfigSource struct {
+	APIType             string   `json:"api_type"`
+	TransportAPIVersion string   `json:"transport_api_version"`
+	ClusterNames        []string `json:"cluster_names"`
+	RefreshDelay        string   `json:"refresh_delay"`
+}
+
+type Address struct {
+	SocketAddress SocketAddress `json:"socket_address"`
+}
+
+type SocketAddress struct {
+	Address   string `json:"address"`
+	PortValue int    `json:"port_value"`
+}
+
+type Listener struct {
+	// Type is only set when the listener is sent in a discovery response
+	Type         string        `json:"@type,omitempty"`
+	Name         string        `json:"name"`
+	Address      Address       `json:"address"`
+	FilterChains []FilterChain `json:"filter_chains"`
+}
+
+type FilterChain struct {
+	Filters []Filter `json:"filters"`
+}
+
+type Filter struct {
+	Name        string                 `json:"name"`
+	TypedConfig *HTTPConnectionManager `json:"typed_config"`
+}
+
+type HTTPConnectionManager struct {
+	Type             string             `json:"@type"`
+	StatPrefix       string             `json:"stat_prefix"`
+	RouteConfig      RouteConfiguration `json:"route_config"`
+	HTTPFilters      []HTTPFilter       `json:"http_filters"`
+	AccessLog        []AccessLog        `json:"access_log,omitempty"`
+	UpgradeConfigs   []UpgradeConfig    `json:"upgrade_configs,omitempty"`
+	LocalReplyConfig *LocalReplyConfig  `json:"local_reply_config,omitempty"`
+}
+
+type HTTPFilter struct {
+	Name        string                 `json:"name"`
+	TypedConfig map[string]interface{} `json:"typed_config,omitempty"`
+}
+
+type AccessLog struct {
+	Name        string                 `json:"name"`
+	TypedConfig map[string]interface{} `json:"typed_config"`
+}
+
+type UpgradeConfig struct {
+	UpgradeType string `json:"upgrade_type"`
+}
+
+type LocalReplyConfig struct {
+	Mappers []ResponseMapper `json:"mappers"`
+}
+
+type ResponseMapper struct {
+	Filter             AccessLogFilter           `json:"filter"`
+	Body               *DataSource               `json:"body,omitempty"`
+	BodyFormatOverride *SubstitutionFormatString `json:"body_format_override,omitempty"`
+}
+
+type AccessLogFilter struct {
+	StatusCodeFilter StatusCodeFilter `json:"status_code_filter"`
+}
+
+type StatusCodeFilter struct {
+	Comparison ComparisonFilter `json:"comparison"`
+}
+
+type ComparisonFilter struct {
+	Op    string        `json:"op"`
+	Value RuntimeUInt32 `json:"value"`
+}
+
+type RuntimeUInt32 struct {
+	DefaultValue int    `json:"default_value"`
+	RuntimeKey   string `json:"runtime_key"`
+}
+
+type SubstitutionFormatString struct {
+	TextFormat  string `json:"text_format"`
+	ContentType string `json:"content_type"`
+}
+
+type DataSource struct {
+	InlineString string `json:"inline_string"`
+}
+
+type RouteConfiguration struct {
+	Name         string        `json:"name"`
+	VirtualHosts []VirtualHost `json:"virtual_hosts"`
+}
+
+type VirtualHost struct {
+	Name    string   `json:"name"`
+	Domains []string `json:"domains"`
+	Routes  []Route  `json:"routes"`
+}
+
+type Route struct {
+	Name                   string                 `json:"name,omitempty"`
+	Match                  RouteMatch             `json:"match"`
+	Route                  *RouteAction           `json:"route,omitempty"`
+	DirectResponse         *DirectResponseAction  `json:"direct_response,omitempty"`
+	RequestHeadersToAdd    []HeaderValueOption    `json:"request_headers_to_add,omitempty"`
+	RequestHeadersToRemove []string               `json:"request_headers_to_remove,omitempty"`
+	ResponseHeadersToAdd   []HeaderValueOption    `json:"response_headers_to_add,omitempty"`
+	TypedPerFilterConfig   map[string]interface{} `json:"typed_per_filter_config,omitempty"`
+}
+
+type RouteMatch struct {
+	Prefix string `json:"prefix,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
+type RouteAction struct {
+	Cluster       string       `json:"cluster"`
+	PrefixRewrite string       `json:"prefix_rewrite,omitempty"`
+	Timeout       string       `json:"timeout,omitempty"`
+	RetryPolicy   *RetryPolicy `json:"retry_policy,omitempty"`
+	CORS          *CORSPolicy  `json:"cors,omitempty"`
+}
+
+type RetryPolicy struct {
+	RetryOn    string `json:"retry_on"`
+	NumRetries int    `json:"num_retries"`
+}
+
+type CORSPolicy struct {
+	AllowOriginStringMatch []StringMatcher `json:"allow_origin_string_match,omitempty"`
+	AllowMethods           string          `json:"allow_methods,omitempty"`
+	AllowHeaders           string          `json:"allow_headers,omitempty"`
+	AllowCredentials       bool            `json:"allow_credentials,omitempty"`
+	MaxAge                 string          `json:"max_age,omitempty"`
+}
+
+type StringMatcher struct {
+	Exact     string        `json:"exact,omitempty"`
+	SafeRegex *RegexMatcher `json:"safe_regex,omitempty"`
+}
+
+type RegexMatcher struct {
+	GoogleRE2 map[string]interface{} `json:"google_re2"`
+	Regex     string                 `json:"regex"`
+}
+
+type HeaderValueOption struct {
+	Header HeaderValue `json:"header"`
+	Append bool        `json:"append"`
+}
+
+type HeaderValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type DirectResponseAction struct {
+	Status int         `json:"status"`
+	Body   *DataSource `json:"body,omitempty"`
+}
+
+type Cluster struct {
+	// Type is only set when the cluster is sent in a discovery response
+	Type           string                `json:"@type,omitempty"`
+	Name           string                `json:"name"`
+	ConnectTimeout string                `json:"connect_timeout"`
+	ClusterType    string                `json:"type"`
+	LoadAssignment ClusterLoadAssignment `json:"load_assignment"`
+	HealthChecks   []HealthCheck         `json:"health_checks,omitempty"`
+}
+
+type ClusterLoadAssignment struct {
+	ClusterName string                `json:"cluster_name"`
+	Endpoints   []LocalityLbEndpoints `json:"endpoints"`
+}
+
+type LocalityLbEndpoints struct {
+	LbEndpoints []LbEndpoint `json:"lb_endpoints"`
+}
+
+type LbEndpoint struct {
+	Endpoint Endpoint `json:"endpoint"`
+}
+
+type Endpoint struct {
+	Address Address `json:"address"`
+}
+
+type HealthCheck struct {
+	Timeout            string          `json:"timeout"`
+	Interval           string          `json:"interval"`
+	HealthyThreshold   int             `json:"healthy_threshold"`
+	UnhealthyThreshold int             `json:"unhealthy_threshold"`
+	HTTPHealthCheck    HTTPHealthCheck `json:"http_health_check"`
+}
+
+type HTTPHealthCheck struct {
+	Path string `json:"path"`
+}
+
+// DiscoveryRequest is the request of the REST variant of the xDS API.
+type DiscoveryRequest struct {
+	VersionInfo string `json:"version_info,omitempty"`
+	Node        Node   `json:"node"`
+	TypeURL     string `json:"type_url,omitempty"`
+}
+
+// DiscoveryResponse is the response of the REST variant of the xDS API.
+type DiscoveryResponse struct {
+	VersionInfo string        `json:"version_info"`
+	Resources   []interface{} `json:"resources"`
+	TypeURL     string        `json:"type_url"`
+}
+
+// NewCluster returns a cluster of the given type with a single endpoint.
+func NewCluster(name string, clusterType string, host string, port int) Cluster {
+	return Cluster{
+		Name:           name,
+		ConnectTimeout: "5s",
+		ClusterType:    clusterType,
+		LoadAssignment: ClusterLoadAssignment{
+			ClusterName: name,
+			Endpoints: []LocalityLbEndpoints{
+				{
+					LbEndpoints: []LbEndpoint{
+						{
+							Endpoint: Endpoint{
+								Address: NewAddress(host, port),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// NewAddress returns the socket address with the given host and port.
+func NewAddress(host string, port int) Address {
+	return Address{
+		SocketAddress: SocketAddress{
+			Address:   host,
+			PortValue: port,
+		},
+	}
+}
+
+// NewHTTPListener returns a listener on the given port handling the HTTP requests using the provided routes.
+func NewHTTPListener(name string, port int, hcm *HTTPConnectionManager) Listener {
+	hcm.Type = HTTPConnectionManagerTypeURL
+	if hcm.StatPrefix == "" {
+		hcm.StatPrefix = name
+	}
+
+	return Listener{
+		Name:    name,
+		Address: NewAddress("0.0.0.0", port),
+		FilterChains: []FilterChain{
+			{
+				Filters: []Filter{
+					{
+						Name:        HTTPConnectionManagerFilterName,
+						TypedConfig: hcm,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,226 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+// Package filteredcache provides the cache of the operator manager that only caches the config maps and secrets
+// selected by the operator. The clusters can contain a huge number of config maps and secrets the operator is not
+// interested in and caching all of them makes the memory consumption and the initial sync time of the operator
+// grow with the size of the cluster.
+package filteredcache
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	configMapGVK = corev1.SchemeGroupVersion.WithKind("ConfigMap")
+	secretGVK    = corev1.SchemeGroupVersion.WithKind("Secret")
+
+	defaultResync = 10 * time.Hour
+)
+
+// NewCacheFunc returns the function creating the cache that only caches the config maps and secrets matching
+// the provided selectors. All the other objects are cached as usual.
+func NewCacheFunc(configMapSelector labels.Selector, secretSelector labels.Selector) cache.NewCacheFunc {
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		delegate, err := cache.New(config, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		clientset, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, err
+		}
+
+		configMaps := &toolscache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.LabelSelector = configMapSelector.String()
+				return clientset.CoreV1().ConfigMaps(opts.Namespace).List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.LabelSelector = configMapSelector.String()
+				return clientset.CoreV1().ConfigMaps(opts.Namespace).Watch(context.TODO(), options)
+			},
+		}
+
+		secrets := &toolscache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.LabelSelector = secretSelector.String()
+				return clientset.CoreV1().Secrets(opts.Namespace).List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.LabelSelector = secretSelector.String()
+				return clientset.CoreV1().Secrets(opts.Namespace).Watch(context.TODO(), options)
+			},
+		}
+
+		resync := defaultResync
+		if opts.Resync != nil {
+			resync = *opts.Resync
+		}
+
+		return newFilteredCache(delegate, configMaps, secrets, resync), nil
+	}
+}
+
+type filteredCache struct {
+	cache.Cache
+	configMaps toolscache.SharedIndexInformer
+	secrets    toolscache.SharedIndexInformer
+}
+
+var _ cache.Cache = (*filteredCache)(nil)
+
+func newFilteredCache(delegate cache.Cache, configMaps toolscache.ListerWatcher, secrets toolscache.ListerWatcher, resync time.Duration) *filteredCache {
+	indexers := toolscache.Indexers{
+		toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc,
+	}
+
+	return &filteredCache{
+		Cache:      delegate,
+		configMaps: toolscache.NewSharedIndexInformer(configMaps, &corev1.ConfigMap{}, resync, indexers),
+		secrets:    toolscache.NewSharedIndexInformer(secrets, &corev1.Secret{}, resync, indexers),
+	}
+}
+
+// informerFor returns the filtered informer of the type of the provided object or list, or nil if the type
+// is not filtered.
+func (c *filteredCache) informerFor(obj runtime.Object) (toolscache.SharedIndexInformer, schema.GroupVersionKind, string) {
+	switch obj.(type) {
+	case *corev1.ConfigMap, *corev1.ConfigMapList:
+		return c.configMaps, configMapGVK, "configmaps"
+	case *corev1.Secret, *corev1.SecretList:
+		return c.secrets, secretGVK, "secrets"
+	}
+	return nil, schema.GroupVersionKind{}, ""
+}
+
+func (c *filteredCache) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	informer, gvk, resource := c.informerFor(obj)
+	if informer == nil {
+		return c.Cache.Get(ctx, key, obj)
+	}
+
+	item, exists, err := informer.GetIndexer().GetByKey(key.String())
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return errors.NewNotFound(schema.GroupResource{Resource: resource}, key.Name)
+	}
+
+	reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(item.(runtime.Object).DeepCopyObject()).Elem())
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+
+	return nil
+}
+
+func (c *filteredCache) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	informer, _, resource := c.informerFor(list)
+	if informer == nil {
+		return c.Cache.List(ctx, list, opts...)
+	}
+
+	listOpts := client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+
+	if listOpts.FieldSelector != nil {
+		return fmt.Errorf("the field selectors are not supported when listing the %s", resource)
+	}
+
+	var items []interface{}
+	if listOpts.Namespace != "" {
+		var err error
+		if items, err = informer.GetIndexer().ByIndex(toolscache.NamespaceIndex, listOpts.Namespace); err != nil {
+			return err
+		}
+	} else {
+		items = informer.GetIndexer().List()
+	}
+
+	matches := func(obj metav1.Object) bool {
+		return listOpts.LabelSelector == nil || listOpts.LabelSelector.Matches(labels.Set(obj.GetLabels()))
+	}
+
+	switch l := list.(type) {
+	case *corev1.ConfigMapList:
+		l.Items = make([]corev1.ConfigMap, 0, len(items))
+		for _, item := range items {
+			if cm := item.(*corev1.ConfigMap); matches(cm) {
+				l.Items = append(l.Items, *cm.DeepCopy())
+			}
+		}
+	case *corev1.SecretList:
+		l.Items = make([]corev1.Secret, 0, len(items))
+		for _, item := range items {
+			if secret := item.(*corev1.Secret); matches(secret) {
+				l.Items = append(l.Items, *secret.DeepCopy())
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *filteredCache) GetInformer(ctx context.Context, obj runtime.Object) (cache.Informer, error) {
+	if informer, _, _ := c.informerFor(obj); informer != nil {
+		return informer, nil
+	}
+	return c.Cache.GetInformer(ctx, obj)
+}
+
+func (c *filteredCache) GetInformerForKind(ctx context.Context, gvk schema.GroupVersionKind) (cache.Informer, error) {
+	switch gvk {
+	case configMapGVK:
+		return c.configMaps, nil
+	case secretGVK:
+		return c.secrets, nil
+	}
+	return c.Cache.GetInformerForKind(ctx, gvk)
+}
+
+func (c *filteredCache) Start(stop <-chan struct{}) error {
+	go c.configMaps.Run(stop)
+	go c.secrets.Run(stop)
+	return c.Cache.Start(stop)
+}
+
+func (c *filteredCache) WaitForCacheSync(stop <-chan struct{}) bool {
+	if !toolscache.WaitForCacheSync(stop, c.configMaps.HasSynced, c.secrets.HasSynced) {
+		return false
+	}
+	return c.Cache.WaitForCacheSync(stop)
+}
+
+func (c *filteredCache) IndexField(ctx context.Context, obj runtime.Object, field string, extractValue client.IndexerFunc) error {
+	if informer, _, resource := c.informerFor(obj); informer != nil {
+		return fmt.Errorf("the field indices are not supported on the %s", resource)
+	}
+	return c.Cache.IndexField(ctx, obj, field, extractValue)
+}
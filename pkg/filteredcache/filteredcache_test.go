@@ -0,0 +1,203 @@
+package filteredcache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func configMaps(managed int, unrelated int) []runtime.Object {
+	ret := []runtime.Object{}
+	for i := 0; i < managed; i++ {
+		ret = append(ret, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("workspace%d", i),
+				Namespace: "che",
+				Labels:    defaults.GetLabelsFromNames("che", "gateway-config"),
+			},
+			Data: map[string]string{"workspace.yml": "http: {}"},
+		})
+	}
+	for i := 0; i < unrelated; i++ {
+		ret = append(ret, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("unrelated%d", i),
+				Namespace: fmt.Sprintf("ns%d", i%100),
+				Labels:    map[string]string{"app": "unrelated"},
+			},
+			Data: map[string]string{"data": "some data of a different application"},
+		})
+	}
+	return ret
+}
+
+func secrets(selected int, unrelated int) []runtime.Object {
+	ret := []runtime.Object{}
+	for i := 0; i < selected; i++ {
+		ret = append(ret, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("certs%d", i),
+				Namespace: "che",
+				Labels:    map[string]string{defaults.GatewayStaticConfigSecretLabel: "true"},
+			},
+			Data: map[string][]byte{"tls.crt": []byte("cert")},
+		})
+	}
+	for i := 0; i < unrelated; i++ {
+		ret = append(ret, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("unrelated%d", i),
+				Namespace: "che",
+			},
+			Data: map[string][]byte{"password": []byte("secret")},
+		})
+	}
+	return ret
+}
+
+func listWatch(clientset kubernetes.Interface, selector labels.Selector) toolscache.ListerWatcher {
+	return &toolscache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = selector.String()
+			return clientset.CoreV1().ConfigMaps("").List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = selector.String()
+			return clientset.CoreV1().ConfigMaps("").Watch(context.TODO(), options)
+		},
+	}
+}
+
+func secretsListWatch(clientset kubernetes.Interface, selector labels.Selector) toolscache.ListerWatcher {
+	return &toolscache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = selector.String()
+			return clientset.CoreV1().Secrets("").List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = selector.String()
+			return clientset.CoreV1().Secrets("").Watch(context.TODO(), options)
+		},
+	}
+}
+
+func startCache(t testing.TB, clientset kubernetes.Interface, selector labels.Selector) (*filteredCache, chan struct{}) {
+	c := newFilteredCache(&informertest.FakeInformers{}, listWatch(clientset, selector), secretsListWatch(clientset, defaults.GetCachedSecretsSelector()), time.Hour)
+
+	stop := make(chan struct{})
+	go func() {
+		_ = c.Start(stop)
+	}()
+
+	if !c.WaitForCacheSync(stop) {
+		t.Fatal("The cache failed to sync")
+	}
+
+	return c, stop
+}
+
+func TestOnlySelectedConfigMapsAreCached(t *testing.T) {
+	c, stop := startCache(t, fake.NewSimpleClientset(configMaps(2, 3)...), defaults.GetCachedConfigMapsSelector())
+	defer close(stop)
+
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(context.TODO(), client.ObjectKey{Name: "workspace0", Namespace: "che"}, cm); err != nil {
+		t.Fatalf("The managed config map should have been found: %s", err)
+	}
+	if cm.Data["workspace.yml"] != "http: {}" {
+		t.Errorf("Unexpected data of the config map: %v", cm.Data)
+	}
+
+	if err := c.Get(context.TODO(), client.ObjectKey{Name: "unrelated0", Namespace: "ns0"}, cm); !errors.IsNotFound(err) {
+		t.Errorf("The unrelated config map should not be cached but got: %v", err)
+	}
+
+	list := &corev1.ConfigMapList{}
+	if err := c.List(context.TODO(), list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 2 {
+		t.Errorf("Only the 2 managed config maps should have been listed but got %d", len(list.Items))
+	}
+
+	if err := c.List(context.TODO(), list, client.InNamespace("che"), client.MatchingLabels{"app.kubernetes.io/component": "error-pages"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 0 {
+		t.Errorf("The label selector of the list should have been applied but got %d config maps", len(list.Items))
+	}
+
+	if informer, err := c.GetInformer(context.TODO(), &corev1.ConfigMap{}); err != nil || informer != c.configMaps {
+		t.Errorf("The filtered informer should be used for the config maps but got: %v, %v", informer, err)
+	}
+}
+
+func TestOnlySelectedSecretsAreCached(t *testing.T) {
+	c, stop := startCache(t, fake.NewSimpleClientset(secrets(1, 2)...), defaults.GetCachedConfigMapsSelector())
+	defer close(stop)
+
+	secret := &corev1.Secret{}
+	if err := c.Get(context.TODO(), client.ObjectKey{Name: "certs0", Namespace: "che"}, secret); err != nil {
+		t.Fatalf("The labeled secret should have been found: %s", err)
+	}
+	if string(secret.Data["tls.crt"]) != "cert" {
+		t.Errorf("Unexpected data of the secret: %v", secret.Data)
+	}
+
+	if err := c.Get(context.TODO(), client.ObjectKey{Name: "unrelated0", Namespace: "che"}, secret); !errors.IsNotFound(err) {
+		t.Errorf("The unlabeled secret should not be cached but got: %v", err)
+	}
+
+	list := &corev1.SecretList{}
+	if err := c.List(context.TODO(), list, client.InNamespace("che")); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 {
+		t.Errorf("Only the labeled secret should have been listed but got %d", len(list.Items))
+	}
+
+	if informer, err := c.GetInformer(context.TODO(), &corev1.Secret{}); err != nil || informer != c.secrets {
+		t.Errorf("The filtered informer should be used for the secrets but got: %v, %v", informer, err)
+	}
+}
+
+// BenchmarkConfigMapCacheSync compares the initial sync of the cache of the config maps with and without
+// the selector in a cluster where the operator manages just a small fraction of the config maps.
+func BenchmarkConfigMapCacheSync(b *testing.B) {
+	objs := configMaps(100, 10000)
+
+	for _, bm := range []struct {
+		name     string
+		selector labels.Selector
+	}{
+		{"unfiltered", labels.Everything()},
+		{"filtered", defaults.GetCachedConfigMapsSelector()},
+	} {
+		selector := bm.selector
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				clientset := fake.NewSimpleClientset(objs...)
+				b.StartTimer()
+
+				_, stop := startCache(b, clientset, selector)
+				close(stop)
+			}
+		})
+	}
+}
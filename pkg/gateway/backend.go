@@ -0,0 +1,168 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package gateway
+
+import (
+	"fmt"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Backend is the implementation of the gateway server. It defines the static configuration of the server and
+// the containers of the gateway pod. The objects common to all the implementations, like the service, the ingress
+// or the route, are managed by the CheGateway. The dynamic configuration of the workspaces is rendered by
+// the routing solver.
+type Backend interface {
+	// Validate returns an error if the che manager uses some gateway settings the implementation doesn't support.
+	Validate(manager *v1alpha1.CheManager) error
+
+	// StaticConfig returns the data of the config map with the static configuration of the server. The config map
+	// is mounted into the gateway container. It must not contain the config server token, the containers get
+	// the token from its secret, see getConfigServerTokenEnv.
+	StaticConfig(manager *v1alpha1.CheManager) (map[string]string, error)
+
+	// UsesErrorPages returns true if the implementation serves the error pages using the error pages backend.
+	UsesErrorPages() bool
+
+	// Containers returns the containers of the gateway pod. The additional mounts need to be mounted into
+	// the gateway container.
+	Containers(manager *v1alpha1.CheManager, additionalMounts []corev1.VolumeMount) []corev1.Container
+
+	// Volumes returns the volumes required by the containers, apart from the static configuration config map
+	// which is always available as the "static-config" volume.
+	Volumes(manager *v1alpha1.CheManager) []corev1.Volume
+}
+
+// GetBackend returns the implementation of the gateway configured in the che manager.
+func GetBackend(manager *v1alpha1.CheManager) Backend {
+	if manager.Spec.Gateway.Implementation == v1alpha1.EnvoyGatewayImplementation {
+		return &envoyBackend{}
+	}
+	return &traefikBackend{}
+}
+
+// UsesEnvoy returns true if the gateway of the che manager is implemented by Envoy.
+func UsesEnvoy(manager *v1alpha1.CheManager) bool {
+	return manager.Spec.Gateway.Implementation == v1alpha1.EnvoyGatewayImplementation
+}
+
+// traefikBackend implements the gateway using Traefik. It supports all the config providers.
+type traefikBackend struct{}
+
+var _ Backend = (*traefikBackend)(nil)
+
+func (b *traefikBackend) Validate(manager *v1alpha1.CheManager) error {
+	return nil
+}
+
+func (b *traefikBackend) StaticConfig(manager *v1alpha1.CheManager) (map[string]string, error) {
+	staticConfig, err := getTraefikStaticConfig(manager)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"traefik.yml": staticConfig,
+	}, nil
+}
+
+func (b *traefikBackend) UsesErrorPages() bool {
+	return true
+}
+
+func (b *traefikBackend) Containers(manager *v1alpha1.CheManager, additionalMounts []corev1.VolumeMount) []corev1.Container {
+	gatewayProbes := getGatewayProbes(manager)
+
+	gatewayMounts := []corev1.VolumeMount{
+		{
+			Name:      "static-config",
+			MountPath: "/etc/traefik",
+		},
+	}
+
+	// in the HTTP provider mode, the gateway reads the dynamic configuration directly from the operator
+	if !UsesHTTPConfigProvider(manager) {
+		gatewayMounts = append(gatewayMounts, corev1.VolumeMount{
+			Name:      "dynamic-config",
+			MountPath: "/dynamic-config",
+		})
+	}
+
+	gateway := corev1.Container{
+		Name:            "gateway",
+		Image:           defaults.GetGatewayImage(),
+		ImagePullPolicy: corev1.PullAlways,
+		LivenessProbe:   gatewayProbes.liveness,
+		ReadinessProbe:  gatewayProbes.readiness,
+		StartupProbe:    gatewayProbes.startup,
+		VolumeMounts:    append(gatewayMounts, additionalMounts...),
+	}
+
+	// Traefik can't read its static configuration from more than one source, so the token is put into
+	// the configuration file when the container starts
+	if UsesHTTPConfigProvider(manager) {
+		gateway.Command = []string{"/bin/sh", "-c", fmt.Sprintf(`sed "s/%s/$%s/" /etc/traefik/traefik.yml > %s/traefik.yml && exec traefik --configFile=%s/traefik.yml`,
+			traefikConfigServerTokenPlaceholder, configServerTokenEnvVar, traefikRenderedConfigDir, traefikRenderedConfigDir)}
+		gateway.Env = getConfigServerTokenEnv(manager)
+		gateway.VolumeMounts = append(gateway.VolumeMounts, corev1.VolumeMount{
+			Name:      "rendered-static-config",
+			MountPath: traefikRenderedConfigDir,
+		})
+	}
+
+	containers := []corev1.Container{gateway}
+
+	if !UsesHTTPConfigProvider(manager) && !UsesKubernetesCRDConfigProvider(manager) {
+		containers = append(containers, getConfigbumpContainerSpec(manager))
+	}
+
+	return append(containers, getErrorPagesContainerSpec())
+}
+
+func (b *traefikBackend) Volumes(manager *v1alpha1.CheManager) []corev1.Volume {
+	volumes := []corev1.Volume{}
+
+	// In the Kubernetes CRD provider mode, only the gateway-wide routes are read from the files and they're
+	// mounted directly from their configmap. In the config maps mode, the configuration is synced into the volume
+	// by the configbump sidecar.
+	if UsesKubernetesCRDConfigProvider(manager) {
+		volumes = append(volumes, corev1.Volume{
+			Name: "dynamic-config",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: getGatewayRoutesConfigMapName(manager),
+					},
+				},
+			},
+		})
+	} else if !UsesHTTPConfigProvider(manager) {
+		volumes = append(volumes, corev1.Volume{
+			Name: "dynamic-config",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		})
+	} else {
+		volumes = append(volumes, corev1.Volume{
+			Name: "rendered-static-config",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		})
+	}
+
+	return append(volumes, getErrorPagesVolumesSpec(manager)...)
+}
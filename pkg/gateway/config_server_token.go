@@ -0,0 +1,139 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/sync"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// ConfigServerTokenQueryParam is the query parameter in which the Traefik gateways send the token to
+	// the config server.
+	ConfigServerTokenQueryParam = "token"
+
+	// ConfigServerTokenMetadataKey is the key of the node metadata in which the Envoy gateways send the token to
+	// the config server.
+	ConfigServerTokenMetadataKey = "token"
+
+	configServerTokenKey = "token"
+
+	// the environment variable of the gateway container with the token
+	configServerTokenEnvVar = "CONFIG_SERVER_TOKEN"
+
+	// the placeholder for the token in the static configuration of the Traefik gateways and the directory into which
+	// the configuration with the actual token is written when the gateway container starts
+	traefikConfigServerTokenPlaceholder = "__CONFIG_SERVER_TOKEN__"
+	traefikRenderedConfigDir            = "/var/run/traefik"
+)
+
+// getConfigServerTokenSecretName returns the name of the secret with the token the gateway of the che manager uses
+// to authenticate to the config server.
+func getConfigServerTokenSecretName(managerName string) string {
+	return managerName + "-config-server-token"
+}
+
+// GetConfigServerToken returns the token the gateway of the che manager needs to send to the config server or
+// an empty string if there is no token for the che manager.
+func GetConfigServerToken(ctx context.Context, cl client.Reader, manager client.ObjectKey) (string, error) {
+	secret := corev1.Secret{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: getConfigServerTokenSecretName(manager.Name), Namespace: manager.Namespace}, &secret); err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return string(secret.Data[configServerTokenKey]), nil
+}
+
+// getConfigServerTokenEnv returns the environment of the gateway container with the config server token read from
+// its secret, so that the token doesn't need to be part of the static configuration of the gateway.
+func getConfigServerTokenEnv(manager *v1alpha1.CheManager) []corev1.EnvVar {
+	return []corev1.EnvVar{
+		{
+			Name: configServerTokenEnvVar,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: getConfigServerTokenSecretName(manager.Name),
+					},
+					Key: configServerTokenKey,
+				},
+			},
+		},
+	}
+}
+
+// reconcileConfigServerToken makes sure there is a token for the gateway of the che manager if it obtains its
+// configuration from the config server and returns the secret with it. The token is generated only once, so that
+// the gateway pods don't need to be restarted. The secret with the token is deleted if the gateway doesn't use
+// the config server. In the dry-run mode, the returned secret is nil if it doesn't exist yet.
+func (g *CheGateway) reconcileConfigServerToken(syncer sync.Syncer, ctx context.Context, manager *v1alpha1.CheManager) (bool, *corev1.Secret, error) {
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getConfigServerTokenSecretName(manager.Name),
+			Namespace: manager.Namespace,
+			Labels:    defaults.GetLabelsForComponent(manager, "gateway-config-token"),
+		},
+	}
+	// the secret needs to be labeled so that it is cached by the operator, see the filteredcache package
+	secret.Labels[defaults.GatewayStaticConfigSecretLabel] = "true"
+
+	if !UsesHTTPConfigProvider(manager) {
+		return false, nil, syncer.Delete(ctx, &secret)
+	}
+
+	existing := corev1.Secret{}
+	err := g.client.Get(ctx, client.ObjectKey{Name: secret.Name, Namespace: secret.Namespace}, &existing)
+	if err == nil {
+		return false, &existing, nil
+	}
+	if !errors.IsNotFound(err) {
+		return false, nil, err
+	}
+
+	if g.dryRun {
+		// the token is only generated when the secret is actually created, so that the gateway objects depending on
+		// it are reported the same way on each reconciliation
+		return true, nil, nil
+	}
+
+	data := make([]byte, 32)
+	if _, err = rand.Read(data); err != nil {
+		return false, nil, fmt.Errorf("failed to generate the config server token of the gateway: %s", err)
+	}
+
+	secret.Data = map[string][]byte{configServerTokenKey: []byte(hex.EncodeToString(data))}
+	if err = controllerutil.SetControllerReference(manager, &secret, g.scheme); err != nil {
+		return false, nil, err
+	}
+
+	if err = g.client.Create(ctx, &secret); err != nil {
+		// if the secret already exists, the cache is just not up to date yet and we need to try again later
+		return false, nil, err
+	}
+
+	return true, &secret, nil
+}
@@ -0,0 +1,345 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/infrastructure"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/sync"
+	"github.com/devfile/devworkspace-operator/pkg/config"
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	rbac "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// IngressRouteGVK is the kind of the Traefik objects with the routers of the workspaces.
+	IngressRouteGVK = schema.GroupVersionKind{
+		Group:   "traefik.containo.us",
+		Version: "v1alpha1",
+		Kind:    "IngressRoute",
+	}
+
+	// MiddlewareGVK is the kind of the Traefik objects with the middlewares of the workspaces.
+	MiddlewareGVK = schema.GroupVersionKind{
+		Group:   "traefik.containo.us",
+		Version: "v1alpha1",
+		Kind:    "Middleware",
+	}
+)
+
+// UsesKubernetesCRDConfigProvider returns true if the gateway of the che manager should obtain the configuration
+// of the workspaces from the Traefik CRDs.
+func UsesKubernetesCRDConfigProvider(manager *v1alpha1.CheManager) bool {
+	return manager.Spec.Gateway.ConfigProvider == v1alpha1.KubernetesCRDConfigProvider
+}
+
+// GetWorkspaceConfigLabels returns the labels that need to be put on the Traefik objects with the configuration
+// of the workspaces so that the gateway of the che manager picks them up.
+func GetWorkspaceConfigLabels(manager *v1alpha1.CheManager) map[string]string {
+	labels := defaults.GetLabelsForComponent(manager, "gateway-config")
+	labels[defaults.GatewayConfigCheNamespaceLabel] = manager.Namespace
+	return labels
+}
+
+// GetWorkspaceTraefikObjectLabels returns the labels of the Traefik objects with the configuration of the single
+// workspace.
+func GetWorkspaceTraefikObjectLabels(manager *v1alpha1.CheManager, workspaceID string) map[string]string {
+	labels := GetWorkspaceConfigLabels(manager)
+	labels[config.WorkspaceIDLabel] = workspaceID
+	return labels
+}
+
+// ListWorkspaceTraefikObjects returns the Traefik objects of the given kind with the configuration of the workspace
+// in the namespace.
+func ListWorkspaceTraefikObjects(ctx context.Context, cl client.Reader, manager *v1alpha1.CheManager, namespace string, workspaceID string, gvk schema.GroupVersionKind) ([]unstructured.Unstructured, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(schema.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind + "List"})
+
+	if err := cl.List(ctx, list, client.InNamespace(namespace), client.MatchingLabels(GetWorkspaceTraefikObjectLabels(manager, workspaceID))); err != nil {
+		return nil, err
+	}
+
+	return list.Items, nil
+}
+
+// DeleteWorkspaceTraefikObjects deletes the IngressRoute and the Middlewares with the configuration of the workspace
+// in the namespace.
+func DeleteWorkspaceTraefikObjects(ctx context.Context, cl client.Reader, syncer *sync.Syncer, manager *v1alpha1.CheManager, namespace string, workspaceID string) error {
+	if !infrastructure.TraefikCRDsAvailable {
+		// there can't be anything to delete
+		return nil
+	}
+
+	for _, gvk := range []schema.GroupVersionKind{IngressRouteGVK, MiddlewareGVK} {
+		objs, err := ListWorkspaceTraefikObjects(ctx, cl, manager, namespace, workspaceID, gvk)
+		if err != nil {
+			return err
+		}
+
+		for i := range objs {
+			if err := syncer.Delete(ctx, &objs[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetErrorPagesServiceName returns the name of the service exposing the error pages backend of the gateway.
+// The service only exists if the gateway uses the Traefik CRDs, because those cannot reference the backend
+// running inside the gateway pod directly.
+func GetErrorPagesServiceName(manager *v1alpha1.CheManager) string {
+	return getErrorPagesConfigMapName(manager)
+}
+
+// GetGatewayWatchedNamespaces returns the sorted namespaces the gateway of the che manager reads the Traefik CRDs
+// from. These are the namespaces of the workspaces and the namespace of the che manager itself, which is always
+// included so that the list is never empty, which would make the gateway watch all the namespaces.
+func GetGatewayWatchedNamespaces(manager *v1alpha1.CheManager) []string {
+	ret := []string{manager.Namespace}
+	for _, ns := range manager.Status.WorkspaceNamespaces {
+		if ns != manager.Namespace {
+			ret = append(ret, ns)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// reconcileKubernetesCRDProvider makes sure the objects needed by the gateway to read the Traefik CRDs exist
+// if the che manager is configured to use them, or that they don't exist otherwise.
+func (g *CheGateway) reconcileKubernetesCRDProvider(syncer sync.Syncer, ctx context.Context, manager *v1alpha1.CheManager) (bool, error) {
+	errorPagesService := getErrorPagesServiceSpec(manager)
+
+	if !UsesKubernetesCRDConfigProvider(manager) {
+		return false, g.deleteKubernetesCRDProviderObjects(syncer, ctx, manager)
+	}
+
+	if !infrastructure.TraefikCRDsAvailable {
+		return false, fmt.Errorf("the che manager '%s' in namespace '%s' is configured to use the Traefik CRDs but they are not installed in the cluster", manager.Name, manager.Namespace)
+	}
+
+	var ret, partial bool
+	var err error
+
+	// the gateway is only granted access to the namespaces it watches, so that it cannot read the secrets in
+	// the rest of the cluster
+	namespaces := GetGatewayWatchedNamespaces(manager)
+	for _, ns := range namespaces {
+		role := getGatewayCRDRoleSpec(manager, ns)
+		roleBinding := getGatewayCRDRoleBindingSpec(manager, ns)
+
+		// the roles outside of the namespace of the che manager cannot be owned by it, so we're cleaning them up
+		// explicitly and the syncer doesn't record the events about them on the che manager
+		if partial, err = g.syncUnowned(syncer, ctx, manager, &role, roleDiffOpts); err != nil {
+			return false, err
+		}
+		ret = ret || partial
+
+		if partial, err = g.syncUnowned(syncer, ctx, manager, &roleBinding, roleBindingDiffOpts); err != nil {
+			return false, err
+		}
+		ret = ret || partial
+	}
+
+	if err = g.deleteGatewayCRDRoles(syncer, ctx, manager, namespaces); err != nil {
+		return false, err
+	}
+
+	// the cluster-wide access was granted by the previous versions of the operator
+	if err = g.deleteGatewayClusterRole(syncer, ctx, manager); err != nil {
+		return false, err
+	}
+
+	if partial, _, err = syncer.Sync(ctx, manager, &errorPagesService, serviceDiffOpts); err != nil {
+		return false, err
+	}
+	ret = ret || partial
+
+	return ret, nil
+}
+
+// syncUnowned syncs the object that cannot be owned by the che manager and records the report about its change on
+// the che manager.
+func (g *CheGateway) syncUnowned(syncer sync.Syncer, ctx context.Context, manager *v1alpha1.CheManager, blueprint metav1.Object, diffOpts cmp.Option) (bool, error) {
+	report, _, err := syncer.SyncAndReport(ctx, nil, blueprint, diffOpts)
+	if err != nil || report == nil {
+		return false, err
+	}
+
+	if g.recorder != nil {
+		g.recorder.Event(manager, corev1.EventTypeNormal, report.EventReason(), report.String())
+	}
+
+	return true, nil
+}
+
+func (g *CheGateway) deleteKubernetesCRDProviderObjects(syncer sync.Syncer, ctx context.Context, manager *v1alpha1.CheManager) error {
+	if err := g.deleteGatewayCRDRoles(syncer, ctx, manager, nil); err != nil {
+		return err
+	}
+
+	if err := g.deleteGatewayClusterRole(syncer, ctx, manager); err != nil {
+		return err
+	}
+
+	errorPagesService := getErrorPagesServiceSpec(manager)
+	return syncer.Delete(ctx, &errorPagesService)
+}
+
+// deleteGatewayCRDRoles deletes the roles and role bindings granting the gateway access to the Traefik CRDs
+// in all the namespaces except the given ones.
+func (g *CheGateway) deleteGatewayCRDRoles(syncer sync.Syncer, ctx context.Context, manager *v1alpha1.CheManager, keep []string) error {
+	kept := map[string]bool{}
+	for _, ns := range keep {
+		kept[ns] = true
+	}
+
+	roleBindings := rbac.RoleBindingList{}
+	if err := g.client.List(ctx, &roleBindings, client.MatchingLabels(getGatewayCRDRoleLabels(manager))); err != nil {
+		return err
+	}
+	for i := range roleBindings.Items {
+		if !kept[roleBindings.Items[i].Namespace] {
+			if err := syncer.Delete(ctx, &roleBindings.Items[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	roles := rbac.RoleList{}
+	if err := g.client.List(ctx, &roles, client.MatchingLabels(getGatewayCRDRoleLabels(manager))); err != nil {
+		return err
+	}
+	for i := range roles.Items {
+		if !kept[roles.Items[i].Namespace] {
+			if err := syncer.Delete(ctx, &roles.Items[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (g *CheGateway) deleteGatewayClusterRole(syncer sync.Syncer, ctx context.Context, manager *v1alpha1.CheManager) error {
+	clusterRoleBinding := rbac.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: getGatewayCRDRoleName(manager)}}
+	if err := syncer.Delete(ctx, &clusterRoleBinding); err != nil {
+		return err
+	}
+
+	clusterRole := rbac.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: getGatewayCRDRoleName(manager)}}
+	return syncer.Delete(ctx, &clusterRole)
+}
+
+func getGatewayCRDRoleName(manager *v1alpha1.CheManager) string {
+	return manager.Namespace + "-" + manager.Name + "-gateway"
+}
+
+func getGatewayCRDRoleLabels(manager *v1alpha1.CheManager) map[string]string {
+	labels := defaults.GetLabelsForComponent(manager, "gateway-crd-access")
+	labels[defaults.GatewayConfigCheNamespaceLabel] = manager.Namespace
+	return labels
+}
+
+func getGatewayCRDRoleSpec(manager *v1alpha1.CheManager, namespace string) rbac.Role {
+	return rbac.Role{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Role",
+			APIVersion: rbac.SchemeGroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getGatewayCRDRoleName(manager),
+			Namespace: namespace,
+			Labels:    getGatewayCRDRoleLabels(manager),
+		},
+		Rules: []rbac.PolicyRule{
+			{
+				Verbs:     []string{"get", "list", "watch"},
+				APIGroups: []string{"traefik.containo.us"},
+				Resources: []string{"ingressroutes", "ingressroutetcps", "ingressrouteudps", "middlewares", "tlsoptions", "tlsstores", "traefikservices"},
+			},
+			{
+				// the provider watches the secrets, too, because the Traefik objects can reference them
+				Verbs:     []string{"get", "list", "watch"},
+				APIGroups: []string{""},
+				Resources: []string{"services", "endpoints", "secrets"},
+			},
+		},
+	}
+}
+
+func getGatewayCRDRoleBindingSpec(manager *v1alpha1.CheManager, namespace string) rbac.RoleBinding {
+	return rbac.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "RoleBinding",
+			APIVersion: rbac.SchemeGroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getGatewayCRDRoleName(manager),
+			Namespace: namespace,
+			Labels:    getGatewayCRDRoleLabels(manager),
+		},
+		RoleRef: rbac.RoleRef{
+			Name:     getGatewayCRDRoleName(manager),
+			Kind:     "Role",
+			APIGroup: "rbac.authorization.k8s.io",
+		},
+		Subjects: []rbac.Subject{
+			{
+				Kind:      "ServiceAccount",
+				Name:      manager.Name,
+				Namespace: manager.Namespace,
+			},
+		},
+	}
+}
+
+func getErrorPagesServiceSpec(manager *v1alpha1.CheManager) corev1.Service {
+	return corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Service",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      GetErrorPagesServiceName(manager),
+			Namespace: manager.Namespace,
+			Labels:    defaults.GetLabelsForComponent(manager, "error-pages"),
+		},
+		Spec: corev1.ServiceSpec{
+			Selector:        defaults.GetLabelsForComponent(manager, "deployment"),
+			SessionAffinity: corev1.ServiceAffinityNone,
+			Type:            corev1.ServiceTypeClusterIP,
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "error-pages",
+					Port:       int32(ErrorPagesPort),
+					Protocol:   corev1.ProtocolTCP,
+					TargetPort: intstr.FromInt(ErrorPagesPort),
+				},
+			},
+		},
+	}
+}
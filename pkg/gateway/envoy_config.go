@@ -0,0 +1,244 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package gateway
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/envoy"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// the admin interface is only reachable from within the pod, the health checks and the metrics are exposed
+	// through the dedicated listeners
+	envoyAdminPort = 9901
+
+	envoyAdminClusterName        = "admin"
+	envoyConfigServerClusterName = "che-config-server"
+	envoyStaticConfigKey         = "envoy.yaml"
+
+	// the JSON format of the log messages of Envoy itself, the access log has its own format
+	envoyJSONLogFormat = `{"time":"%Y-%m-%dT%T.%eZ","level":"%l","logger":"%n","message":"%j"}`
+)
+
+// envoyBackend implements the gateway using Envoy. Envoy obtains the configuration of the workspaces from
+// the gateway config server of the operator using the REST variant of the xDS API. The bootstrap configuration
+// only contains the listeners for the health checks and the metrics.
+type envoyBackend struct{}
+
+var _ Backend = (*envoyBackend)(nil)
+
+// GetEnvoyNodeID returns the ID of the Envoy node of the gateway of the che manager. The gateway config server
+// uses the ID to find the configuration for the gateway.
+func GetEnvoyNodeID(manager *v1alpha1.CheManager) string {
+	return manager.Namespace + "/" + manager.Name
+}
+
+func (b *envoyBackend) Validate(manager *v1alpha1.CheManager) error {
+	if !UsesHTTPConfigProvider(manager) {
+		return fmt.Errorf("the envoy gateway requires the '%s' config provider", v1alpha1.HTTPConfigProvider)
+	}
+
+	settings := manager.Spec.Gateway
+	unsupported := []string{}
+
+	if settings.Tracing != nil {
+		unsupported = append(unsupported, "gateway.tracing")
+	}
+	if settings.Timeouts != nil {
+		unsupported = append(unsupported, "gateway.timeouts")
+	}
+	if len(settings.TrustedForwardedHeadersIPs) > 0 {
+		unsupported = append(unsupported, "gateway.trustedForwardedHeadersIPs")
+	}
+	if settings.InsecureForwardedHeaders {
+		unsupported = append(unsupported, "gateway.insecureForwardedHeaders")
+	}
+	if manager.Spec.WorkspaceBackends != nil && manager.Spec.WorkspaceBackends.CircuitBreakerExpression != "" {
+		unsupported = append(unsupported, "workspaceBackends.circuitBreakerExpression")
+	}
+	if manager.Spec.ErrorPagesConfigMap != "" {
+		unsupported = append(unsupported, "errorPagesConfigMap")
+	}
+
+	if len(unsupported) > 0 {
+		return fmt.Errorf("the envoy gateway doesn't support the following settings: %s", strings.Join(unsupported, ", "))
+	}
+
+	return nil
+}
+
+func (b *envoyBackend) StaticConfig(manager *v1alpha1.CheManager) (map[string]string, error) {
+	configServer, err := getEnvoyConfigServerCluster()
+	if err != nil {
+		return nil, err
+	}
+
+	xds := envoy.ConfigSource{
+		ResourceAPIVersion: "V3",
+		APIConfigSource: envoy.APIConfigSource{
+			APIType:             "REST",
+			TransportAPIVersion: "V3",
+			ClusterNames:        []string{envoyConfigServerClusterName},
+			RefreshDelay:        httpProviderPollInterval,
+		},
+	}
+
+	listeners := []envoy.Listener{
+		// the admin interface reports whether the server has been initialized, i.e. has obtained its configuration
+		getEnvoyAdminProxyListener(pingEntryPointName, GatewayPingPort, pingPath, "/ready"),
+	}
+
+	if manager.Spec.Gateway.Metrics != nil {
+		listeners = append(listeners, getEnvoyAdminProxyListener(metricsEntryPointName, GatewayMetricsPort, "/metrics", "/stats/prometheus"))
+	}
+
+	bootstrap := envoy.Bootstrap{
+		Node: envoy.Node{
+			ID:      GetEnvoyNodeID(manager),
+			Cluster: manager.Name,
+		},
+		Admin: envoy.Admin{
+			AccessLogPath: "/dev/null",
+			Address:       envoy.NewAddress("127.0.0.1", envoyAdminPort),
+		},
+		StaticResources: envoy.StaticResources{
+			Listeners: listeners,
+			Clusters: []envoy.Cluster{
+				envoy.NewCluster(envoyAdminClusterName, "STATIC", "127.0.0.1", envoyAdminPort),
+				configServer,
+			},
+		},
+		DynamicResources: &envoy.DynamicResources{
+			LDSConfig: xds,
+			CDSConfig: xds,
+		},
+	}
+
+	staticConfig, err := marshalStaticConfig(bootstrap, manager.Spec.Gateway.AdditionalStaticConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		envoyStaticConfigKey: staticConfig,
+	}, nil
+}
+
+func (b *envoyBackend) UsesErrorPages() bool {
+	// Envoy serves the error pages for the errors it generates itself
+	return false
+}
+
+func (b *envoyBackend) Containers(manager *v1alpha1.CheManager, additionalMounts []corev1.VolumeMount) []corev1.Container {
+	gatewayProbes := getGatewayProbes(manager)
+
+	// the token is merged into the node metadata of the bootstrap configuration from the environment variable, which
+	// the cluster expands in the arguments
+	tokenConfig := fmt.Sprintf(`{"node": {"metadata": {"%s": "$(%s)"}}}`, ConfigServerTokenMetadataKey, configServerTokenEnvVar)
+
+	args := []string{"-c", "/etc/envoy/" + envoyStaticConfigKey, "--config-yaml", tokenConfig, "--log-level", getEnvoyLogLevel(manager.Spec.Gateway.LogLevel)}
+	if manager.Spec.Gateway.LogFormat == "json" {
+		args = append(args, "--log-format", envoyJSONLogFormat)
+	}
+
+	mounts := []corev1.VolumeMount{
+		{
+			Name:      "static-config",
+			MountPath: "/etc/envoy",
+		},
+	}
+
+	return []corev1.Container{
+		{
+			Name:            "gateway",
+			Image:           defaults.GetGatewayEnvoyImage(),
+			ImagePullPolicy: corev1.PullAlways,
+			Args:            args,
+			Env:             getConfigServerTokenEnv(manager),
+			LivenessProbe:   gatewayProbes.liveness,
+			ReadinessProbe:  gatewayProbes.readiness,
+			StartupProbe:    gatewayProbes.startup,
+			VolumeMounts:    append(mounts, additionalMounts...),
+		},
+	}
+}
+
+func (b *envoyBackend) Volumes(manager *v1alpha1.CheManager) []corev1.Volume {
+	return []corev1.Volume{}
+}
+
+// getEnvoyAdminProxyListener returns the listener exposing the single path of the admin interface on the given
+// port under a different path.
+func getEnvoyAdminProxyListener(name string, port int, path string, adminPath string) envoy.Listener {
+	return envoy.NewHTTPListener(name, port, &envoy.HTTPConnectionManager{
+		RouteConfig: envoy.RouteConfiguration{
+			Name: name,
+			VirtualHosts: []envoy.VirtualHost{
+				{
+					Name:    name,
+					Domains: []string{"*"},
+					Routes: []envoy.Route{
+						{
+							Match: envoy.RouteMatch{Path: path},
+							Route: &envoy.RouteAction{
+								Cluster:       envoyAdminClusterName,
+								PrefixRewrite: adminPath,
+							},
+						},
+					},
+				},
+			},
+		},
+		HTTPFilters: []envoy.HTTPFilter{{Name: envoy.RouterFilterName}},
+	})
+}
+
+// getEnvoyConfigServerCluster returns the cluster of the gateway config server of the operator.
+func getEnvoyConfigServerCluster() (envoy.Cluster, error) {
+	serverURL, err := url.Parse(defaults.GetGatewayConfigServerURL())
+	if err != nil {
+		return envoy.Cluster{}, fmt.Errorf("failed to parse the URL of the gateway config server: %s", err)
+	}
+
+	port := 80
+	if serverURL.Port() != "" {
+		if port, err = strconv.Atoi(serverURL.Port()); err != nil {
+			return envoy.Cluster{}, fmt.Errorf("the URL of the gateway config server '%s' has an invalid port", serverURL)
+		}
+	}
+
+	return envoy.NewCluster(envoyConfigServerClusterName, "STRICT_DNS", serverURL.Hostname(), port), nil
+}
+
+// getEnvoyLogLevel converts the log level from the che manager to the log level of Envoy.
+func getEnvoyLogLevel(level string) string {
+	switch level {
+	case "DEBUG":
+		return "debug"
+	case "WARN":
+		return "warning"
+	case "ERROR":
+		return "error"
+	case "FATAL", "PANIC":
+		return "critical"
+	default:
+		return "info"
+	}
+}
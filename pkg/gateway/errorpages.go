@@ -0,0 +1,256 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package gateway
+
+import (
+	"fmt"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ErrorPagePathPattern is the path of the page served by the error pages backend for given HTTP
+	// status code. The "{status}" is replaced by the actual status code by the gateway.
+	ErrorPagePathPattern = "/{status}.html"
+
+	errorPagesServerConfigKey = "default.conf"
+
+	// the key under which the gateway-wide routes are stored in the dynamic configuration configmap
+	gatewayRoutesKey = "gateway.yml"
+
+	// the name of the router, service and middleware serving the errors for the paths not routed anywhere else
+	notFoundErrorPagesName = "che-gateway-not-found"
+
+	// the name of the router, service and middleware answering on the configSyncedPath of the ping entrypoint
+	configSyncedName = "che-gateway-config-synced"
+
+	defaultWorkspaceNotFoundPage = `<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>Workspace not found</title>
+  </head>
+  <body>
+    <h1>Workspace not found</h1>
+    <p>There is no running workspace on this address. If the workspace has been stopped, start it again.</p>
+  </body>
+</html>
+`
+
+	defaultWorkspaceStartingPage = `<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <meta http-equiv="refresh" content="5">
+    <title>Workspace is starting</title>
+  </head>
+  <body>
+    <h1>Workspace is starting</h1>
+    <p>The workspace is not yet ready to handle your request. This page will reload automatically.
+    If the workspace doesn't start, try restarting it.</p>
+  </body>
+</html>
+`
+)
+
+var (
+	// ErrorPagesPort is the port on which the error pages backend listens inside the gateway pod.
+	ErrorPagesPort = 8081
+
+	defaultErrorPages = map[string]string{
+		"404.html": defaultWorkspaceNotFoundPage,
+		"502.html": defaultWorkspaceStartingPage,
+		"503.html": defaultWorkspaceStartingPage,
+		"504.html": defaultWorkspaceStartingPage,
+	}
+)
+
+// GetErrorPagesURL returns the URL on which the gateway can reach the error pages backend.
+func GetErrorPagesURL() string {
+	return fmt.Sprintf("http://127.0.0.1:%d", ErrorPagesPort)
+}
+
+// GetDefaultErrorPage returns the default error page for the given HTTP status code or an empty string if there is
+// no error page for the status code. It is used by the gateway implementations that serve the error pages themselves.
+func GetDefaultErrorPage(statusCode int) string {
+	return defaultErrorPages[fmt.Sprintf("%d.html", statusCode)]
+}
+
+func getErrorPagesConfigMapName(manager *v1alpha1.CheManager) string {
+	return manager.Name + "-error-pages"
+}
+
+func getGatewayRoutesConfigMapName(manager *v1alpha1.CheManager) string {
+	return manager.Name + "-routes"
+}
+
+func getGatewayErrorPagesConfigSpec(manager *v1alpha1.CheManager) corev1.ConfigMap {
+	data := map[string]string{
+		errorPagesServerConfigKey: fmt.Sprintf(`server {
+  listen %d;
+  root /usr/share/nginx/html;
+  location / {
+    try_files $uri =404;
+  }
+}
+`, ErrorPagesPort),
+	}
+
+	for k, v := range defaultErrorPages {
+		data[k] = v
+	}
+
+	return corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getErrorPagesConfigMapName(manager),
+			Namespace: manager.Namespace,
+			Labels:    defaults.GetLabelsForComponent(manager, "error-pages"),
+		},
+		Data: data,
+	}
+}
+
+// getGatewayRoutesConfigSpec returns the dynamic configuration of the gateway that is not specific to any
+// workspace. It routes all the requests not handled by any workspace to the error pages backend, so that
+// the users get a meaningful page when accessing a workspace that is not running. It also exposes the ping
+// of the gateway on the configSyncedPath, so that the probes can tell the configuration has been loaded.
+func getGatewayRoutesConfigSpec(manager *v1alpha1.CheManager) corev1.ConfigMap {
+	return corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getGatewayRoutesConfigMapName(manager),
+			Namespace: manager.Namespace,
+			Labels:    defaults.GetLabelsForComponent(manager, "gateway-config"),
+		},
+		Data: map[string]string{
+			gatewayRoutesKey: GetGatewayRoutesConfig(),
+		},
+	}
+}
+
+// GetGatewayRoutesConfig returns the dynamic configuration of the gateway that is not specific to any workspace,
+// serialized as YAML.
+func GetGatewayRoutesConfig() string {
+	return fmt.Sprintf(`http:
+  routers:
+    %[1]s:
+      rule: "PathPrefix(`+"`/`"+`)"
+      service: %[1]s
+      middlewares:
+      - %[1]s
+      priority: 1
+    %[3]s:
+      rule: "Path(`+"`%[4]s`"+`)"
+      entryPoints:
+      - %[5]s
+      service: %[3]s
+      middlewares:
+      - %[3]s
+  services:
+    %[1]s:
+      loadBalancer:
+        servers:
+        - url: "%[2]s"
+    %[3]s:
+      loadBalancer:
+        servers:
+        - url: "http://127.0.0.1:%[6]d"
+  middlewares:
+    %[1]s:
+      errors:
+        status:
+        - "404"
+        service: %[1]s
+        query: "/404.html"
+    %[3]s:
+      replacePath:
+        path: "%[7]s"
+`, notFoundErrorPagesName, GetErrorPagesURL(), configSyncedName, configSyncedPath, pingEntryPointName, GatewayPingPort, pingPath)
+}
+
+func getErrorPagesContainerSpec() corev1.Container {
+	return corev1.Container{
+		Name:            "error-pages",
+		Image:           defaults.GetGatewayErrorPagesImage(),
+		ImagePullPolicy: corev1.PullAlways,
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "error-pages-server-config",
+				MountPath: "/etc/nginx/conf.d",
+			},
+			{
+				Name:      "error-pages-content",
+				MountPath: "/usr/share/nginx/html",
+			},
+		},
+	}
+}
+
+func getErrorPagesVolumesSpec(manager *v1alpha1.CheManager) []corev1.Volume {
+	// the pages either come from the user-provided configmap or from our own configmap with the defaults
+	var content corev1.ConfigMapVolumeSource
+	if manager.Spec.ErrorPagesConfigMap != "" {
+		content = corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{
+				Name: manager.Spec.ErrorPagesConfigMap,
+			},
+		}
+	} else {
+		items := []corev1.KeyToPath{}
+		for _, page := range []string{"404.html", "502.html", "503.html", "504.html"} {
+			items = append(items, corev1.KeyToPath{Key: page, Path: page})
+		}
+
+		content = corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{
+				Name: getErrorPagesConfigMapName(manager),
+			},
+			Items: items,
+		}
+	}
+
+	return []corev1.Volume{
+		{
+			Name: "error-pages-server-config",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: getErrorPagesConfigMapName(manager),
+					},
+					Items: []corev1.KeyToPath{
+						{
+							Key:  errorPagesServerConfigKey,
+							Path: errorPagesServerConfigKey,
+						},
+					},
+				},
+			},
+		},
+		{
+			Name: "error-pages-content",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &content,
+			},
+		},
+	}
+}
@@ -14,6 +14,7 @@ package gateway
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
@@ -24,14 +25,19 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	rbac "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// The diff options are only used when the gateway objects are not synced using the server-side apply. The ignored
+// fields are either defaulted or maintained by the cluster, so the objects in the cluster would always differ from
+// the blueprints in them.
 var (
 	serviceAccountDiffOpts = cmpopts.IgnoreFields(corev1.ServiceAccount{}, "TypeMeta", "ObjectMeta", "Secrets", "ImagePullSecrets")
 	roleDiffOpts           = cmpopts.IgnoreFields(rbac.Role{}, "TypeMeta", "ObjectMeta")
@@ -48,31 +54,64 @@ var (
 		cmpopts.IgnoreFields(corev1.Container{}, "TerminationMessagePath", "TerminationMessagePolicy"),
 		cmpopts.IgnoreFields(corev1.PodSpec{}, "DNSPolicy", "SchedulerName", "SecurityContext", "DeprecatedServiceAccount"),
 		cmpopts.IgnoreFields(corev1.ConfigMapVolumeSource{}, "DefaultMode"),
+		cmpopts.IgnoreFields(corev1.SecretVolumeSource{}, "DefaultMode"),
+		// the pod template can be annotated by other tools (e.g. kubectl rollout restart). We only care about
+		// the hash of the static configuration that is used to restart the pods when the configuration changes.
+		cmp.FilterPath(func(p cmp.Path) bool {
+			return p.String() == "Spec.Template.ObjectMeta.Annotations"
+		}, cmp.Comparer(func(x, y map[string]string) bool {
+			return x[staticConfigHashAnnotation] == y[staticConfigHashAnnotation]
+		})),
 		cmpopts.IgnoreFields(corev1.VolumeSource{}, "EmptyDir"),
 		cmp.Comparer(func(x, y resource.Quantity) bool {
 			return x.Cmp(y) == 0
 		}),
 	}
 
-	GatewayPort       = 8080
-	GatewaySecurePort = 8443
+	GatewayPort        = 8080
+	GatewaySecurePort  = 8443
+	GatewayMetricsPort = 8082
 )
 
 type CheGateway struct {
-	client client.Client
-	scheme *runtime.Scheme
+	client   client.Client
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
+
+	// used for the server-side apply of the gateway objects, see sync.New
+	fieldManager string
+
+	dryRun bool
 }
 
-func New(client client.Client, scheme *runtime.Scheme) CheGateway {
+// New returns a new gateway. The optional recorder is used to record the events about the changes of the gateway
+// objects on the che manager. If the field manager is not empty, the gateway objects are synced using
+// the server-side apply, see sync.New.
+func New(client client.Client, scheme *runtime.Scheme, recorder record.EventRecorder, fieldManager string) CheGateway {
 	return CheGateway{
-		client: client,
-		scheme: scheme,
+		client:       client,
+		scheme:       scheme,
+		recorder:     recorder,
+		fieldManager: fieldManager,
 	}
 }
 
+// DryRun returns a copy of the gateway that only reports the changes it would make to the gateway objects, see
+// sync.Syncer.DryRun.
+func (g *CheGateway) DryRun() CheGateway {
+	ret := *g
+	ret.dryRun = true
+	return ret
+}
+
 func (g *CheGateway) Sync(ctx context.Context, manager *v1alpha1.CheManager) (bool, string, error) {
 
-	syncer := sync.New(g.client, g.scheme)
+	syncer := g.newSyncer()
+
+	backend := GetBackend(manager)
+	if err := backend.Validate(manager); err != nil {
+		return false, "", err
+	}
 
 	var ret, partial bool
 	var err error
@@ -84,24 +123,81 @@ func (g *CheGateway) Sync(ctx context.Context, manager *v1alpha1.CheManager) (bo
 	ret = ret || partial
 
 	role := getGatewayRoleSpec(manager)
-	if partial, _, err = syncer.Sync(ctx, manager, &role, roleDiffOpts); err != nil {
+	roleBinding := getGatewayRoleBindingSpec(manager)
+	routesConfig := getGatewayRoutesConfigSpec(manager)
+
+	if UsesHTTPConfigProvider(manager) || UsesKubernetesCRDConfigProvider(manager) {
+		// the gateway doesn't need to read the configmaps with the workspace configuration
+		if err = syncer.Delete(ctx, &roleBinding); err != nil {
+			return false, "", err
+		}
+		if err = syncer.Delete(ctx, &role); err != nil {
+			return false, "", err
+		}
+	} else {
+		if partial, _, err = syncer.Sync(ctx, manager, &role, roleDiffOpts); err != nil {
+			return false, "", err
+		}
+		ret = ret || partial
+
+		if partial, _, err = syncer.Sync(ctx, manager, &roleBinding, roleBindingDiffOpts); err != nil {
+			return false, "", err
+		}
+		ret = ret || partial
+	}
+
+	if UsesHTTPConfigProvider(manager) {
+		// all the gateway-wide routes are served by the operator
+		if err = syncer.Delete(ctx, &routesConfig); err != nil {
+			return false, "", err
+		}
+	} else {
+		if partial, _, err = syncer.Sync(ctx, manager, &routesConfig, configMapDiffOpts); err != nil {
+			return false, "", err
+		}
+		ret = ret || partial
+	}
+
+	if partial, err = g.reconcileKubernetesCRDProvider(syncer, ctx, manager); err != nil {
 		return false, "", err
 	}
 	ret = ret || partial
 
-	roleBinding := getGatewayRoleBindingSpec(manager)
-	if partial, _, err = syncer.Sync(ctx, manager, &roleBinding, roleBindingDiffOpts); err != nil {
+	partial, configServerTokenSecret, err := g.reconcileConfigServerToken(syncer, ctx, manager)
+	if err != nil {
 		return false, "", err
 	}
 	ret = ret || partial
 
-	traefikConfig := getGatewayTraefikConfigSpec(manager)
-	if partial, _, err = syncer.Sync(ctx, manager, &traefikConfig, configMapDiffOpts); err != nil {
+	staticConfig, err := getGatewayStaticConfigSpec(manager)
+	if err != nil {
+		return false, "", err
+	}
+	if partial, _, err = syncer.Sync(ctx, manager, &staticConfig, configMapDiffOpts); err != nil {
 		return false, "", err
 	}
 	ret = ret || partial
 
-	depl := getGatewayDeploymentSpec(manager)
+	errorPagesConfig := getGatewayErrorPagesConfigSpec(manager)
+	if backend.UsesErrorPages() {
+		if partial, _, err = syncer.Sync(ctx, manager, &errorPagesConfig, configMapDiffOpts); err != nil {
+			return false, "", err
+		}
+		ret = ret || partial
+	} else if err = syncer.Delete(ctx, &errorPagesConfig); err != nil {
+		return false, "", err
+	}
+
+	staticConfigSecrets, err := g.getStaticConfigSecrets(ctx, manager)
+	if err != nil {
+		return false, "", err
+	}
+	// the token is passed to the gateway in an environment variable, which is only read when the pod starts
+	if configServerTokenSecret != nil {
+		staticConfigSecrets = append(staticConfigSecrets, *configServerTokenSecret)
+	}
+
+	depl := getGatewayDeploymentSpec(manager, getStaticConfigHash(&staticConfig, staticConfigSecrets))
 	if partial, _, err = syncer.Sync(ctx, manager, &depl, deploymentDiffOpts); err != nil {
 		return false, "", err
 	}
@@ -113,6 +209,11 @@ func (g *CheGateway) Sync(ctx context.Context, manager *v1alpha1.CheManager) (bo
 	}
 	ret = ret || partial
 
+	if partial, err = g.reconcileServiceMonitor(syncer, ctx, manager); err != nil {
+		return false, "", err
+	}
+	ret = ret || partial
+
 	var host string
 
 	if infrastructure.Current.Type == infrastructure.OpenShift {
@@ -130,12 +231,53 @@ func (g *CheGateway) Sync(ctx context.Context, manager *v1alpha1.CheManager) (bo
 	return ret, host, nil
 }
 
+// getStaticConfigSecrets loads the secrets referenced from the static configuration of the gateway.
+func (g *CheGateway) getStaticConfigSecrets(ctx context.Context, manager *v1alpha1.CheManager) ([]corev1.Secret, error) {
+	ret := []corev1.Secret{}
+
+	for _, name := range manager.Spec.Gateway.StaticConfigSecrets {
+		secret := corev1.Secret{}
+		if err := g.client.Get(ctx, client.ObjectKey{Name: name, Namespace: manager.Namespace}, &secret); err != nil {
+			if errors.IsNotFound(err) {
+				return nil, fmt.Errorf("the secret '%s' referenced from the static configuration of the gateway doesn't exist or doesn't have the '%s' label", name, defaults.GatewayStaticConfigSecretLabel)
+			}
+			return nil, err
+		}
+		ret = append(ret, secret)
+	}
+
+	return ret, nil
+}
+
+// GetConfigProvider returns the config provider the gateway of the che manager obtains its dynamic configuration
+// with, falling back to the default if none is specified.
+func GetConfigProvider(manager *v1alpha1.CheManager) v1alpha1.GatewayConfigProvider {
+	if manager.Spec.Gateway.ConfigProvider == "" {
+		return v1alpha1.ConfigMapsConfigProvider
+	}
+	return manager.Spec.Gateway.ConfigProvider
+}
+
+// UsesHTTPConfigProvider returns true if the gateway of the che manager should obtain its dynamic configuration
+// from the operator over HTTP instead of from the config maps.
+func UsesHTTPConfigProvider(manager *v1alpha1.CheManager) bool {
+	return manager.Spec.Gateway.ConfigProvider == v1alpha1.HTTPConfigProvider
+}
+
+func (g *CheGateway) newSyncer() sync.Syncer {
+	syncer := sync.New(g.client, g.scheme, g.recorder, g.fieldManager)
+	if g.dryRun {
+		return syncer.DryRun()
+	}
+	return syncer
+}
+
 func GetGatewayServiceName(manager *v1alpha1.CheManager) string {
 	return manager.Name
 }
 
 func (g *CheGateway) Delete(ctx context.Context, manager *v1alpha1.CheManager) error {
-	syncer := sync.New(g.client, g.scheme)
+	syncer := g.newSyncer()
 
 	deployment := appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
@@ -157,6 +299,26 @@ func (g *CheGateway) Delete(ctx context.Context, manager *v1alpha1.CheManager) e
 		return err
 	}
 
+	errorPagesConfig := corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getErrorPagesConfigMapName(manager),
+			Namespace: manager.Namespace,
+		},
+	}
+	if err := syncer.Delete(ctx, &errorPagesConfig); err != nil {
+		return err
+	}
+
+	routesConfig := corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getGatewayRoutesConfigMapName(manager),
+			Namespace: manager.Namespace,
+		},
+	}
+	if err := syncer.Delete(ctx, &routesConfig); err != nil {
+		return err
+	}
+
 	roleBinding := rbac.RoleBinding{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      manager.Name,
@@ -197,7 +359,13 @@ func (g *CheGateway) Delete(ctx context.Context, manager *v1alpha1.CheManager) e
 		return err
 	}
 
-	return nil
+	if infrastructure.MonitoringAvailable {
+		if err := syncer.Delete(ctx, getGatewayServiceMonitorStub(manager)); err != nil {
+			return err
+		}
+	}
+
+	return g.deleteKubernetesCRDProviderObjects(syncer, ctx, manager)
 }
 
 // below functions declare the desired states of the various objects required for the gateway
@@ -262,7 +430,12 @@ func getGatewayRoleBindingSpec(manager *v1alpha1.CheManager) rbac.RoleBinding {
 	}
 }
 
-func getGatewayTraefikConfigSpec(manager *v1alpha1.CheManager) corev1.ConfigMap {
+func getGatewayStaticConfigSpec(manager *v1alpha1.CheManager) (corev1.ConfigMap, error) {
+	data, err := GetBackend(manager).StaticConfig(manager)
+	if err != nil {
+		return corev1.ConfigMap{}, err
+	}
+
 	return corev1.ConfigMap{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: corev1.SchemeGroupVersion.String(),
@@ -273,36 +446,35 @@ func getGatewayTraefikConfigSpec(manager *v1alpha1.CheManager) corev1.ConfigMap
 			Namespace: manager.Namespace,
 			Labels:    defaults.GetLabelsForComponent(manager, "gateway-config"),
 		},
-		Data: map[string]string{
-			"traefik.yml": `
-entrypoints:
-  http:
-    address: ":8080"
-    forwardedHeaders:
-      insecure: true
-  https:
-    address: ":8443"
-    forwardedHeaders:
-      insecure: true
-global:
-  checkNewVersion: false
-  sendAnonymousUsage: false
-providers:
-  file:
-    directory: "/dynamic-config"
-    watch: true
-log:
-  level: "INFO"`,
-		},
-	}
+		Data: data,
+	}, nil
 }
 
-func getGatewayDeploymentSpec(manager *v1alpha1.CheManager) appsv1.Deployment {
-	gatewayImage := defaults.GetGatewayImage()
-	sidecarImage := defaults.GetGatewayConfigurerImage()
+func getGatewayDeploymentSpec(manager *v1alpha1.CheManager, staticConfigHash string) appsv1.Deployment {
+	backend := GetBackend(manager)
 
 	terminationGracePeriodSeconds := int64(10)
 
+	secretVolumes, secretMounts := getStaticConfigSecretsVolumesSpec(manager)
+
+	volumes := []corev1.Volume{
+		{
+			Name: "static-config",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: manager.Name,
+					},
+				},
+			},
+		},
+	}
+
+	volumes = append(volumes, backend.Volumes(manager)...)
+	volumes = append(volumes, secretVolumes...)
+
+	containers := backend.Containers(manager, secretMounts)
+
 	return appsv1.Deployment{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: appsv1.SchemeGroupVersion.String(),
@@ -323,75 +495,53 @@ func getGatewayDeploymentSpec(manager *v1alpha1.CheManager) appsv1.Deployment {
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Labels: defaults.GetLabelsForComponent(manager, "deployment"),
+					Annotations: map[string]string{
+						staticConfigHashAnnotation: staticConfigHash,
+					},
 				},
 				Spec: corev1.PodSpec{
 					TerminationGracePeriodSeconds: &terminationGracePeriodSeconds,
 					ServiceAccountName:            manager.Name,
 					RestartPolicy:                 corev1.RestartPolicyAlways,
-					Containers: []corev1.Container{
-						{
-							Name:            "gateway",
-							Image:           gatewayImage,
-							ImagePullPolicy: corev1.PullAlways,
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "static-config",
-									MountPath: "/etc/traefik",
-								},
-								{
-									Name:      "dynamic-config",
-									MountPath: "/dynamic-config",
-								},
-							},
-						},
-						{
-							Name:            "configbump",
-							Image:           sidecarImage,
-							ImagePullPolicy: corev1.PullAlways,
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "dynamic-config",
-									MountPath: "/dynamic-config",
-								},
-							},
-							Env: []corev1.EnvVar{
-								{
-									Name:  "CONFIG_BUMP_DIR",
-									Value: "/dynamic-config",
-								},
-								{
-									Name:  "CONFIG_BUMP_LABELS",
-									Value: labels.FormatLabels(defaults.GetLabelsForComponent(manager, "gateway-config")),
-								},
-								{
-									Name: "CONFIG_BUMP_NAMESPACE",
-									ValueFrom: &corev1.EnvVarSource{
-										FieldRef: &corev1.ObjectFieldSelector{
-											APIVersion: "v1",
-											FieldPath:  "metadata.namespace",
-										},
-									},
-								},
-							},
-						},
-					},
-					Volumes: []corev1.Volume{
-						{
-							Name: "static-config",
-							VolumeSource: corev1.VolumeSource{
-								ConfigMap: &corev1.ConfigMapVolumeSource{
-									LocalObjectReference: corev1.LocalObjectReference{
-										Name: manager.Name,
-									},
-								},
-							},
-						},
-						{
-							Name: "dynamic-config",
-							VolumeSource: corev1.VolumeSource{
-								EmptyDir: &corev1.EmptyDirVolumeSource{},
-							},
-						},
+					Containers:                    containers,
+					Volumes:                       volumes,
+				},
+			},
+		},
+	}
+}
+
+func getConfigbumpContainerSpec(manager *v1alpha1.CheManager) corev1.Container {
+	configbumpProbes := getConfigbumpProbes(manager)
+
+	return corev1.Container{
+		Name:            "configbump",
+		Image:           defaults.GetGatewayConfigurerImage(),
+		ImagePullPolicy: corev1.PullAlways,
+		LivenessProbe:   configbumpProbes.liveness,
+		ReadinessProbe:  configbumpProbes.readiness,
+		StartupProbe:    configbumpProbes.startup,
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "dynamic-config",
+				MountPath: "/dynamic-config",
+			},
+		},
+		Env: []corev1.EnvVar{
+			{
+				Name:  "CONFIG_BUMP_DIR",
+				Value: "/dynamic-config",
+			},
+			{
+				Name:  "CONFIG_BUMP_LABELS",
+				Value: labels.FormatLabels(defaults.GetLabelsForComponent(manager, "gateway-config")),
+			},
+			{
+				Name: "CONFIG_BUMP_NAMESPACE",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{
+						APIVersion: "v1",
+						FieldPath:  "metadata.namespace",
 					},
 				},
 			},
@@ -400,6 +550,30 @@ func getGatewayDeploymentSpec(manager *v1alpha1.CheManager) appsv1.Deployment {
 }
 
 func getGatewayServiceSpec(manager *v1alpha1.CheManager) corev1.Service {
+	ports := []corev1.ServicePort{
+		{
+			Name:       "gateway-http",
+			Port:       int32(GatewayPort),
+			Protocol:   corev1.ProtocolTCP,
+			TargetPort: intstr.FromInt(GatewayPort),
+		},
+		{
+			Name:       "gateway-https",
+			Port:       int32(GatewaySecurePort),
+			Protocol:   corev1.ProtocolTCP,
+			TargetPort: intstr.FromInt(GatewaySecurePort),
+		},
+	}
+
+	if manager.Spec.Gateway.Metrics != nil {
+		ports = append(ports, corev1.ServicePort{
+			Name:       gatewayMetricsPortName,
+			Port:       int32(GatewayMetricsPort),
+			Protocol:   corev1.ProtocolTCP,
+			TargetPort: intstr.FromInt(GatewayMetricsPort),
+		})
+	}
+
 	return corev1.Service{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: corev1.SchemeGroupVersion.String(),
@@ -414,20 +588,7 @@ func getGatewayServiceSpec(manager *v1alpha1.CheManager) corev1.Service {
 			Selector:        defaults.GetLabelsForComponent(manager, "deployment"),
 			SessionAffinity: corev1.ServiceAffinityNone,
 			Type:            corev1.ServiceTypeClusterIP,
-			Ports: []corev1.ServicePort{
-				{
-					Name:       "gateway-http",
-					Port:       int32(GatewayPort),
-					Protocol:   corev1.ProtocolTCP,
-					TargetPort: intstr.FromInt(GatewayPort),
-				},
-				{
-					Name:       "gateway-https",
-					Port:       int32(GatewaySecurePort),
-					Protocol:   corev1.ProtocolTCP,
-					TargetPort: intstr.FromInt(GatewaySecurePort),
-				},
-			},
+			Ports:           ports,
 		},
 	}
 }
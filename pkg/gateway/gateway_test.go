@@ -2,17 +2,28 @@ package gateway
 
 import (
 	"context"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/infrastructure"
+	"github.com/google/go-cmp/cmp"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
 	rbac "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
 )
 
 func createTestScheme() *runtime.Scheme {
@@ -117,3 +128,948 @@ func TestDelete(t *testing.T) {
 
 	TestGatewayObjectsDontExist(t, ctx, cl, managerName, ns)
 }
+
+func TestCustomErrorPages(t *testing.T) {
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host:                "over.the.rainbow",
+			Routing:             v1alpha1.SingleHost,
+			ErrorPagesConfigMap: "my-pages",
+		},
+	}
+
+	depl := getGatewayDeploymentSpec(manager, "")
+
+	found := false
+	for _, v := range depl.Spec.Template.Spec.Volumes {
+		if v.Name == "error-pages-content" {
+			found = true
+			if v.ConfigMap == nil || v.ConfigMap.Name != "my-pages" {
+				t.Errorf("The error pages should have been mounted from the custom configmap but the volume is: %v", v)
+			}
+		}
+	}
+
+	if !found {
+		t.Error("The gateway deployment should mount the error pages")
+	}
+}
+
+func TestStaticConfigFromSpec(t *testing.T) {
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host:    "over.the.rainbow",
+			Routing: v1alpha1.SingleHost,
+			Gateway: v1alpha1.GatewaySettings{
+				LogLevel:                   "DEBUG",
+				AccessLog:                  &v1alpha1.GatewayAccessLog{Format: "json"},
+				TrustedForwardedHeadersIPs: []string{"10.0.0.0/8"},
+				Timeouts:                   &v1alpha1.GatewayTimeouts{ReadTimeout: "30s"},
+				AdditionalStaticConfig:     "log:\n  level: ERROR\nping: {}\n",
+			},
+		},
+	}
+
+	cm, err := getGatewayStaticConfigSpec(manager)
+	if err != nil {
+		t.Fatalf("Failed to produce the static config: %s", err)
+	}
+
+	cfg := map[string]interface{}{}
+	if err = yaml.Unmarshal([]byte(cm.Data["traefik.yml"]), &cfg); err != nil {
+		t.Fatalf("Failed to parse the static config: %s", err)
+	}
+
+	log := cfg["log"].(map[string]interface{})
+	if log["level"] != "ERROR" {
+		t.Errorf("The additional config should have overridden the log level but it is %v", log["level"])
+	}
+
+	if _, ok := cfg["ping"]; !ok {
+		t.Error("The additional config should have been merged into the static config")
+	}
+
+	if cfg["accessLog"].(map[string]interface{})["format"] != "json" {
+		t.Error("The access log should have been configured")
+	}
+
+	http := cfg["entryPoints"].(map[string]interface{})["http"].(map[string]interface{})
+	fwd := http["forwardedHeaders"].(map[string]interface{})
+	if _, ok := fwd["insecure"]; ok {
+		t.Error("The forwarded headers should not be trusted from everywhere when trusted IPs are configured")
+	}
+	if ips := fwd["trustedIPs"].([]interface{}); len(ips) != 1 || ips[0] != "10.0.0.0/8" {
+		t.Errorf("Unexpected trusted IPs: %v", ips)
+	}
+
+	timeouts := http["transport"].(map[string]interface{})["respondingTimeouts"].(map[string]interface{})
+	if timeouts["readTimeout"] != "30s" {
+		t.Errorf("Unexpected read timeout: %v", timeouts["readTimeout"])
+	}
+}
+
+func TestForwardedHeadersNotTrustedByDefault(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		settings v1alpha1.GatewaySettings
+		insecure bool
+	}{
+		{name: "default", settings: v1alpha1.GatewaySettings{}, insecure: false},
+		{name: "insecure", settings: v1alpha1.GatewaySettings{InsecureForwardedHeaders: true}, insecure: true},
+	} {
+		manager := &v1alpha1.CheManager{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "che",
+				Namespace: "default",
+			},
+			Spec: v1alpha1.CheManagerSpec{
+				Host:    "over.the.rainbow",
+				Routing: v1alpha1.SingleHost,
+				Gateway: tc.settings,
+			},
+		}
+
+		cm, err := getGatewayStaticConfigSpec(manager)
+		if err != nil {
+			t.Fatalf("%s: failed to produce the static config: %s", tc.name, err)
+		}
+
+		cfg := map[string]interface{}{}
+		if err = yaml.Unmarshal([]byte(cm.Data["traefik.yml"]), &cfg); err != nil {
+			t.Fatalf("%s: failed to parse the static config: %s", tc.name, err)
+		}
+
+		for _, entryPoint := range []string{"http", "https"} {
+			fwd, _ := cfg["entryPoints"].(map[string]interface{})[entryPoint].(map[string]interface{})["forwardedHeaders"].(map[string]interface{})
+			if insecure := fwd["insecure"] == true; insecure != tc.insecure {
+				t.Errorf("%s: expected the insecure forwarded headers on the '%s' entry point to be %t but got %v", tc.name, entryPoint, tc.insecure, fwd)
+			}
+		}
+	}
+}
+
+func TestInvalidAdditionalStaticConfig(t *testing.T) {
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Gateway: v1alpha1.GatewaySettings{
+				AdditionalStaticConfig: "this: is: not: yaml",
+			},
+		},
+	}
+
+	if _, err := getGatewayStaticConfigSpec(manager); err == nil {
+		t.Error("Invalid additional static config should have been reported")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	origMonitoring := infrastructure.MonitoringAvailable
+	infrastructure.MonitoringAvailable = true
+	defer func() { infrastructure.MonitoringAvailable = origMonitoring }()
+
+	scheme := createTestScheme()
+	cl := fake.NewFakeClientWithScheme(scheme)
+	ctx := context.TODO()
+
+	gateway := CheGateway{client: cl, scheme: scheme}
+
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host:    "over.the.rainbow",
+			Routing: v1alpha1.SingleHost,
+			Gateway: v1alpha1.GatewaySettings{
+				Metrics: &v1alpha1.GatewayMetrics{
+					ScrapeInterval: "15s",
+				},
+			},
+		},
+	}
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	cm := corev1.ConfigMap{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: "che", Namespace: "default"}, &cm); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(cm.Data["traefik.yml"]), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cfg["entryPoints"].(map[string]interface{})["metrics"]; !ok {
+		t.Error("The metrics entrypoint should have been configured")
+	}
+	if _, ok := cfg["metrics"].(map[string]interface{})["prometheus"]; !ok {
+		t.Error("The prometheus metrics should have been configured")
+	}
+
+	svc := corev1.Service{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: "che", Namespace: "default"}, &svc); err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, p := range svc.Spec.Ports {
+		if p.Port == int32(GatewayMetricsPort) {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("The metrics port should have been exposed on the gateway service")
+	}
+
+	sm := getGatewayServiceMonitorStub(manager)
+	if err := cl.Get(ctx, client.ObjectKey{Name: "che", Namespace: "default"}, sm); err != nil {
+		t.Fatalf("The service monitor should have been created: %s", err)
+	}
+
+	endpoints, _, _ := unstructured.NestedSlice(sm.Object, "spec", "endpoints")
+	if len(endpoints) != 1 || endpoints[0].(map[string]interface{})["interval"] != "15s" {
+		t.Errorf("Unexpected service monitor endpoints: %v", endpoints)
+	}
+
+	// disabling the metrics should remove the service monitor
+	manager.Spec.Gateway.Metrics = nil
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	if err := cl.Get(ctx, client.ObjectKey{Name: "che", Namespace: "default"}, getGatewayServiceMonitorStub(manager)); !errors.IsNotFound(err) {
+		t.Errorf("The service monitor should have been deleted but got: %v", err)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	scheme := createTestScheme()
+	cl := fake.NewFakeClientWithScheme(scheme)
+	ctx := context.TODO()
+
+	gateway := CheGateway{client: cl, scheme: scheme}
+
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host:    "over.the.rainbow",
+			Routing: v1alpha1.SingleHost,
+		},
+	}
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	cfg, podAnnos := readStaticConfigAndPodAnnotations(t, cl)
+	if _, ok := cfg["tracing"]; ok {
+		t.Error("Tracing should be disabled by default")
+	}
+	origHash := podAnnos[staticConfigHashAnnotation]
+	if origHash == "" {
+		t.Error("The gateway pods should be annotated with the hash of the static config")
+	}
+
+	manager.Spec.Gateway.Tracing = &v1alpha1.GatewayTracing{
+		Endpoint:     "http://collector:9411/api/v2/spans",
+		SamplingRate: "0.25",
+	}
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	cfg, podAnnos = readStaticConfigAndPodAnnotations(t, cl)
+	tracing, ok := cfg["tracing"].(map[string]interface{})
+	if !ok {
+		t.Fatal("Tracing should have been configured")
+	}
+	if tracing["serviceName"] != "che-gateway" {
+		t.Errorf("Unexpected service name: %v", tracing["serviceName"])
+	}
+	zipkin := tracing["zipkin"].(map[string]interface{})
+	if zipkin["httpEndpoint"] != "http://collector:9411/api/v2/spans" {
+		t.Errorf("Unexpected tracing endpoint: %v", zipkin["httpEndpoint"])
+	}
+	if zipkin["sampleRate"] != 0.25 {
+		t.Errorf("Unexpected sample rate: %v", zipkin["sampleRate"])
+	}
+
+	if podAnnos[staticConfigHashAnnotation] == origHash {
+		t.Error("Changing the tracing settings should have changed the pod template to restart the gateway")
+	}
+}
+
+func TestInvalidTracingSamplingRate(t *testing.T) {
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Gateway: v1alpha1.GatewaySettings{
+				Tracing: &v1alpha1.GatewayTracing{
+					Endpoint:     "http://collector:9411/api/v2/spans",
+					SamplingRate: "1.5",
+				},
+			},
+		},
+	}
+
+	if _, err := getGatewayStaticConfigSpec(manager); err == nil {
+		t.Error("Invalid sampling rate should have been reported")
+	}
+}
+
+func readStaticConfigAndPodAnnotations(t *testing.T, cl client.Client) (map[string]interface{}, map[string]string) {
+	ctx := context.TODO()
+	key := client.ObjectKey{Name: "che", Namespace: "default"}
+
+	cm := corev1.ConfigMap{}
+	if err := cl.Get(ctx, key, &cm); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(cm.Data["traefik.yml"]), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	depl := appsv1.Deployment{}
+	if err := cl.Get(ctx, key, &depl); err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg, depl.Spec.Template.Annotations
+}
+
+func TestProbes(t *testing.T) {
+	periodSeconds := int32(42)
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Gateway: v1alpha1.GatewaySettings{
+				Probes: &v1alpha1.GatewayProbes{
+					Liveness: &v1alpha1.ProbeTimings{
+						PeriodSeconds: &periodSeconds,
+					},
+				},
+			},
+		},
+	}
+
+	depl := getGatewayDeploymentSpec(manager, "")
+
+	for _, c := range depl.Spec.Template.Spec.Containers {
+		if c.Name == "configbump" {
+			if c.LivenessProbe != nil || c.StartupProbe != nil {
+				t.Error("The configbump should not be restarted when the gateway fails")
+			}
+			if c.ReadinessProbe == nil || c.ReadinessProbe.HTTPGet == nil || c.ReadinessProbe.HTTPGet.Path != configSyncedPath {
+				t.Errorf("The readiness of the configbump should be probed on the config synced path of the gateway but the probe is %v", c.ReadinessProbe)
+			}
+			continue
+		}
+
+		if c.Name != "gateway" {
+			continue
+		}
+
+		if c.LivenessProbe == nil || c.ReadinessProbe == nil || c.StartupProbe == nil {
+			t.Errorf("Container %s should have all the probes defined", c.Name)
+			continue
+		}
+
+		if c.LivenessProbe.PeriodSeconds != periodSeconds {
+			t.Errorf("The liveness probe of the container %s should have used the configured period but was %d", c.Name, c.LivenessProbe.PeriodSeconds)
+		}
+
+		if c.ReadinessProbe.PeriodSeconds != *defaultReadinessTimings.PeriodSeconds {
+			t.Errorf("The readiness probe of the container %s should have used the default period but was %d", c.Name, c.ReadinessProbe.PeriodSeconds)
+		}
+
+		if c.Name == "gateway" && (c.LivenessProbe.HTTPGet == nil || c.LivenessProbe.HTTPGet.Port.IntValue() != GatewayPingPort) {
+			t.Errorf("The gateway should be probed on the ping port but the probe is %v", c.LivenessProbe)
+		}
+	}
+
+	staticConfig, err := getTraefikStaticConfig(manager)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := map[string]interface{}{}
+	if err = yaml.Unmarshal([]byte(staticConfig), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg["ping"].(map[string]interface{})["entryPoint"] != pingEntryPointName {
+		t.Error("The ping should be served on the dedicated entrypoint")
+	}
+
+	routes := map[string]interface{}{}
+	if err = yaml.Unmarshal([]byte(GetGatewayRoutesConfig()), &routes); err != nil {
+		t.Fatal(err)
+	}
+
+	router := routes["http"].(map[string]interface{})["routers"].(map[string]interface{})[configSyncedName].(map[string]interface{})
+	if router["rule"] != "Path(`/config-synced`)" || !reflect.DeepEqual(router["entryPoints"], []interface{}{pingEntryPointName}) {
+		t.Errorf("The config synced path should be routed on the ping entrypoint but the router is %v", router)
+	}
+
+	replacePath := routes["http"].(map[string]interface{})["middlewares"].(map[string]interface{})[configSyncedName].(map[string]interface{})["replacePath"]
+	if replacePath.(map[string]interface{})["path"] != pingPath {
+		t.Errorf("The config synced path should be answered by the ping but the middleware is %v", replacePath)
+	}
+}
+
+func TestStaticConfigSecretsRollThePods(t *testing.T) {
+	scheme := createTestScheme()
+	secret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "certs",
+			Namespace: "default",
+		},
+		Data: map[string][]byte{
+			"tls.crt": []byte("original"),
+		},
+	}
+	cl := fake.NewFakeClientWithScheme(scheme, secret)
+	ctx := context.TODO()
+
+	gateway := CheGateway{client: cl, scheme: scheme}
+
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host:    "over.the.rainbow",
+			Routing: v1alpha1.SingleHost,
+			Gateway: v1alpha1.GatewaySettings{
+				StaticConfigSecrets: []string{"certs"},
+			},
+		},
+	}
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	depl := appsv1.Deployment{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: "che", Namespace: "default"}, &depl); err != nil {
+		t.Fatal(err)
+	}
+
+	mounted := false
+	for _, v := range depl.Spec.Template.Spec.Volumes {
+		if v.Secret != nil && v.Secret.SecretName == "certs" {
+			mounted = true
+		}
+	}
+	if !mounted {
+		t.Error("The referenced secret should have been mounted into the gateway")
+	}
+
+	origHash := depl.Spec.Template.Annotations[staticConfigHashAnnotation]
+
+	secret.Data["tls.crt"] = []byte("changed")
+	if err := cl.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	if err := cl.Get(ctx, client.ObjectKey{Name: "che", Namespace: "default"}, &depl); err != nil {
+		t.Fatal(err)
+	}
+
+	if depl.Spec.Template.Annotations[staticConfigHashAnnotation] == origHash {
+		t.Error("Changing the referenced secret should have changed the pod template to restart the gateway")
+	}
+}
+
+func TestMissingStaticConfigSecretIsReported(t *testing.T) {
+	scheme := createTestScheme()
+	cl := fake.NewFakeClientWithScheme(scheme)
+
+	gateway := CheGateway{client: cl, scheme: scheme}
+
+	_, _, err := gateway.Sync(context.TODO(), &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host:    "over.the.rainbow",
+			Routing: v1alpha1.SingleHost,
+			Gateway: v1alpha1.GatewaySettings{
+				StaticConfigSecrets: []string{"nonexistent"},
+			},
+		},
+	})
+
+	if err == nil {
+		t.Error("The missing secret should have been reported")
+	}
+}
+
+func TestNoSpuriousDeploymentUpdates(t *testing.T) {
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host:    "over.the.rainbow",
+			Routing: v1alpha1.SingleHost,
+		},
+	}
+
+	staticConfig, err := getGatewayStaticConfigSpec(manager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := getStaticConfigHash(&staticConfig, nil)
+
+	actual := getGatewayDeploymentSpec(manager, hash)
+	// simulate what other tools can do to the pod template
+	actual.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = "2021-01-01T00:00:00Z"
+
+	if diff := cmp.Diff(&actual, &appsv1.Deployment{}, deploymentDiffOpts); diff == "" {
+		t.Fatal("The test is broken, the deployments should differ")
+	}
+
+	expected := getGatewayDeploymentSpec(manager, hash)
+	if diff := cmp.Diff(&actual, &expected, deploymentDiffOpts); diff != "" {
+		t.Errorf("The deployments should not differ but the diff is: %s", diff)
+	}
+
+	expected = getGatewayDeploymentSpec(manager, "different")
+	if diff := cmp.Diff(&actual, &expected, deploymentDiffOpts); diff == "" {
+		t.Error("The change of the static configuration hash should have been detected")
+	}
+}
+
+func TestHTTPConfigProvider(t *testing.T) {
+	scheme := createTestScheme()
+	cl := fake.NewFakeClientWithScheme(scheme)
+	ctx := context.TODO()
+
+	gateway := CheGateway{client: cl, scheme: scheme}
+
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host:    "over.the.rainbow",
+			Routing: v1alpha1.SingleHost,
+		},
+	}
+
+	// start in the configmaps mode and switch to the HTTP mode to check that we clean up
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	manager.Spec.Gateway.ConfigProvider = v1alpha1.HTTPConfigProvider
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	cfg, _ := readStaticConfigAndPodAnnotations(t, cl)
+	providers := cfg["providers"].(map[string]interface{})
+	if _, ok := providers["file"]; ok {
+		t.Error("The file provider should not be used in the HTTP provider mode")
+	}
+	httpProvider, ok := providers["http"].(map[string]interface{})
+	if !ok {
+		t.Fatal("The HTTP provider should have been configured")
+	}
+	token, err := GetConfigServerToken(ctx, cl, client.ObjectKey{Name: "che", Namespace: "default"})
+	if err != nil || len(token) != 64 {
+		t.Fatalf("A random token for the config server should have been generated but got '%s', %v", token, err)
+	}
+	if httpProvider["endpoint"] != defaults.GetGatewayConfigEndpoint(manager)+"?token="+traefikConfigServerTokenPlaceholder {
+		t.Errorf("Unexpected endpoint of the HTTP provider: %v", httpProvider["endpoint"])
+	}
+	staticConfig := corev1.ConfigMap{}
+	if err = cl.Get(ctx, client.ObjectKey{Name: "che", Namespace: "default"}, &staticConfig); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(staticConfig.Data["traefik.yml"], token) {
+		t.Error("The token of the config server should not be stored in the static configuration")
+	}
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+	if again, _ := GetConfigServerToken(ctx, cl, client.ObjectKey{Name: "che", Namespace: "default"}); again != token {
+		t.Error("The token of the config server should not change once generated")
+	}
+
+	key := client.ObjectKey{Name: "che", Namespace: "default"}
+
+	depl := appsv1.Deployment{}
+	if err := cl.Get(ctx, key, &depl); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range depl.Spec.Template.Spec.Containers {
+		if c.Name == "configbump" {
+			t.Error("There should be no configbump sidecar in the HTTP provider mode")
+		}
+		if c.Name == "gateway" && (len(c.Env) != 1 || c.Env[0].ValueFrom.SecretKeyRef.Name != "che-config-server-token") {
+			t.Errorf("The gateway should read the token of the config server from its secret but got %+v", c.Env)
+		}
+	}
+
+	if err := cl.Get(ctx, key, &rbac.Role{}); !errors.IsNotFound(err) {
+		t.Errorf("The role should have been deleted but got: %v", err)
+	}
+	if err := cl.Get(ctx, key, &rbac.RoleBinding{}); !errors.IsNotFound(err) {
+		t.Errorf("The role binding should have been deleted but got: %v", err)
+	}
+	if err := cl.Get(ctx, client.ObjectKey{Name: "che-routes", Namespace: "default"}, &corev1.ConfigMap{}); !errors.IsNotFound(err) {
+		t.Errorf("The gateway routes configmap should have been deleted but got: %v", err)
+	}
+}
+
+func TestKubernetesCRDConfigProvider(t *testing.T) {
+	scheme := createTestScheme()
+	// the cluster-wide access granted by the previous versions of the operator
+	cl := fake.NewFakeClientWithScheme(scheme,
+		&rbac.ClusterRole{ObjectMeta: v1.ObjectMeta{Name: "default-che-gateway"}},
+		&rbac.ClusterRoleBinding{ObjectMeta: v1.ObjectMeta{Name: "default-che-gateway"}})
+	ctx := context.TODO()
+
+	gateway := CheGateway{client: cl, scheme: scheme}
+
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host:    "over.the.rainbow",
+			Routing: v1alpha1.SingleHost,
+			Gateway: v1alpha1.GatewaySettings{
+				ConfigProvider: v1alpha1.KubernetesCRDConfigProvider,
+			},
+		},
+		Status: v1alpha1.CheManagerStatus{
+			WorkspaceNamespaces: []string{"ws1"},
+		},
+	}
+
+	infrastructure.TraefikCRDsAvailable = false
+	if _, _, err := gateway.Sync(ctx, manager); err == nil {
+		t.Error("The sync should have failed when the Traefik CRDs are not available")
+	}
+
+	infrastructure.TraefikCRDsAvailable = true
+	defer func() { infrastructure.TraefikCRDsAvailable = false }()
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	cfg, _ := readStaticConfigAndPodAnnotations(t, cl)
+	providers := cfg["providers"].(map[string]interface{})
+	crdProvider, ok := providers["kubernetesCRD"].(map[string]interface{})
+	if !ok {
+		t.Fatal("The Kubernetes CRD provider should have been configured")
+	}
+	if crdProvider["labelSelector"] != labels.FormatLabels(GetWorkspaceConfigLabels(manager)) {
+		t.Errorf("Unexpected label selector of the Kubernetes CRD provider: %v", crdProvider["labelSelector"])
+	}
+	if _, ok := providers["file"]; !ok {
+		t.Error("The file provider should still be used for the gateway-wide routes")
+	}
+
+	depl := appsv1.Deployment{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: "che", Namespace: "default"}, &depl); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range depl.Spec.Template.Spec.Containers {
+		if c.Name == "configbump" {
+			t.Error("There should be no configbump sidecar in the Kubernetes CRD provider mode")
+		}
+	}
+	for _, v := range depl.Spec.Template.Spec.Volumes {
+		if v.Name == "dynamic-config" && (v.ConfigMap == nil || v.ConfigMap.Name != "che-routes") {
+			t.Error("The gateway-wide routes should be mounted directly from their configmap")
+		}
+	}
+
+	if !reflect.DeepEqual(crdProvider["namespaces"], []interface{}{"default", "ws1"}) {
+		t.Errorf("The gateway should only watch the namespaces of the che manager and the workspaces but got: %v", crdProvider["namespaces"])
+	}
+
+	for _, ns := range []string{"default", "ws1"} {
+		key := client.ObjectKey{Name: "default-che-gateway", Namespace: ns}
+		if err := cl.Get(ctx, key, &rbac.Role{}); err != nil {
+			t.Errorf("Failed to get the role of the gateway in the namespace %s: %s", ns, err)
+		}
+		if err := cl.Get(ctx, key, &rbac.RoleBinding{}); err != nil {
+			t.Errorf("Failed to get the role binding of the gateway in the namespace %s: %s", ns, err)
+		}
+	}
+	clusterRoleKey := client.ObjectKey{Name: "default-che-gateway"}
+	if err := cl.Get(ctx, clusterRoleKey, &rbac.ClusterRole{}); !errors.IsNotFound(err) {
+		t.Errorf("The gateway should not be granted the cluster-wide access but got: %v", err)
+	}
+	if err := cl.Get(ctx, clusterRoleKey, &rbac.ClusterRoleBinding{}); !errors.IsNotFound(err) {
+		t.Errorf("The gateway should not be granted the cluster-wide access but got: %v", err)
+	}
+	if err := cl.Get(ctx, client.ObjectKey{Name: "che", Namespace: "default"}, &rbac.Role{}); !errors.IsNotFound(err) {
+		t.Errorf("The role should not exist but got: %v", err)
+	}
+	if err := cl.Get(ctx, client.ObjectKey{Name: GetErrorPagesServiceName(manager), Namespace: "default"}, &corev1.Service{}); err != nil {
+		t.Errorf("Failed to get the error pages service: %s", err)
+	}
+
+	// the access to the namespaces without any workspaces is revoked
+	manager.Status.WorkspaceNamespaces = []string{"ws2"}
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	if err := cl.Get(ctx, client.ObjectKey{Name: "default-che-gateway", Namespace: "ws1"}, &rbac.Role{}); !errors.IsNotFound(err) {
+		t.Errorf("The role in the namespace without workspaces should have been deleted but got: %v", err)
+	}
+	if err := cl.Get(ctx, client.ObjectKey{Name: "default-che-gateway", Namespace: "ws2"}, &rbac.RoleBinding{}); err != nil {
+		t.Errorf("Failed to get the role binding of the gateway in the new namespace: %s", err)
+	}
+
+	// switching back to the configmaps mode must clean up the objects outside of the namespace of the che manager
+	manager.Spec.Gateway.ConfigProvider = v1alpha1.ConfigMapsConfigProvider
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	for _, ns := range []string{"default", "ws2"} {
+		key := client.ObjectKey{Name: "default-che-gateway", Namespace: ns}
+		if err := cl.Get(ctx, key, &rbac.Role{}); !errors.IsNotFound(err) {
+			t.Errorf("The role in the namespace %s should have been deleted but got: %v", ns, err)
+		}
+		if err := cl.Get(ctx, key, &rbac.RoleBinding{}); !errors.IsNotFound(err) {
+			t.Errorf("The role binding in the namespace %s should have been deleted but got: %v", ns, err)
+		}
+	}
+	if err := cl.Get(ctx, client.ObjectKey{Name: GetErrorPagesServiceName(manager), Namespace: "default"}, &corev1.Service{}); !errors.IsNotFound(err) {
+		t.Errorf("The error pages service should have been deleted but got: %v", err)
+	}
+}
+
+func TestEnvoyImplementation(t *testing.T) {
+	scheme := createTestScheme()
+	cl := fake.NewFakeClientWithScheme(scheme)
+	ctx := context.TODO()
+
+	gateway := CheGateway{client: cl, scheme: scheme}
+
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host:    "over.the.rainbow",
+			Routing: v1alpha1.SingleHost,
+			Gateway: v1alpha1.GatewaySettings{
+				Implementation: v1alpha1.EnvoyGatewayImplementation,
+				ConfigProvider: v1alpha1.HTTPConfigProvider,
+				LogLevel:       "WARN",
+			},
+		},
+	}
+
+	if _, _, err := gateway.Sync(ctx, manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	key := client.ObjectKey{Name: "che", Namespace: "default"}
+
+	cm := corev1.ConfigMap{}
+	if err := cl.Get(ctx, key, &cm); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cm.Data["traefik.yml"]; ok {
+		t.Error("There should be no traefik configuration for the envoy gateway")
+	}
+
+	bootstrap := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(cm.Data["envoy.yaml"]), &bootstrap); err != nil {
+		t.Fatal(err)
+	}
+
+	node := bootstrap["node"].(map[string]interface{})
+	if node["id"] != GetEnvoyNodeID(manager) {
+		t.Errorf("Unexpected node ID: %v", node["id"])
+	}
+
+	dynamic, ok := bootstrap["dynamic_resources"].(map[string]interface{})
+	if !ok {
+		t.Fatal("The listeners and clusters should be obtained dynamically")
+	}
+	lds := dynamic["lds_config"].(map[string]interface{})["api_config_source"].(map[string]interface{})
+	if lds["api_type"] != "REST" || lds["cluster_names"].([]interface{})[0] != envoyConfigServerClusterName {
+		t.Errorf("Unexpected LDS config: %v", lds)
+	}
+
+	depl := appsv1.Deployment{}
+	if err := cl.Get(ctx, key, &depl); err != nil {
+		t.Fatal(err)
+	}
+
+	containers := depl.Spec.Template.Spec.Containers
+	if len(containers) != 1 {
+		t.Fatalf("There should be just the gateway container but there are %d containers", len(containers))
+	}
+	if containers[0].Image != defaults.GetGatewayEnvoyImage() {
+		t.Errorf("Unexpected image of the gateway container: %s", containers[0].Image)
+	}
+	expectedArgs := []string{"-c", "/etc/envoy/envoy.yaml", "--config-yaml", `{"node": {"metadata": {"token": "$(CONFIG_SERVER_TOKEN)"}}}`, "--log-level", "warning"}
+	if !cmp.Equal(expectedArgs, containers[0].Args) {
+		t.Errorf("Unexpected arguments of the gateway container: %s", cmp.Diff(expectedArgs, containers[0].Args))
+	}
+
+	if err := cl.Get(ctx, client.ObjectKey{Name: "che-error-pages", Namespace: "default"}, &corev1.ConfigMap{}); !errors.IsNotFound(err) {
+		t.Errorf("The error pages config map should not exist for the envoy gateway but got: %v", err)
+	}
+}
+
+func TestEnvoyImplementationValidation(t *testing.T) {
+	scheme := createTestScheme()
+	cl := fake.NewFakeClientWithScheme(scheme)
+
+	gateway := CheGateway{client: cl, scheme: scheme}
+
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host:    "over.the.rainbow",
+			Routing: v1alpha1.SingleHost,
+			Gateway: v1alpha1.GatewaySettings{
+				Implementation: v1alpha1.EnvoyGatewayImplementation,
+			},
+		},
+	}
+
+	if _, _, err := gateway.Sync(context.TODO(), manager); err == nil {
+		t.Error("The envoy gateway should require the HTTP config provider")
+	}
+
+	manager.Spec.Gateway.ConfigProvider = v1alpha1.HTTPConfigProvider
+	manager.Spec.Gateway.Tracing = &v1alpha1.GatewayTracing{}
+
+	if _, _, err := gateway.Sync(context.TODO(), manager); err == nil {
+		t.Error("The envoy gateway should reject the unsupported settings")
+	}
+}
+
+func TestDryRunOnlyReportsChanges(t *testing.T) {
+	scheme := createTestScheme()
+
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host:    "over.the.rainbow",
+			Routing: v1alpha1.SingleHost,
+		},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, manager)
+	recorder := record.NewFakeRecorder(50)
+	gateway := New(cl, scheme, recorder, "")
+	dryRun := gateway.DryRun()
+
+	if _, _, err := dryRun.Sync(context.TODO(), manager); err != nil {
+		t.Fatalf("Error while syncing: %s", err)
+	}
+
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "che", Namespace: "default"}, &appsv1.Deployment{}); !errors.IsNotFound(err) {
+		t.Errorf("The deployment should not have been created in the dry-run mode but got: %v", err)
+	}
+
+	close(recorder.Events)
+	reported := false
+	for e := range recorder.Events {
+		if e == "Normal DryRunCreated Would create Deployment default/che" {
+			reported = true
+		}
+	}
+	if !reported {
+		t.Error("The creation of the deployment should have been reported in an event")
+	}
+}
+
+func TestDryRunDoesNotGenerateConfigServerToken(t *testing.T) {
+	scheme := createTestScheme()
+
+	manager := &v1alpha1.CheManager{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "che",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host:    "over.the.rainbow",
+			Routing: v1alpha1.SingleHost,
+			Gateway: v1alpha1.GatewaySettings{
+				ConfigProvider: v1alpha1.HTTPConfigProvider,
+			},
+		},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, manager)
+	gateway := New(cl, scheme, nil, "")
+	dryRun := gateway.DryRun()
+
+	for i := 0; i < 2; i++ {
+		changed, secret, err := dryRun.reconcileConfigServerToken(dryRun.newSyncer(), context.TODO(), manager)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !changed || secret != nil {
+			t.Errorf("The creation of the token should have been reported without generating it, changed: %v, secret: %v", changed, secret)
+		}
+	}
+
+	if token, err := GetConfigServerToken(context.TODO(), cl, client.ObjectKey{Name: "che", Namespace: "default"}); err != nil || token != "" {
+		t.Errorf("The token should not have been created in the dry-run mode but got '%s', %v", token, err)
+	}
+}
@@ -15,6 +15,7 @@ import (
 )
 
 var (
+	// only compared when not using the server-side apply, the metadata and status are maintained by the cluster
 	ingressDiffOpts = cmp.Options{
 		cmpopts.IgnoreFields(v1beta1.Ingress{}, "TypeMeta", "ObjectMeta", "Status"),
 	}
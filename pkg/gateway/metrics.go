@@ -0,0 +1,97 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package gateway
+
+import (
+	"context"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/infrastructure"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/sync"
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	metricsEntryPointName  = "metrics"
+	gatewayMetricsPortName = "gateway-metrics"
+)
+
+var (
+	// ServiceMonitorGVK is the group, version and kind of the ServiceMonitor of the Prometheus operator.
+	// We don't depend on the Go API of the Prometheus operator and work with the unstructured objects instead.
+	ServiceMonitorGVK = schema.GroupVersionKind{
+		Group:   "monitoring.coreos.com",
+		Version: "v1",
+		Kind:    "ServiceMonitor",
+	}
+
+	// we only manage the spec of the service monitor
+	serviceMonitorDiffOpts = cmp.Comparer(func(x, y *unstructured.Unstructured) bool {
+		return equality.Semantic.DeepEqual(x.Object["spec"], y.Object["spec"])
+	})
+)
+
+func (g *CheGateway) reconcileServiceMonitor(syncer sync.Syncer, ctx context.Context, manager *v1alpha1.CheManager) (bool, error) {
+	if !infrastructure.MonitoringAvailable {
+		return false, nil
+	}
+
+	if manager.Spec.Gateway.Metrics == nil {
+		return false, syncer.Delete(ctx, getGatewayServiceMonitorStub(manager))
+	}
+
+	serviceMonitor := getGatewayServiceMonitorSpec(manager)
+	changed, _, err := syncer.Sync(ctx, manager, serviceMonitor, serviceMonitorDiffOpts)
+	return changed, err
+}
+
+// getGatewayServiceMonitorStub returns an empty service monitor object with just enough data set to be able to
+// find it in the cluster.
+func getGatewayServiceMonitorStub(manager *v1alpha1.CheManager) *unstructured.Unstructured {
+	ret := &unstructured.Unstructured{}
+	ret.SetGroupVersionKind(ServiceMonitorGVK)
+	ret.SetName(manager.Name)
+	ret.SetNamespace(manager.Namespace)
+	return ret
+}
+
+func getGatewayServiceMonitorSpec(manager *v1alpha1.CheManager) *unstructured.Unstructured {
+	endpoint := map[string]interface{}{
+		"port": gatewayMetricsPortName,
+		"path": "/metrics",
+	}
+
+	if interval := manager.Spec.Gateway.Metrics.ScrapeInterval; interval != "" {
+		endpoint["interval"] = interval
+	}
+
+	selector := map[string]interface{}{}
+	for k, v := range defaults.GetLabelsForComponent(manager, "deployment") {
+		selector[k] = v
+	}
+
+	ret := getGatewayServiceMonitorStub(manager)
+	ret.SetLabels(defaults.GetLabelsForComponent(manager, "metrics"))
+	ret.Object["spec"] = map[string]interface{}{
+		"selector": map[string]interface{}{
+			"matchLabels": selector,
+		},
+		"endpoints": []interface{}{endpoint},
+	}
+
+	return ret
+}
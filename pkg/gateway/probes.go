@@ -0,0 +1,132 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package gateway
+
+import (
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	pingEntryPointName = "ping"
+	pingPath           = "/ping"
+
+	// the path on the ping entrypoint that only answers once the gateway has loaded the gateway-wide routes
+	configSyncedPath = "/config-synced"
+)
+
+var (
+	// GatewayPingPort is the port on which the gateway answers the health checks.
+	GatewayPingPort = 8083
+
+	defaultLivenessTimings = v1alpha1.ProbeTimings{
+		InitialDelaySeconds: int32Ptr(0),
+		PeriodSeconds:       int32Ptr(10),
+		TimeoutSeconds:      int32Ptr(3),
+		FailureThreshold:    int32Ptr(3),
+	}
+
+	defaultReadinessTimings = v1alpha1.ProbeTimings{
+		InitialDelaySeconds: int32Ptr(0),
+		PeriodSeconds:       int32Ptr(5),
+		TimeoutSeconds:      int32Ptr(3),
+		FailureThreshold:    int32Ptr(3),
+	}
+
+	defaultStartupTimings = v1alpha1.ProbeTimings{
+		InitialDelaySeconds: int32Ptr(0),
+		PeriodSeconds:       int32Ptr(2),
+		TimeoutSeconds:      int32Ptr(3),
+		FailureThreshold:    int32Ptr(30),
+	}
+)
+
+// gatewayProbes are the probes of a single container.
+type gatewayProbes struct {
+	liveness  *corev1.Probe
+	readiness *corev1.Probe
+	startup   *corev1.Probe
+}
+
+// getGatewayProbes returns the probes of the gateway container. The gateway answers on the dedicated ping
+// entrypoint.
+func getGatewayProbes(manager *v1alpha1.CheManager) gatewayProbes {
+	handler := corev1.Handler{
+		HTTPGet: &corev1.HTTPGetAction{
+			Path:   pingPath,
+			Port:   intstr.FromInt(GatewayPingPort),
+			Scheme: corev1.URISchemeHTTP,
+		},
+	}
+
+	return getProbes(manager, handler)
+}
+
+// getConfigbumpProbes returns the probes of the configbump sidecar. The sidecar doesn't expose any health
+// endpoint so its readiness is checked by the gateway answering on the configSyncedPath. It is only routed by
+// the gateway-wide routes, which are always present, so this succeeds only once the sidecar has synced them into
+// the dynamic configuration directory and the gateway has loaded them. Without them the gateway would not serve
+// anything meaningful. The sidecar has no liveness probe, because the failures of the gateway must not restart it.
+func getConfigbumpProbes(manager *v1alpha1.CheManager) gatewayProbes {
+	handler := corev1.Handler{
+		HTTPGet: &corev1.HTTPGetAction{
+			Path:   configSyncedPath,
+			Port:   intstr.FromInt(GatewayPingPort),
+			Scheme: corev1.URISchemeHTTP,
+		},
+	}
+
+	probes := getProbes(manager, handler)
+	return gatewayProbes{readiness: probes.readiness}
+}
+
+func getProbes(manager *v1alpha1.CheManager, handler corev1.Handler) gatewayProbes {
+	cfg := manager.Spec.Gateway.Probes
+	if cfg == nil {
+		cfg = &v1alpha1.GatewayProbes{}
+	}
+
+	return gatewayProbes{
+		liveness:  getProbe(handler, cfg.Liveness, defaultLivenessTimings),
+		readiness: getProbe(handler, cfg.Readiness, defaultReadinessTimings),
+		startup:   getProbe(handler, cfg.Startup, defaultStartupTimings),
+	}
+}
+
+func getProbe(handler corev1.Handler, timings *v1alpha1.ProbeTimings, defaults v1alpha1.ProbeTimings) *corev1.Probe {
+	if timings == nil {
+		timings = &v1alpha1.ProbeTimings{}
+	}
+
+	// all the fields are set explicitly so that the probes don't differ from what the cluster defaults them to
+	return &corev1.Probe{
+		Handler:             handler,
+		InitialDelaySeconds: valueOrDefault(timings.InitialDelaySeconds, defaults.InitialDelaySeconds),
+		PeriodSeconds:       valueOrDefault(timings.PeriodSeconds, defaults.PeriodSeconds),
+		TimeoutSeconds:      valueOrDefault(timings.TimeoutSeconds, defaults.TimeoutSeconds),
+		FailureThreshold:    valueOrDefault(timings.FailureThreshold, defaults.FailureThreshold),
+		SuccessThreshold:    1,
+	}
+}
+
+func valueOrDefault(value *int32, def *int32) int32 {
+	if value != nil {
+		return *value
+	}
+	return *def
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
@@ -20,6 +20,8 @@ const (
 	generatedHostAnnotation = "openshift.io/host.generated"
 )
 
+// The route diff options are only used when not using the server-side apply. The wildcard policy and the weight are
+// defaulted by the cluster.
 var (
 	// used when the che manager spec defines the host explicitly
 	explicitHostRouteDiffOpts = cmp.Options{
@@ -40,6 +40,16 @@ func TestGatewayObjectsExist(t *testing.T, ctx context.Context, cl client.Client
 		t.Errorf("There should a configmap called '%s'", managerName)
 	}
 
+	errorPages := corev1.ConfigMap{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: managerName + "-error-pages", Namespace: ns}, &errorPages); err != nil {
+		t.Errorf("Failed to get a configmap called '%s-error-pages': %s", managerName, err)
+	}
+
+	routes := corev1.ConfigMap{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: managerName + "-routes", Namespace: ns}, &routes); err != nil {
+		t.Errorf("Failed to get a configmap called '%s-routes': %s", managerName, err)
+	}
+
 	depl := appsv1.Deployment{}
 	if err := cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, &depl); err != nil {
 		t.Errorf("Failed to get a deployment called '%s': %s", managerName, err)
@@ -68,6 +78,16 @@ func TestGatewayObjectsDontExist(t *testing.T, ctx context.Context, cl client.Cl
 		t.Errorf("Expected to not find the gateway configmap but the error we got was unexpected: %s", err)
 	}
 
+	err = cl.Get(ctx, client.ObjectKey{Name: managerName + "-error-pages", Namespace: ns}, cm)
+	if !errors.IsNotFound(err) {
+		t.Errorf("Expected to not find the gateway error pages configmap but the error we got was unexpected: %s", err)
+	}
+
+	err = cl.Get(ctx, client.ObjectKey{Name: managerName + "-routes", Namespace: ns}, cm)
+	if !errors.IsNotFound(err) {
+		t.Errorf("Expected to not find the gateway routes configmap but the error we got was unexpected: %s", err)
+	}
+
 	rb := &rbac.RoleBinding{}
 	err = cl.Get(ctx, client.ObjectKey{Name: managerName, Namespace: ns}, rb)
 	if !errors.IsNotFound(err) {
@@ -0,0 +1,401 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"sort"
+	"strconv"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	defaultTracingSampleRate  = 1.0
+	defaultTracingServiceName = "che-gateway"
+
+	// the annotation on the gateway pods that contains the hash of the static configuration. Traefik doesn't
+	// reload its static configuration so we need to restart the pods whenever it changes.
+	staticConfigHashAnnotation = "che.eclipse.org/gateway-static-config-hash"
+
+	staticConfigSecretsMountPath = "/etc/traefik/secrets/"
+
+	// how often the gateway asks the operator for the dynamic configuration in the HTTP provider mode
+	httpProviderPollInterval = "1s"
+)
+
+// A representation of the Traefik static config as we need it. This is in no way complete, the settings not
+// covered here can be supplied using the additional static config in the che manager spec.
+type traefikStaticConfig struct {
+	EntryPoints map[string]traefikStaticConfigEntryPoint `json:"entryPoints"`
+	Global      traefikStaticConfigGlobal                `json:"global"`
+	Providers   traefikStaticConfigProviders             `json:"providers"`
+	Log         traefikStaticConfigLog                   `json:"log"`
+	AccessLog   *traefikStaticConfigAccessLog            `json:"accessLog,omitempty"`
+	Metrics     *traefikStaticConfigMetrics              `json:"metrics,omitempty"`
+	Tracing     *traefikStaticConfigTracing              `json:"tracing,omitempty"`
+	Ping        *traefikStaticConfigPing                 `json:"ping,omitempty"`
+}
+
+type traefikStaticConfigEntryPoint struct {
+	Address          string                                  `json:"address"`
+	ForwardedHeaders *traefikStaticConfigForwardedHeaders    `json:"forwardedHeaders,omitempty"`
+	Transport        *traefikStaticConfigEntryPointTransport `json:"transport,omitempty"`
+}
+
+type traefikStaticConfigForwardedHeaders struct {
+	Insecure   bool     `json:"insecure,omitempty"`
+	TrustedIPs []string `json:"trustedIPs,omitempty"`
+}
+
+type traefikStaticConfigEntryPointTransport struct {
+	RespondingTimeouts traefikStaticConfigRespondingTimeouts `json:"respondingTimeouts"`
+}
+
+type traefikStaticConfigRespondingTimeouts struct {
+	ReadTimeout  string `json:"readTimeout,omitempty"`
+	WriteTimeout string `json:"writeTimeout,omitempty"`
+	IdleTimeout  string `json:"idleTimeout,omitempty"`
+}
+
+type traefikStaticConfigGlobal struct {
+	CheckNewVersion    bool `json:"checkNewVersion"`
+	SendAnonymousUsage bool `json:"sendAnonymousUsage"`
+}
+
+type traefikStaticConfigProviders struct {
+	File *traefikStaticConfigFileProvider `json:"file,omitempty"`
+	HTTP *traefikStaticConfigHTTPProvider `json:"http,omitempty"`
+
+	KubernetesCRD *traefikStaticConfigKubernetesCRDProvider `json:"kubernetesCRD,omitempty"`
+}
+
+type traefikStaticConfigKubernetesCRDProvider struct {
+	LabelSelector string   `json:"labelSelector"`
+	Namespaces    []string `json:"namespaces"`
+}
+
+type traefikStaticConfigHTTPProvider struct {
+	Endpoint     string `json:"endpoint"`
+	PollInterval string `json:"pollInterval"`
+}
+
+type traefikStaticConfigFileProvider struct {
+	Directory string `json:"directory"`
+	Watch     bool   `json:"watch"`
+}
+
+type traefikStaticConfigLog struct {
+	Level  string `json:"level"`
+	Format string `json:"format,omitempty"`
+}
+
+type traefikStaticConfigAccessLog struct {
+	Format string `json:"format,omitempty"`
+}
+
+type traefikStaticConfigMetrics struct {
+	Prometheus *traefikStaticConfigPrometheus `json:"prometheus,omitempty"`
+}
+
+type traefikStaticConfigPrometheus struct {
+	EntryPoint           string `json:"entryPoint"`
+	AddEntryPointsLabels bool   `json:"addEntryPointsLabels"`
+	AddServicesLabels    bool   `json:"addServicesLabels"`
+}
+
+type traefikStaticConfigPing struct {
+	EntryPoint string `json:"entryPoint"`
+}
+
+type traefikStaticConfigTracing struct {
+	ServiceName string                            `json:"serviceName"`
+	Zipkin      *traefikStaticConfigZipkinTracing `json:"zipkin,omitempty"`
+}
+
+type traefikStaticConfigZipkinTracing struct {
+	HTTPEndpoint string  `json:"httpEndpoint"`
+	SampleRate   float64 `json:"sampleRate"`
+	ID128Bit     bool    `json:"id128Bit"`
+}
+
+// getTraefikStaticConfig builds the static configuration of the gateway from the che manager spec and
+// returns it serialized as YAML.
+func getTraefikStaticConfig(manager *v1alpha1.CheManager) (string, error) {
+	settings := manager.Spec.Gateway
+
+	// the forwarded headers sent by the clients are only trusted if explicitly configured
+	var forwardedHeaders *traefikStaticConfigForwardedHeaders
+	if settings.InsecureForwardedHeaders {
+		forwardedHeaders = &traefikStaticConfigForwardedHeaders{Insecure: true}
+	} else if len(settings.TrustedForwardedHeadersIPs) > 0 {
+		forwardedHeaders = &traefikStaticConfigForwardedHeaders{TrustedIPs: settings.TrustedForwardedHeadersIPs}
+	}
+
+	var transport *traefikStaticConfigEntryPointTransport
+	if settings.Timeouts != nil {
+		transport = &traefikStaticConfigEntryPointTransport{
+			RespondingTimeouts: traefikStaticConfigRespondingTimeouts{
+				ReadTimeout:  settings.Timeouts.ReadTimeout,
+				WriteTimeout: settings.Timeouts.WriteTimeout,
+				IdleTimeout:  settings.Timeouts.IdleTimeout,
+			},
+		}
+	}
+
+	logLevel := settings.LogLevel
+	if logLevel == "" {
+		logLevel = "INFO"
+	}
+
+	var accessLog *traefikStaticConfigAccessLog
+	if settings.AccessLog != nil {
+		accessLog = &traefikStaticConfigAccessLog{
+			Format: settings.AccessLog.Format,
+		}
+	}
+
+	cfg := traefikStaticConfig{
+		EntryPoints: map[string]traefikStaticConfigEntryPoint{
+			"http": {
+				Address:          fmt.Sprintf(":%d", GatewayPort),
+				ForwardedHeaders: forwardedHeaders,
+				Transport:        transport,
+			},
+			"https": {
+				Address:          fmt.Sprintf(":%d", GatewaySecurePort),
+				ForwardedHeaders: forwardedHeaders,
+				Transport:        transport,
+			},
+			pingEntryPointName: {
+				Address: fmt.Sprintf(":%d", GatewayPingPort),
+			},
+		},
+		Ping: &traefikStaticConfigPing{
+			EntryPoint: pingEntryPointName,
+		},
+		Global: traefikStaticConfigGlobal{
+			CheckNewVersion:    false,
+			SendAnonymousUsage: false,
+		},
+		Providers: getProvidersConfig(manager),
+		Log: traefikStaticConfigLog{
+			Level:  logLevel,
+			Format: settings.LogFormat,
+		},
+		AccessLog: accessLog,
+	}
+
+	if settings.Metrics != nil {
+		cfg.EntryPoints[metricsEntryPointName] = traefikStaticConfigEntryPoint{
+			Address: fmt.Sprintf(":%d", GatewayMetricsPort),
+		}
+		// the services of the workspaces are prefixed with the workspace ID so labelling the metrics
+		// with the service names enables us to tell the workspaces apart
+		cfg.Metrics = &traefikStaticConfigMetrics{
+			Prometheus: &traefikStaticConfigPrometheus{
+				EntryPoint:           metricsEntryPointName,
+				AddEntryPointsLabels: true,
+				AddServicesLabels:    true,
+			},
+		}
+	}
+
+	if settings.Tracing != nil {
+		tracing, err := getTracingConfig(settings.Tracing)
+		if err != nil {
+			return "", err
+		}
+		cfg.Tracing = tracing
+	}
+
+	return marshalStaticConfig(cfg, settings.AdditionalStaticConfig)
+}
+
+func getProvidersConfig(manager *v1alpha1.CheManager) traefikStaticConfigProviders {
+	if UsesHTTPConfigProvider(manager) {
+		// the HTTP provider cannot send any headers, so the token is sent as a query parameter. The placeholder is
+		// replaced with the token when the gateway container starts.
+		endpoint := defaults.GetGatewayConfigEndpoint(manager) + "?" + ConfigServerTokenQueryParam + "=" + traefikConfigServerTokenPlaceholder
+		return traefikStaticConfigProviders{
+			HTTP: &traefikStaticConfigHTTPProvider{
+				Endpoint:     endpoint,
+				PollInterval: httpProviderPollInterval,
+			},
+		}
+	}
+
+	providers := traefikStaticConfigProviders{
+		File: &traefikStaticConfigFileProvider{
+			Directory: "/dynamic-config",
+			Watch:     true,
+		},
+	}
+
+	if UsesKubernetesCRDConfigProvider(manager) {
+		providers.KubernetesCRD = &traefikStaticConfigKubernetesCRDProvider{
+			LabelSelector: labels.FormatLabels(GetWorkspaceConfigLabels(manager)),
+			Namespaces:    GetGatewayWatchedNamespaces(manager),
+		}
+	}
+
+	return providers
+}
+
+func getTracingConfig(settings *v1alpha1.GatewayTracing) (*traefikStaticConfigTracing, error) {
+	if settings.Endpoint == "" {
+		return nil, fmt.Errorf("the endpoint of the tracing of the gateway must be specified")
+	}
+
+	sampleRate := defaultTracingSampleRate
+	if settings.SamplingRate != "" {
+		var err error
+		if sampleRate, err = strconv.ParseFloat(settings.SamplingRate, 64); err != nil || sampleRate < 0 || sampleRate > 1 {
+			return nil, fmt.Errorf("the sampling rate of the tracing of the gateway must be a number between 0 and 1 but was '%s'", settings.SamplingRate)
+		}
+	}
+
+	serviceName := settings.ServiceName
+	if serviceName == "" {
+		serviceName = defaultTracingServiceName
+	}
+
+	// the Zipkin tracer propagates the trace context to the backends using the B3 headers. We use the 128bit
+	// trace IDs so that the traces can be correlated with the W3C trace context used by OpenTelemetry.
+	return &traefikStaticConfigTracing{
+		ServiceName: serviceName,
+		Zipkin: &traefikStaticConfigZipkinTracing{
+			HTTPEndpoint: settings.Endpoint,
+			SampleRate:   sampleRate,
+			ID128Bit:     true,
+		},
+	}, nil
+}
+
+// getStaticConfigHash computes a hash of the static configuration and of the secrets referenced from it. This
+// is used to detect the changes in the configuration that require the restart of the gateway.
+func getStaticConfigHash(staticConfig *corev1.ConfigMap, secrets []corev1.Secret) string {
+	h := sha256.New()
+
+	hashData(h, staticConfig.Data)
+
+	for _, s := range secrets {
+		h.Write([]byte(s.Name))
+		h.Write([]byte{0})
+
+		data := map[string]string{}
+		for k, v := range s.Data {
+			data[k] = string(v)
+		}
+		for k, v := range s.StringData {
+			data[k] = v
+		}
+		hashData(h, data)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func hashData(h hash.Hash, data map[string]string) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(data[k]))
+		h.Write([]byte{0})
+	}
+}
+
+// getStaticConfigSecretsVolumesSpec returns the volumes with the secrets referenced from the static configuration
+// and the mounts of them in the gateway container.
+func getStaticConfigSecretsVolumesSpec(manager *v1alpha1.CheManager) ([]corev1.Volume, []corev1.VolumeMount) {
+	volumes := []corev1.Volume{}
+	mounts := []corev1.VolumeMount{}
+
+	for _, name := range manager.Spec.Gateway.StaticConfigSecrets {
+		volumeName := "static-config-secret-" + name
+		volumes = append(volumes, corev1.Volume{
+			Name: volumeName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: name,
+				},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      volumeName,
+			MountPath: staticConfigSecretsMountPath + name,
+			ReadOnly:  true,
+		})
+	}
+
+	return volumes, mounts
+}
+
+// marshalStaticConfig serializes the provided configuration into YAML, merging the additional configuration
+// (also in YAML) into it.
+func marshalStaticConfig(cfg interface{}, additional string) (string, error) {
+	if additional == "" {
+		out, err := yaml.Marshal(cfg)
+		return string(out), err
+	}
+
+	// go through JSON so that we get the same field names as in the YAML output
+	generatedJSON, err := json.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	generated := map[string]interface{}{}
+	if err = json.Unmarshal(generatedJSON, &generated); err != nil {
+		return "", err
+	}
+
+	extra := map[string]interface{}{}
+	if err = yaml.Unmarshal([]byte(additional), &extra); err != nil {
+		return "", fmt.Errorf("failed to parse the additional static configuration of the gateway: %s", err)
+	}
+
+	mergeConfig(generated, extra)
+
+	out, err := yaml.Marshal(generated)
+	return string(out), err
+}
+
+// mergeConfig merges the src into dst recursively. The values from src win over the values in dst except
+// for the maps which are merged.
+func mergeConfig(dst map[string]interface{}, src map[string]interface{}) {
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+
+		if srcIsMap && dstIsMap {
+			mergeConfig(dstMap, srcMap)
+		} else {
+			dst[k] = v
+		}
+	}
+}
@@ -41,10 +41,19 @@ const (
 var (
 	// Current is the infrastructure that we're currently running on. Can have an Undetected type if the detection fails.
 	Current Kind
+
+	// MonitoringAvailable is true if the Prometheus operator CRDs (e.g. ServiceMonitor) are installed in the cluster.
+	MonitoringAvailable bool
+
+	// TraefikCRDsAvailable is true if the Traefik CRDs (e.g. IngressRoute) are installed in the cluster.
+	TraefikCRDsAvailable bool
 )
 
 func init() {
-	Current = detect()
+	var groups []metav1.APIGroup
+	Current, groups = detect()
+	MonitoringAvailable = findAPIGroup(groups, "monitoring.coreos.com") != nil
+	TraefikCRDsAvailable = findAPIGroup(groups, "traefik.containo.us") != nil
 }
 
 // IsLatest returns true if the infrastructure is at its latest detected generation
@@ -55,23 +64,31 @@ func (k Kind) IsLatest() bool {
 	return true
 }
 
-func detect() Kind {
+// detect detects the kind of the infrastructure and returns it along with the API groups available in the cluster.
+func detect() (Kind, []metav1.APIGroup) {
+	undetected := Kind{Type: Undetected, Generation: Unknown}
+
 	kubeCfg, err := config.GetConfig()
 	if err != nil {
-		return Kind{Type: Undetected, Generation: Unknown}
+		return undetected, nil
 	}
 	discoveryClient, err := discovery.NewDiscoveryClientForConfig(kubeCfg)
 	if err != nil {
-		return Kind{Type: Undetected, Generation: Unknown}
+		return undetected, nil
 	}
 	apiList, err := discoveryClient.ServerGroups()
 	if err != nil {
-		return Kind{Type: Undetected, Generation: Unknown}
+		return undetected, nil
 	}
-	if findAPIGroup(apiList.Groups, "route.openshift.io") == nil {
+
+	return detectKind(apiList.Groups), apiList.Groups
+}
+
+func detectKind(groups []metav1.APIGroup) Kind {
+	if findAPIGroup(groups, "route.openshift.io") == nil {
 		return Kind{Type: Kubernetes, Generation: Unknown}
 	} else {
-		if findAPIGroup(apiList.Groups, "config.openshift.io") == nil {
+		if findAPIGroup(groups, "config.openshift.io") == nil {
 			return Kind{Type: OpenShift, Generation: V3}
 		} else {
 			return Kind{Type: OpenShift, Generation: V4}
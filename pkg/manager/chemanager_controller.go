@@ -14,60 +14,64 @@ package manager
 
 import (
 	"context"
-	"sync"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
 
 	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/gateway"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/infrastructure"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/metrics"
 	datasync "github.com/che-incubator/devworkspace-che-operator/pkg/sync"
+	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	"github.com/devfile/devworkspace-operator/pkg/config"
 	routev1 "github.com/openshift/api/route/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 	rbac "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// the finalizer making sure the workspace configuration is cleaned up before the che manager is deleted
+	cheManagerFinalizer = "chemanager.che.eclipse.org"
 )
 
 var (
-	log             = ctrl.Log.WithName("che")
-	currentManagers = map[client.ObjectKey]v1alpha1.CheManager{}
-	managerAccess   = sync.Mutex{}
+	log = ctrl.Log.WithName("che")
 )
 
 type CheReconciler struct {
-	client  client.Client
-	scheme  *runtime.Scheme
-	gateway gateway.CheGateway
-	syncer  datasync.Syncer
-}
-
-// GetCurrentManagers returns a map of all che managers (keyed by their namespaced name)
-// the the che manager controller currently knows of. This returns any meaningful data
-// only after reconciliation has taken place.
-//
-// If this method is called from another controller, it effectively couples that controller
-// with the che manager controller. Such controller will therefore have to run in the same
-// process as the che manager controller. On the other hand, using this method, and somehow
-// tolerating its eventual consistency, makes the other controller more efficient such that
-// it doesn't have to find the che managers in the cluster (which is what che manager reconciler
-// is doing).
-//
-// If need be, this method can be replaced by a simply calling client.List to get all the che
-// managers in the cluster.
-func GetCurrentManagers() map[client.ObjectKey]v1alpha1.CheManager {
-	managerAccess.Lock()
-	defer managerAccess.Unlock()
-
-	ret := map[client.ObjectKey]v1alpha1.CheManager{}
-
-	for k, v := range currentManagers {
-		ret[k] = v
-	}
+	// FieldManager is the field manager the objects are synced with using the server-side apply. The server-side
+	// apply is not used if it is empty. It needs to be set before calling SetupWithManager.
+	FieldManager string
+	// DryRun makes the reconciler only log and report the changes it would make to the gateway objects and
+	// the gateway configuration of the workspaces, see sync.Syncer.DryRun. It needs to be set before calling
+	// SetupWithManager.
+	DryRun bool
 
-	return ret
+	client   client.Client
+	scheme   *runtime.Scheme
+	gateway  gateway.CheGateway
+	syncer   datasync.Syncer
+	recorder record.EventRecorder
 }
 
 // New returns a new instance of the Che manager reconciler. This is mainly useful for
@@ -76,16 +80,21 @@ func New(cl client.Client, scheme *runtime.Scheme) CheReconciler {
 	return CheReconciler{
 		client:  cl,
 		scheme:  scheme,
-		gateway: gateway.New(cl, scheme),
-		syncer:  datasync.New(cl, scheme),
+		gateway: gateway.New(cl, scheme, nil, ""),
+		syncer:  datasync.New(cl, scheme, nil, ""),
 	}
 }
 
 func (r *CheReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.client = mgr.GetClient()
 	r.scheme = mgr.GetScheme()
-	r.gateway = gateway.New(mgr.GetClient(), mgr.GetScheme())
-	r.syncer = datasync.New(r.client, r.scheme)
+	r.recorder = mgr.GetEventRecorderFor("che-manager")
+	r.gateway = gateway.New(mgr.GetClient(), mgr.GetScheme(), r.recorder, r.FieldManager)
+	r.syncer = datasync.New(r.client, r.scheme, r.recorder, r.FieldManager)
+	if r.DryRun {
+		r.gateway = r.gateway.DryRun()
+		r.syncer = r.syncer.DryRun()
+	}
 
 	bld := ctrl.NewControllerManagedBy(mgr).
 		For(&v1alpha1.CheManager{}).
@@ -100,9 +109,87 @@ func (r *CheReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if infrastructure.Current.Type == infrastructure.OpenShift {
 		bld.Owns(&routev1.Route{})
 	}
+	// the gateway needs to be restarted when the secrets referenced from its static configuration change. Only
+	// the labeled secrets are cached and watched, see the filteredcache package.
+	bld.Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.managersReferencingSecret)})
+	// the gateway reading the Traefik CRDs needs to be able to read them in the namespaces of the new workspaces.
+	// The namespaces of the workspaces only change when the routings are created or deleted.
+	bld.Watches(&source.Kind{Type: &dwo.WorkspaceRouting{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.managersOfRouting)},
+		builder.WithPredicates(predicate.Funcs{
+			UpdateFunc:  func(event.UpdateEvent) bool { return false },
+			GenericFunc: func(event.GenericEvent) bool { return false },
+		}))
+	if infrastructure.MonitoringAvailable {
+		serviceMonitor := &unstructured.Unstructured{}
+		serviceMonitor.SetGroupVersionKind(gateway.ServiceMonitorGVK)
+		bld.Owns(serviceMonitor)
+	}
 	return bld.Complete(r)
 }
 
+func (r *CheReconciler) managersOfRouting(mo handler.MapObject) []reconcile.Request {
+	routing, ok := mo.Object.(*dwo.WorkspaceRouting)
+	if !ok || routing.Spec.RoutingClass != CheRoutingClass {
+		return []reconcile.Request{}
+	}
+
+	manager := &v1alpha1.CheManager{}
+	key := GetCheManagerKeyOfRouting(routing)
+	if key.Name != "" {
+		if err := r.client.Get(context.Background(), key, manager); err != nil {
+			if !errors.IsNotFound(err) {
+				log.Error(err, "failed to get the che manager of a workspace routing", "routing", mo.Meta.GetName(), "namespace", mo.Meta.GetNamespace())
+			}
+			return []reconcile.Request{}
+		}
+	} else {
+		// the routings not specifying the che manager are handled by the only che manager in the cluster, if any
+		managers := v1alpha1.CheManagerList{}
+		if err := r.client.List(context.Background(), &managers); err != nil {
+			log.Error(err, "failed to list the che managers to find the one handling a workspace routing", "routing", mo.Meta.GetName(), "namespace", mo.Meta.GetNamespace())
+			return []reconcile.Request{}
+		}
+
+		if len(managers.Items) != 1 {
+			return []reconcile.Request{}
+		}
+		manager = &managers.Items[0]
+	}
+
+	// only the gateways reading the Traefik CRDs need to know about the namespaces of the workspaces
+	if !readsWorkspaceNamespaces(manager) {
+		return []reconcile.Request{}
+	}
+
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: manager.Name, Namespace: manager.Namespace}}}
+}
+
+// readsWorkspaceNamespaces returns true if the gateway of the che manager reads its configuration from
+// the namespaces of the workspaces, which therefore need to be reported in the status of the che manager.
+func readsWorkspaceNamespaces(manager *v1alpha1.CheManager) bool {
+	return manager.Spec.Routing == v1alpha1.SingleHost && gateway.UsesKubernetesCRDConfigProvider(manager)
+}
+
+func (r *CheReconciler) managersReferencingSecret(mo handler.MapObject) []reconcile.Request {
+	managers := v1alpha1.CheManagerList{}
+	if err := r.client.List(context.Background(), &managers, client.InNamespace(mo.Meta.GetNamespace())); err != nil {
+		log.Error(err, "failed to list the che managers to find the ones referencing a secret", "secret", mo.Meta.GetName(), "namespace", mo.Meta.GetNamespace())
+		return []reconcile.Request{}
+	}
+
+	ret := []reconcile.Request{}
+	for _, m := range managers.Items {
+		for _, name := range m.Spec.Gateway.StaticConfigSecrets {
+			if name == mo.Meta.GetName() {
+				ret = append(ret, reconcile.Request{NamespacedName: client.ObjectKey{Name: m.Name, Namespace: m.Namespace}})
+				break
+			}
+		}
+	}
+
+	return ret
+}
+
 func (r *CheReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
 
@@ -119,32 +206,134 @@ func (r *CheReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	}
 
 	if current.GetDeletionTimestamp() != nil {
-		return ctrl.Result{}, r.finalize(current)
+		return ctrl.Result{}, r.finalize(ctx, current)
+	}
+
+	if !hasFinalizer(current) {
+		current.SetFinalizers(append(current.GetFinalizers(), cheManagerFinalizer))
+		if err = r.client.Update(ctx, current); err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 
 	var changed bool
 	var host string
 
 	if changed, host, err = r.reconcileGateway(ctx, current); err != nil {
+		r.recordEvent(current, corev1.EventTypeWarning, "GatewayFailed", "Failed to reconcile the gateway: %s", err)
+		return ctrl.Result{}, err
+	}
+
+	provider, err := r.reconcileGatewayConfigProvider(ctx, current)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	pending, namespaces, err := r.reconcileWorkspaceRoutings(ctx, current)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	res, err := r.updateStatus(ctx, current, changed, host)
+	return r.updateStatus(ctx, current, changed, host, pending, namespaces, provider)
+}
+
+// reconcileWorkspaceRoutings returns the number of the workspace routings that have not been re-solved in the current
+// routing mode yet, i.e. whose endpoints are still exposed in the previous routing mode. The workspace routings are
+// re-solved by the routing reconciler, which is triggered by the changes of the che manager. If the gateway reads
+// the workspace configuration from the Traefik CRDs, the sorted namespaces of the workspace routings are returned,
+// too.
+func (r *CheReconciler) reconcileWorkspaceRoutings(ctx context.Context, manager *v1alpha1.CheManager) (int, []string, error) {
+	routings, err := ListRoutingsOfCheManager(ctx, r.client, manager)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	pending := 0
+	routed := 0
+	namespaces := map[string]bool{}
+	for _, routing := range routings {
+		if routing.DeletionTimestamp != nil {
+			continue
+		}
+
+		namespaces[routing.Namespace] = true
+
+		// the workspaces of the failed routings are not reachable
+		if routing.Status.Phase == dwo.RoutingFailed {
+			continue
+		}
+
+		routed++
+
+		if mode, ok := getExposedRoutingMode(&routing); ok && mode != manager.Spec.Routing {
+			pending++
+		}
+	}
+
+	metrics.RoutedWorkspaces.WithLabelValues(manager.Namespace, manager.Name).Set(float64(routed))
+
+	if !readsWorkspaceNamespaces(manager) {
+		return pending, nil, nil
+	}
+
+	// nil rather than empty so that the list doesn't differ from the one read back from the status
+	var ret []string
+	for ns := range namespaces {
+		ret = append(ret, ns)
+	}
+	sort.Strings(ret)
+
+	return pending, ret, nil
+}
+
+// reconcileGatewayConfigProvider removes the gateway configuration of the workspaces stored for the config provider
+// the che manager no longer uses. This is only done once the config provider changes, or the gateway is no longer
+// used at all, and the routing solver only ever stores the configuration for the current config provider. Returns
+// the config provider the configuration of the workspaces is stored for now.
+func (r *CheReconciler) reconcileGatewayConfigProvider(ctx context.Context, manager *v1alpha1.CheManager) (v1alpha1.GatewayConfigProvider, error) {
+	var provider v1alpha1.GatewayConfigProvider
+	if manager.Spec.Routing == v1alpha1.SingleHost {
+		provider = gateway.GetConfigProvider(manager)
+	}
+
+	previous := manager.Status.GatewayConfigProvider
+	if provider == previous {
+		return provider, nil
+	}
+
+	if provider != v1alpha1.ConfigMapsConfigProvider {
+		if err := r.deleteWorkspaceConfigMaps(ctx, manager); err != nil {
+			return previous, err
+		}
+	}
 
-	if err == nil {
-		// update the shared map
-		managerAccess.Lock()
-		defer managerAccess.Unlock()
+	if provider != v1alpha1.KubernetesCRDConfigProvider && infrastructure.TraefikCRDsAvailable {
+		if err := r.deleteWorkspaceTraefikObjects(ctx, manager); err != nil {
+			return previous, err
+		}
+	}
 
-		currentManagers[req.NamespacedName] = *current
+	if previous != "" {
+		log.Info("Removed the gateway configuration of the workspaces stored for the previous config provider", "namespace", manager.Namespace, "name", manager.Name,
+			"previous", previous, "current", provider)
+		r.recordEvent(manager, corev1.EventTypeNormal, "GatewayConfigProviderChanged", "Removed the gateway configuration of the workspaces stored for the '%s' config provider", previous)
 	}
 
-	return res, err
+	return provider, nil
 }
 
-func (r *CheReconciler) updateStatus(ctx context.Context, manager *v1alpha1.CheManager, changed bool, host string) (ctrl.Result, error) {
+func (r *CheReconciler) updateStatus(ctx context.Context, manager *v1alpha1.CheManager, changed bool, host string, pendingRoutings int, workspaceNamespaces []string, configProvider v1alpha1.GatewayConfigProvider) (ctrl.Result, error) {
 	currentPhase := manager.Status.GatewayPhase
 	currentHost := manager.Status.GatewayHost
+	currentWorkspaceRouting := manager.Status.WorkspaceRouting
+	currentPendingRoutings := manager.Status.PendingWorkspaceRoutings
+	currentWorkspaceNamespaces := manager.Status.WorkspaceNamespaces
+	currentConfigProvider := manager.Status.GatewayConfigProvider
+
+	manager.Status.GatewayConfigProvider = configProvider
+
+	// the gateway picks up the new namespaces in the next reconciliation triggered by the status update
+	manager.Status.WorkspaceNamespaces = workspaceNamespaces
 
 	if manager.Spec.Routing == v1alpha1.MultiHost {
 		manager.Status.GatewayPhase = v1alpha1.GatewayPhaseInactive
@@ -156,18 +345,178 @@ func (r *CheReconciler) updateStatus(ctx context.Context, manager *v1alpha1.CheM
 
 	manager.Status.GatewayHost = host
 
-	if currentPhase != manager.Status.GatewayPhase || currentHost != manager.Status.GatewayHost {
+	manager.Status.PendingWorkspaceRoutings = pendingRoutings
+	if pendingRoutings == 0 {
+		manager.Status.WorkspaceRouting = manager.Spec.Routing
+	}
+
+	if currentPendingRoutings != pendingRoutings || currentWorkspaceRouting != manager.Status.WorkspaceRouting {
+		log.Info("Workspace routings re-solved in the routing mode of the che manager", "namespace", manager.Namespace, "name", manager.Name,
+			"routing", manager.Spec.Routing, "pending", pendingRoutings)
+
+		if pendingRoutings > 0 {
+			r.recordEvent(manager, corev1.EventTypeNormal, "RoutingModeTransition", "%d workspace routings are waiting to be exposed in the %s mode", pendingRoutings, manager.Spec.Routing)
+		} else if currentWorkspaceRouting != manager.Status.WorkspaceRouting {
+			r.recordEvent(manager, corev1.EventTypeNormal, "RoutingModeTransition", "All workspace routings are exposed in the %s mode", manager.Spec.Routing)
+		}
+	}
+
+	if currentPhase != manager.Status.GatewayPhase {
+		r.recordEvent(manager, corev1.EventTypeNormal, "GatewayPhaseChanged", "The gateway phase changed from '%s' to '%s'", currentPhase, manager.Status.GatewayPhase)
+	}
+
+	if currentPhase != manager.Status.GatewayPhase || currentHost != manager.Status.GatewayHost ||
+		currentWorkspaceRouting != manager.Status.WorkspaceRouting || currentPendingRoutings != pendingRoutings ||
+		!reflect.DeepEqual(currentWorkspaceNamespaces, workspaceNamespaces) || currentConfigProvider != configProvider {
 		return ctrl.Result{Requeue: true}, r.client.Status().Update(ctx, manager)
 	}
 
+	if pendingRoutings > 0 {
+		// poll for the progress also in case the re-solved workspace routings are not changed by the solver
+		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+	}
+
 	return ctrl.Result{Requeue: currentPhase == v1alpha1.GatewayPhaseInitializing}, nil
 }
 
-func (r *CheReconciler) finalize(router *v1alpha1.CheManager) error {
-	// implement if needed
+// finalize cleans up the gateway and the workspace configuration of the che manager before it is deleted. Some objects
+// of the gateway are outside of the namespace of the che manager and the gateway configuration of the workspaces is
+// not owned by the che manager, so they need to be deleted explicitly. The workspace routings
+// of the che manager can't be served by anything anymore, so they're marked as failed, see markRoutingFailed.
+func (r *CheReconciler) finalize(ctx context.Context, manager *v1alpha1.CheManager) error {
+	if !hasFinalizer(manager) {
+		return nil
+	}
+
+	// the gateway has objects outside of the namespace of the che manager which are not garbage collected with it
+	if err := r.gateway.Delete(ctx, manager); err != nil {
+		return err
+	}
+
+	if err := r.deleteWorkspaceConfigMaps(ctx, manager); err != nil {
+		return err
+	}
+
+	routings, err := ListRoutingsOfCheManager(ctx, r.client, manager)
+	if err != nil {
+		return err
+	}
+
+	reason := fmt.Sprintf("the che manager %s/%s has been deleted", manager.Namespace, manager.Name)
+	failed := 0
+	for i := range routings {
+		routing := &routings[i]
+
+		// the Traefik objects are owned by the routings, which are not deleted with the che manager
+		if err = gateway.DeleteWorkspaceTraefikObjects(ctx, r.client, &r.syncer, manager, routing.Namespace, routing.Spec.WorkspaceId); err != nil {
+			return err
+		}
+
+		if routing.DeletionTimestamp != nil || routing.Annotations[defaults.ConfigAnnotationRoutingFailureReason] == reason {
+			continue
+		}
+
+		if err = r.markRoutingFailed(ctx, routing, reason); err != nil {
+			return err
+		}
+		failed++
+	}
+
+	metrics.RoutedWorkspaces.DeleteLabelValues(manager.Namespace, manager.Name)
+
+	r.recordEvent(manager, corev1.EventTypeNormal, "Finalized", "Deleted the gateway configuration of the workspaces and marked %d workspace routings as failed", failed)
+
+	finalizers := []string{}
+	for _, f := range manager.GetFinalizers() {
+		if f != cheManagerFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	manager.SetFinalizers(finalizers)
+
+	return r.client.Update(ctx, manager)
+}
+
+// deleteWorkspaceConfigMaps deletes the config maps with the gateway configuration of the workspaces of the che
+// manager.
+func (r *CheReconciler) deleteWorkspaceConfigMaps(ctx context.Context, manager *v1alpha1.CheManager) error {
+	selector := labels.SelectorFromSet(defaults.GetLabelsForComponent(manager, "gateway-config"))
+	workspaceIDExists, err := labels.NewRequirement(config.WorkspaceIDLabel, selection.Exists, nil)
+	if err != nil {
+		return err
+	}
+	selector = selector.Add(*workspaceIDExists)
+
+	configMaps := corev1.ConfigMapList{}
+	if err = r.client.List(ctx, &configMaps, client.InNamespace(manager.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return err
+	}
+
+	for i := range configMaps.Items {
+		if err = r.syncer.Delete(ctx, &configMaps.Items[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteWorkspaceTraefikObjects deletes the Traefik objects with the gateway configuration of the workspaces of
+// the che manager in all the namespaces.
+func (r *CheReconciler) deleteWorkspaceTraefikObjects(ctx context.Context, manager *v1alpha1.CheManager) error {
+	for _, gvk := range []schema.GroupVersionKind{gateway.IngressRouteGVK, gateway.MiddlewareGVK} {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(schema.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind + "List"})
+
+		if err := r.client.List(ctx, list, client.MatchingLabels(gateway.GetWorkspaceConfigLabels(manager))); err != nil {
+			return err
+		}
+
+		for i := range list.Items {
+			if err := r.syncer.Delete(ctx, &list.Items[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// markRoutingFailed annotates the workspace routing with the reason of its failure. The status of the routing is
+// owned by the routing reconciler, which puts the routing into the failed phase in its next reconciliation, because
+// the routing solver refuses to solve the annotated routings. The reason is also recorded as an event.
+func (r *CheReconciler) markRoutingFailed(ctx context.Context, routing *dwo.WorkspaceRouting, reason string) error {
+	log.Info("Marking the workspace routing as failed", "namespace", routing.Namespace, "name", routing.Name, "reason", reason)
+
+	if routing.Annotations == nil {
+		routing.Annotations = map[string]string{}
+	}
+	routing.Annotations[defaults.ConfigAnnotationRoutingFailureReason] = reason
+	if err := r.client.Update(ctx, routing); err != nil {
+		return err
+	}
+
+	r.recordEvent(routing, corev1.EventTypeWarning, "RoutingFailed", "The workspace routing failed: %s", reason)
+
 	return nil
 }
 
+// recordEvent records the event on the object. The reconcilers created by New don't record any events.
+func (r *CheReconciler) recordEvent(obj runtime.Object, eventType string, reason string, messageFmt string, args ...interface{}) {
+	if r.recorder != nil {
+		r.recorder.Eventf(obj, eventType, reason, messageFmt, args...)
+	}
+}
+
+func hasFinalizer(manager *v1alpha1.CheManager) bool {
+	for _, f := range manager.GetFinalizers() {
+		if f == cheManagerFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *CheReconciler) reconcileGateway(ctx context.Context, manager *v1alpha1.CheManager) (bool, string, error) {
 	var changed bool
 	var err error
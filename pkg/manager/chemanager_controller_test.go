@@ -8,17 +8,26 @@ import (
 	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/gateway"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/infrastructure"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/metrics"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/sync"
+	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	"github.com/devfile/devworkspace-operator/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
 	rbac "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
@@ -29,6 +38,14 @@ func createTestScheme() *runtime.Scheme {
 	utilruntime.Must(corev1.AddToScheme(scheme))
 	utilruntime.Must(appsv1.AddToScheme(scheme))
 	utilruntime.Must(rbac.AddToScheme(scheme))
+	utilruntime.Must(dwo.AddToScheme(scheme))
+
+	// the fake client needs to know the list kinds of the Traefik objects we work with as unstructured
+	for _, gvk := range []schema.GroupVersionKind{gateway.IngressRouteGVK, gateway.MiddlewareGVK} {
+		scheme.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+		scheme.AddKnownTypeWithName(gvk.GroupVersion().WithKind(gvk.Kind+"List"), &unstructured.UnstructuredList{})
+	}
+
 	return scheme
 }
 
@@ -48,7 +65,7 @@ func TestCreatesObjectsInSingleHost(t *testing.T) {
 		},
 	})
 
-	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, scheme), syncer: sync.New(cl, scheme)}
+	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, scheme, nil, ""), syncer: sync.New(cl, scheme, nil, "")}
 
 	_, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: managerName, Namespace: ns}})
 	if err != nil {
@@ -118,7 +135,7 @@ func TestUpdatesObjectsInSingleHost(t *testing.T) {
 
 	ctx := context.TODO()
 
-	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, scheme), syncer: sync.New(cl, scheme)}
+	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, scheme, nil, ""), syncer: sync.New(cl, scheme, nil, "")}
 
 	_, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: managerName, Namespace: ns}})
 	if err != nil {
@@ -157,7 +174,7 @@ func TestDoesntCreateObjectsInMultiHost(t *testing.T) {
 		},
 	})
 
-	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, scheme), syncer: sync.New(cl, scheme)}
+	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, scheme, nil, ""), syncer: sync.New(cl, scheme, nil, "")}
 
 	_, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: managerName, Namespace: ns}})
 	if err != nil {
@@ -223,7 +240,7 @@ func TestDeletesObjectsInMultiHost(t *testing.T) {
 
 	ctx := context.TODO()
 
-	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, scheme), syncer: sync.New(cl, scheme)}
+	reconciler := CheReconciler{client: cl, scheme: scheme, gateway: gateway.New(cl, scheme, nil, ""), syncer: sync.New(cl, scheme, nil, "")}
 
 	_, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: managerName, Namespace: ns}})
 	if err != nil {
@@ -232,3 +249,269 @@ func TestDeletesObjectsInMultiHost(t *testing.T) {
 
 	gateway.TestGatewayObjectsDontExist(t, ctx, cl, managerName, ns)
 }
+
+func TestFinalizeCleansUpWorkspaces(t *testing.T) {
+	ctx := context.TODO()
+	scheme := createTestScheme()
+
+	workspaceConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "wsid",
+			Namespace: "ns",
+			Labels:    defaults.GetLabelsForComponent(testCheManager("che"), "gateway-config"),
+		},
+	}
+	workspaceConfig.Labels[config.WorkspaceIDLabel] = "wsid"
+
+	cl := fake.NewFakeClientWithScheme(scheme, testCheManager("che"), testRouting("routing", "che"), workspaceConfig)
+
+	reconciler := New(cl, scheme)
+
+	if _, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "che", Namespace: "ns"}}); err != nil {
+		t.Fatalf("Failed to reconcile che manager with error: %s", err)
+	}
+
+	manager := &v1alpha1.CheManager{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: "che", Namespace: "ns"}, manager); err != nil {
+		t.Fatal(err)
+	}
+
+	if !hasFinalizer(manager) {
+		t.Fatal("The che manager should have the finalizer after the reconciliation")
+	}
+
+	// the fake client deletes the objects immediately, so we need to simulate the deletion with finalizers
+	now := metav1.Now()
+	manager.DeletionTimestamp = &now
+	if err := cl.Update(ctx, manager); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "che", Namespace: "ns"}}); err != nil {
+		t.Fatalf("Failed to finalize che manager with error: %s", err)
+	}
+
+	if err := cl.Get(ctx, client.ObjectKey{Name: "wsid", Namespace: "ns"}, &corev1.ConfigMap{}); !errors.IsNotFound(err) {
+		t.Errorf("The gateway configuration of the workspace should have been deleted but got: %v", err)
+	}
+
+	routing := &dwo.WorkspaceRouting{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: "routing", Namespace: "ws"}, routing); err != nil {
+		t.Fatal(err)
+	}
+
+	if routing.Status.Phase == dwo.RoutingFailed {
+		t.Error("The status of the routing is owned by the routing reconciler and should not have been changed")
+	}
+
+	if routing.Annotations[defaults.ConfigAnnotationRoutingFailureReason] == "" {
+		t.Error("The routing should have been annotated with the failure reason")
+	}
+
+	manager = &v1alpha1.CheManager{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: "che", Namespace: "ns"}, manager); err != nil {
+		t.Fatal(err)
+	}
+
+	if hasFinalizer(manager) {
+		t.Error("The finalizer should have been removed from the che manager")
+	}
+}
+
+func TestReportsRoutingModeTransition(t *testing.T) {
+	ctx := context.TODO()
+	scheme := createTestScheme()
+
+	manager := testCheManager("che")
+	manager.Spec.Routing = v1alpha1.MultiHost
+	manager.Status.GatewayConfigProvider = v1alpha1.ConfigMapsConfigProvider
+
+	routing := testRouting("routing", "che")
+	routing.Status.ExposedEndpoints = map[string]dwo.ExposedEndpointList{
+		"m1": {{Name: "e1", Url: "https://over.the.rainbow/routing/m1/9999/"}},
+	}
+
+	workspaceConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "routing",
+			Namespace: "ns",
+			Labels:    defaults.GetLabelsForComponent(manager, "gateway-config"),
+		},
+	}
+	workspaceConfig.Labels[config.WorkspaceIDLabel] = "routing"
+
+	cl := fake.NewFakeClientWithScheme(scheme, manager, routing, workspaceConfig)
+	reconciler := New(cl, scheme)
+
+	getStatus := func() v1alpha1.CheManagerStatus {
+		if _, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "che", Namespace: "ns"}}); err != nil {
+			t.Fatalf("Failed to reconcile che manager with error: %s", err)
+		}
+		m := &v1alpha1.CheManager{}
+		if err := cl.Get(ctx, client.ObjectKey{Name: "che", Namespace: "ns"}, m); err != nil {
+			t.Fatal(err)
+		}
+		return m.Status
+	}
+
+	status := getStatus()
+	if status.PendingWorkspaceRoutings != 1 {
+		t.Errorf("The routing solved in the singlehost mode should be pending but there were %d pending routings", status.PendingWorkspaceRoutings)
+	}
+	if status.WorkspaceRouting == v1alpha1.MultiHost {
+		t.Error("The workspaces should not be reported as exposed in the multihost mode while the routing is pending")
+	}
+
+	if err := cl.Get(ctx, client.ObjectKey{Name: "routing", Namespace: "ns"}, &corev1.ConfigMap{}); !errors.IsNotFound(err) {
+		t.Errorf("The singlehost gateway configuration of the workspace should have been deleted but got: %v", err)
+	}
+
+	// simulate the routing solver re-solving the routing in the multihost mode
+	if err := cl.Get(ctx, client.ObjectKey{Name: "routing", Namespace: "ws"}, routing); err != nil {
+		t.Fatal(err)
+	}
+	routing.Status.ExposedEndpoints = map[string]dwo.ExposedEndpointList{
+		"m1": {{Name: "e1", Url: "https://routing-m1-9999.over.the.rainbow/"}},
+	}
+	if err := cl.Update(ctx, routing); err != nil {
+		t.Fatal(err)
+	}
+
+	status = getStatus()
+	if status.PendingWorkspaceRoutings != 0 {
+		t.Errorf("There should be no pending routings but there were %d", status.PendingWorkspaceRoutings)
+	}
+	if status.WorkspaceRouting != v1alpha1.MultiHost {
+		t.Errorf("The workspaces should be reported as exposed in the multihost mode but were reported as '%s'", status.WorkspaceRouting)
+	}
+}
+
+func TestReportsWorkspaceNamespacesForKubernetesCRDProvider(t *testing.T) {
+	ctx := context.TODO()
+	scheme := createTestScheme()
+
+	manager := testCheManager("che")
+	manager.Spec.Gateway.ConfigProvider = v1alpha1.KubernetesCRDConfigProvider
+
+	other := testRouting("other", "che")
+	other.Namespace = "another-ws"
+
+	cl := fake.NewFakeClientWithScheme(scheme, manager, testRouting("routing", "che"), other)
+	reconciler := New(cl, scheme)
+
+	infrastructure.TraefikCRDsAvailable = true
+	defer func() { infrastructure.TraefikCRDsAvailable = false }()
+
+	if _, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "che", Namespace: "ns"}}); err != nil {
+		t.Fatalf("Failed to reconcile che manager with error: %s", err)
+	}
+
+	m := &v1alpha1.CheManager{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: "che", Namespace: "ns"}, m); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.Status.WorkspaceNamespaces, []string{"another-ws", "ws"}) {
+		t.Errorf("Unexpected workspace namespaces: %v", m.Status.WorkspaceNamespaces)
+	}
+
+	// the new namespaces are granted to the gateway in the next reconciliation
+	if _, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "che", Namespace: "ns"}}); err != nil {
+		t.Fatalf("Failed to reconcile che manager with error: %s", err)
+	}
+	if err := cl.Get(ctx, client.ObjectKey{Name: "ns-che-gateway", Namespace: "another-ws"}, &rbac.Role{}); err != nil {
+		t.Errorf("The gateway should have been granted the access to the namespace of the workspace: %s", err)
+	}
+
+	requests := reconciler.managersOfRouting(handler.MapObject{Meta: other, Object: other})
+	if len(requests) != 1 || requests[0].NamespacedName != (types.NamespacedName{Name: "che", Namespace: "ns"}) {
+		t.Errorf("The changes of the routing should trigger the reconciliation of its che manager but got: %v", requests)
+	}
+
+	manager = &v1alpha1.CheManager{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: "che", Namespace: "ns"}, manager); err != nil {
+		t.Fatal(err)
+	}
+	manager.Spec.Gateway.ConfigProvider = v1alpha1.ConfigMapsConfigProvider
+	if err := cl.Update(ctx, manager); err != nil {
+		t.Fatal(err)
+	}
+	if requests = reconciler.managersOfRouting(handler.MapObject{Meta: other, Object: other}); len(requests) != 0 {
+		t.Errorf("The routings should not trigger the reconciliation of the che managers not reading the Traefik CRDs but got: %v", requests)
+	}
+}
+
+func TestFinalizeDeletesGatewayObjectsOutsideOfNamespace(t *testing.T) {
+	ctx := context.TODO()
+	scheme := createTestScheme()
+
+	infrastructure.TraefikCRDsAvailable = true
+	defer func() { infrastructure.TraefikCRDsAvailable = false }()
+
+	manager := testCheManager("che")
+	manager.Spec.Gateway.ConfigProvider = v1alpha1.KubernetesCRDConfigProvider
+
+	// the configuration of the workspace created by the routing solver
+	ingressRoute := &unstructured.Unstructured{}
+	ingressRoute.SetGroupVersionKind(gateway.IngressRouteGVK)
+	ingressRoute.SetName("routing")
+	ingressRoute.SetNamespace("ws")
+	ingressRoute.SetLabels(gateway.GetWorkspaceTraefikObjectLabels(manager, "routing"))
+
+	cl := fake.NewFakeClientWithScheme(scheme, manager, testRouting("routing", "che"), ingressRoute)
+	reconciler := New(cl, scheme)
+
+	// the first round finds the namespaces of the workspaces, the second grants the gateway the access to them
+	for i := 0; i < 2; i++ {
+		if _, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "che", Namespace: "ns"}}); err != nil {
+			t.Fatalf("Failed to reconcile che manager with error: %s", err)
+		}
+	}
+
+	roleKey := client.ObjectKey{Name: "ns-che-gateway", Namespace: "ws"}
+	if err := cl.Get(ctx, roleKey, &rbac.Role{}); err != nil {
+		t.Fatalf("The gateway should have been granted the access to the namespace of the workspace: %s", err)
+	}
+
+	if err := cl.Get(ctx, client.ObjectKey{Name: "che", Namespace: "ns"}, manager); err != nil {
+		t.Fatal(err)
+	}
+	now := metav1.Now()
+	manager.DeletionTimestamp = &now
+	if err := cl.Update(ctx, manager); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "che", Namespace: "ns"}}); err != nil {
+		t.Fatalf("Failed to finalize che manager with error: %s", err)
+	}
+
+	if err := cl.Get(ctx, roleKey, &rbac.Role{}); !errors.IsNotFound(err) {
+		t.Errorf("The role of the gateway in the namespace of the workspace should have been deleted but got: %v", err)
+	}
+	if err := cl.Get(ctx, roleKey, &rbac.RoleBinding{}); !errors.IsNotFound(err) {
+		t.Errorf("The role binding of the gateway in the namespace of the workspace should have been deleted but got: %v", err)
+	}
+
+	ingressRoute = &unstructured.Unstructured{}
+	ingressRoute.SetGroupVersionKind(gateway.IngressRouteGVK)
+	if err := cl.Get(ctx, client.ObjectKey{Name: "routing", Namespace: "ws"}, ingressRoute); !errors.IsNotFound(err) {
+		t.Errorf("The IngressRoute with the configuration of the workspace should have been deleted but got: %v", err)
+	}
+}
+
+func TestReportsRoutedWorkspaces(t *testing.T) {
+	failed := testRouting("failed", "che")
+	failed.Status.Phase = dwo.RoutingFailed
+
+	scheme := createTestScheme()
+	cl := fake.NewFakeClientWithScheme(scheme, testCheManager("che"), testCheManager("other"), testRouting("first", "che"), testRouting("second", "che"), failed, testRouting("foreign", "other"))
+	reconciler := New(cl, scheme)
+
+	if _, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "che", Namespace: "ns"}}); err != nil {
+		t.Fatalf("Failed to reconcile che manager with error: %s", err)
+	}
+
+	if count := testutil.ToFloat64(metrics.RoutedWorkspaces.WithLabelValues("ns", "che")); count != 2 {
+		t.Errorf("The che manager should route 2 workspaces but the reported number was %v", count)
+	}
+}
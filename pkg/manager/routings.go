@@ -0,0 +1,130 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package manager
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// CheManagerRoutingIndex is the name of the index of the workspace routings by the che manager they're annotated
+	// with.
+	CheManagerRoutingIndex = "cheManager"
+
+	// CheRoutingClass is the routing class of the workspace routings handled by the che managers.
+	CheRoutingClass dwo.WorkspaceRoutingClass = "che"
+)
+
+// GetCheManagerKeyOfRouting returns the key of the che manager the routing is annotated with. The key is empty if
+// the routing doesn't specify the che manager.
+func GetCheManagerKeyOfRouting(routing *dwo.WorkspaceRouting) client.ObjectKey {
+	return client.ObjectKey{
+		Name:      routing.Annotations[defaults.ConfigAnnotationCheManagerName],
+		Namespace: routing.Annotations[defaults.ConfigAnnotationCheManagerNamespace],
+	}
+}
+
+// IndexRoutingByCheManager returns the value of the che manager index for the routing. The routings that don't
+// specify the che manager are indexed under the empty key.
+func IndexRoutingByCheManager(obj runtime.Object) []string {
+	routing, ok := obj.(*dwo.WorkspaceRouting)
+	if !ok {
+		return []string{}
+	}
+
+	key := GetCheManagerKeyOfRouting(routing)
+	if key.Name == "" {
+		return []string{""}
+	}
+	return []string{key.String()}
+}
+
+// getExposedRoutingMode infers the routing mode the workspace of the routing is currently exposed in from the URLs
+// of its exposed endpoints. In the singlehost mode, the paths of the URLs start with the workspace ID, while in
+// the multihost mode each endpoint is exposed on its own host. The mode cannot be inferred if the routing doesn't
+// expose any endpoints (yet).
+func getExposedRoutingMode(routing *dwo.WorkspaceRouting) (v1alpha1.RoutingType, bool) {
+	singlehostPrefix := "/" + routing.Spec.WorkspaceId + "/"
+
+	for _, endpoints := range routing.Status.ExposedEndpoints {
+		for _, endpoint := range endpoints {
+			u, err := url.Parse(endpoint.Url)
+			if err != nil {
+				continue
+			}
+
+			if strings.HasPrefix(u.Path, singlehostPrefix) {
+				return v1alpha1.SingleHost, true
+			}
+			return v1alpha1.MultiHost, true
+		}
+	}
+
+	return "", false
+}
+
+// SetupIndices registers the indices needed by ListRoutingsOfCheManager. It needs to be called once before
+// the operator manager is started, because both the che manager reconciler and the routing solver rely on them.
+func SetupIndices(mgr ctrl.Manager) error {
+	return mgr.GetFieldIndexer().IndexField(context.TODO(), &dwo.WorkspaceRouting{}, CheManagerRoutingIndex, IndexRoutingByCheManager)
+}
+
+// ListRoutingsOfCheManager returns the workspace routings handled by the che manager, including the routings not
+// specifying any che manager if there is no other che manager in the cluster. The che manager itself doesn't need
+// to exist anymore so that the routings can be found also when it has just been deleted.
+func ListRoutingsOfCheManager(ctx context.Context, cl client.Reader, manager *v1alpha1.CheManager) ([]dwo.WorkspaceRouting, error) {
+	managerKey := client.ObjectKey{Name: manager.Name, Namespace: manager.Namespace}.String()
+
+	keys := []string{managerKey}
+
+	managers := v1alpha1.CheManagerList{}
+	if err := cl.List(ctx, &managers); err != nil {
+		return nil, err
+	}
+
+	others := 0
+	for _, m := range managers.Items {
+		if m.Name != manager.Name || m.Namespace != manager.Namespace {
+			others++
+		}
+	}
+	if others == 0 {
+		keys = append(keys, "")
+	}
+
+	ret := []dwo.WorkspaceRouting{}
+	for _, key := range keys {
+		routings := dwo.WorkspaceRoutingList{}
+		if err := cl.List(ctx, &routings, client.MatchingFields{CheManagerRoutingIndex: key}); err != nil {
+			return nil, err
+		}
+
+		for _, r := range routings.Items {
+			// the clients not backed by the cache, e.g. in the tests, might not apply the field selector
+			if IndexRoutingByCheManager(&r)[0] == key && r.Spec.RoutingClass == CheRoutingClass {
+				ret = append(ret, r)
+			}
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,97 @@
+package manager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testCheManager(name string) *v1alpha1.CheManager {
+	return &v1alpha1.CheManager{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "ns",
+		},
+		Spec: v1alpha1.CheManagerSpec{
+			Host:    "over.the.rainbow",
+			Routing: v1alpha1.SingleHost,
+		},
+	}
+}
+
+func testRouting(name string, managerName string) *dwo.WorkspaceRouting {
+	routing := &dwo.WorkspaceRouting{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "ws",
+		},
+		Spec: dwo.WorkspaceRoutingSpec{
+			WorkspaceId:  name,
+			RoutingClass: CheRoutingClass,
+		},
+	}
+
+	if managerName != "" {
+		routing.Annotations = map[string]string{
+			defaults.ConfigAnnotationCheManagerName:      managerName,
+			defaults.ConfigAnnotationCheManagerNamespace: "ns",
+		}
+	}
+
+	return routing
+}
+
+func TestListRoutingsOfCheManager(t *testing.T) {
+	basic := testRouting("basic", "")
+	basic.Spec.RoutingClass = "basic"
+
+	names := func(t *testing.T, cl client.Client) map[string]bool {
+		routings, err := ListRoutingsOfCheManager(context.TODO(), cl, testCheManager("che"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := map[string]bool{}
+		for _, r := range routings {
+			ret[r.Name] = true
+		}
+		return ret
+	}
+
+	t.Run("single manager", func(t *testing.T) {
+		cl := fake.NewFakeClientWithScheme(createTestScheme(), testCheManager("che"), testRouting("annotated", "che"), testRouting("unannotated", ""), testRouting("foreign", "other"), basic.DeepCopy())
+
+		found := names(t, cl)
+		if len(found) != 2 || !found["annotated"] || !found["unannotated"] {
+			t.Errorf("The annotated and the unannotated routing should have been found but found %v", found)
+		}
+	})
+
+	t.Run("several managers", func(t *testing.T) {
+		cl := fake.NewFakeClientWithScheme(createTestScheme(), testCheManager("che"), testCheManager("other"), testRouting("annotated", "che"), testRouting("unannotated", ""), testRouting("foreign", "other"), basic.DeepCopy())
+
+		found := names(t, cl)
+		if len(found) != 1 || !found["annotated"] {
+			t.Errorf("Only the annotated routing should have been found but found %v", found)
+		}
+	})
+}
+
+func TestIndexRoutingByCheManager(t *testing.T) {
+	if idx := IndexRoutingByCheManager(testRouting("routing", "")); len(idx) != 1 || idx[0] != "" {
+		t.Errorf("The routing without annotations should be indexed under the empty key but was %v", idx)
+	}
+
+	if idx := IndexRoutingByCheManager(testRouting("routing", "che")); len(idx) != 1 || idx[0] != "ns/che" {
+		t.Errorf("The routing should be indexed under the key of its che manager but was %v", idx)
+	}
+
+	if idx := IndexRoutingByCheManager(testCheManager("che")); len(idx) != 0 {
+		t.Errorf("Only the routings should be indexed but got %v", idx)
+	}
+}
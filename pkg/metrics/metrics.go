@@ -0,0 +1,79 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+// Package metrics contains the Prometheus metrics of the operator. The metrics are registered with the registry
+// of the controller runtime so that they're exposed on the metrics endpoint of the operator manager along with
+// the metrics of the controllers.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	namespace = "devworkspace_che"
+)
+
+var (
+	// OrphanedConfigMapsDeleted counts the config maps with the gateway configuration of the workspaces that were
+	// deleted because their workspace routing no longer exists.
+	OrphanedConfigMapsDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orphaned_gateway_config_maps_deleted_total",
+		Help:      "The number of the workspace gateway config maps deleted because their workspace routing no longer exists.",
+	})
+
+	// SyncedObjects counts the objects created, updated and deleted by the syncer, by their kind and the operation.
+	SyncedObjects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "synced_objects_total",
+		Help:      "The number of the objects created, updated or deleted in the cluster, by kind and operation.",
+	}, []string{"kind", "operation"})
+
+	// RecreatedObjects counts the objects that the syncer couldn't update in place and had to delete and create
+	// again instead.
+	RecreatedObjects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "recreated_objects_total",
+		Help:      "The number of the objects updated by deleting and re-creating them, by kind.",
+	}, []string{"kind"})
+
+	// RoutingNotReadyRetries counts how many times the solving of a workspace routing was postponed because
+	// the routing could not be solved yet, e.g. because its che manager doesn't exist.
+	RoutingNotReadyRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "routing_not_ready_retries_total",
+		Help:      "The number of times the solving of a workspace routing was retried later because it was not ready.",
+	})
+
+	// RoutingReadyDuration observes the time from the creation of a workspace routing until all its endpoints
+	// are exposed.
+	RoutingReadyDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "routing_ready_duration_seconds",
+		Help:      "The time from the creation of a workspace routing until its endpoints are ready.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	})
+
+	// RoutedWorkspaces is the number of the workspaces routed by each che manager. It is updated when the che
+	// managers are reconciled, which happens also whenever their workspace routings change.
+	RoutedWorkspaces = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "routed_workspaces",
+		Help:      "The number of the workspaces routed by a che manager.",
+	}, []string{"chemanager_namespace", "chemanager_name"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(OrphanedConfigMapsDeleted, SyncedObjects, RecreatedObjects, RoutingNotReadyRetries, RoutingReadyDuration, RoutedWorkspaces)
+}
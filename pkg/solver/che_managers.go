@@ -0,0 +1,58 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// findCheManager finds the che manager with the given key using the client, which reads from the informer cache in
+// the operator. If the key is empty, the only che manager in the cluster is returned.
+func findCheManager(ctx context.Context, cl client.Reader, cheManagerKey client.ObjectKey) (*v1alpha1.CheManager, error) {
+	if len(cheManagerKey.Name) == 0 {
+		managers := v1alpha1.CheManagerList{}
+		if err := cl.List(ctx, &managers); err != nil {
+			return &v1alpha1.CheManager{}, err
+		}
+
+		switch len(managers.Items) {
+		case 0:
+			// the CheManager has not been created yet, so let's wait a bit
+			return &v1alpha1.CheManager{}, &solvers.RoutingNotReady{Retry: 1 * time.Second}
+		case 1:
+			return &managers.Items[0], nil
+		default:
+			return &v1alpha1.CheManager{}, &solvers.RoutingInvalid{Reason: fmt.Sprintf("the routing does not specify any Che manager in its configuration but there are %d Che managers in the cluster", len(managers.Items))}
+		}
+	}
+
+	manager := &v1alpha1.CheManager{}
+	if err := cl.Get(ctx, cheManagerKey, manager); err != nil {
+		if !errors.IsNotFound(err) {
+			return &v1alpha1.CheManager{}, err
+		}
+
+		logger.Info("Routing requires a non-existing che manager. Retrying in 10 seconds.", "key", cheManagerKey)
+
+		return &v1alpha1.CheManager{}, &solvers.RoutingNotReady{Retry: 10 * time.Second}
+	}
+
+	return manager, nil
+}
@@ -0,0 +1,121 @@
+package solver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+func TestFindCheManager(t *testing.T) {
+	cl := fake.NewFakeClientWithScheme(createTestScheme(), simpleCheManager())
+
+	manager, err := findCheManager(context.TODO(), cl, client.ObjectKey{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manager.Name != "che" || manager.Namespace != "ns" {
+		t.Errorf("The only che manager should have been found for an unspecified key but found %s/%s", manager.Namespace, manager.Name)
+	}
+
+	if _, err = findCheManager(context.TODO(), cl, client.ObjectKey{Name: "che", Namespace: "ns"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cl.Delete(context.TODO(), simpleCheManager()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = findCheManager(context.TODO(), cl, client.ObjectKey{Name: "che", Namespace: "ns"})
+	if _, ok := err.(*solvers.RoutingNotReady); !ok {
+		t.Errorf("A deleted che manager should not be found but got: %v", err)
+	}
+
+	_, err = findCheManager(context.TODO(), cl, client.ObjectKey{})
+	if _, ok := err.(*solvers.RoutingNotReady); !ok {
+		t.Errorf("No che manager should be found when there are none but got: %v", err)
+	}
+}
+
+func TestFindCheManagerAmbiguous(t *testing.T) {
+	other := simpleCheManager()
+	other.Name = "other"
+
+	cl := fake.NewFakeClientWithScheme(createTestScheme(), simpleCheManager(), other)
+
+	_, err := findCheManager(context.TODO(), cl, client.ObjectKey{})
+	if _, ok := err.(*solvers.RoutingInvalid); !ok {
+		t.Errorf("The routing without the che manager should be invalid when there are several che managers but got: %v", err)
+	}
+}
+
+func TestCheManagerChangeEnqueuesItsRoutings(t *testing.T) {
+	annotated := simpleWorkspaceRouting()
+	annotated.Name = "annotated"
+	annotated.Annotations = map[string]string{
+		defaults.ConfigAnnotationCheManagerName:      "che",
+		defaults.ConfigAnnotationCheManagerNamespace: "ns",
+	}
+
+	unannotated := simpleWorkspaceRouting()
+	unannotated.Name = "unannotated"
+
+	cl := fake.NewFakeClientWithScheme(createTestScheme(), simpleCheManager(), annotated, unannotated)
+
+	getter := Getter(createTestScheme())
+	getter.client = cl
+
+	requests := func() map[string]bool {
+		ret := map[string]bool{}
+		for _, r := range getter.routingsOfCheManager(handler.MapObject{Meta: simpleCheManager(), Object: simpleCheManager()}) {
+			ret[r.Namespace+"/"+r.Name] = true
+		}
+		return ret
+	}
+
+	found := requests()
+	if len(found) != 2 || !found["ws/annotated"] || !found["ws/unannotated"] {
+		t.Errorf("Both routings should have been enqueued but got %v", found)
+	}
+
+	// the routings need to be reconciled also when the che manager is deleted
+	if err := cl.Delete(context.TODO(), simpleCheManager()); err != nil {
+		t.Fatal(err)
+	}
+
+	found = requests()
+	if len(found) != 2 || !found["ws/annotated"] || !found["ws/unannotated"] {
+		t.Errorf("Both routings should have been enqueued after the che manager deletion but got %v", found)
+	}
+}
+
+func TestMissingCheManagerIsReportedOnRouting(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.Annotations = map[string]string{
+		defaults.ConfigAnnotationCheManagerName:      "che",
+		defaults.ConfigAnnotationCheManagerNamespace: "ns",
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	solver := &CheRoutingSolver{client: fake.NewFakeClientWithScheme(createTestScheme(), routing), scheme: createTestScheme(), recorder: recorder}
+
+	_, err := solver.GetSpecObjects(routing, solvers.WorkspaceMetadata{})
+	if _, ok := err.(*solvers.RoutingNotReady); !ok {
+		t.Fatalf("The routing should not be ready without the che manager but got: %v", err)
+	}
+
+	select {
+	case e := <-recorder.Events:
+		if !strings.HasPrefix(e, "Warning CheManagerNotFound") {
+			t.Errorf("Unexpected event: %s", e)
+		}
+	default:
+		t.Error("The missing che manager should have been reported on the routing")
+	}
+}
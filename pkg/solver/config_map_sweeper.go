@@ -0,0 +1,117 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	"context"
+	"time"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/metrics"
+	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmanager "sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// configMapSweeper periodically deletes the config maps with the gateway configuration of the workspaces whose
+// workspace routing no longer exists. Normally, the config maps are deleted when the routing is finalized, but that
+// doesn't happen when the routing is force-deleted or its finalizer is removed. The gateway would keep routing to
+// the no longer existing workspace services in that case.
+type configMapSweeper struct {
+	// the client reading from the cache, used to find the candidates for the deletion
+	client client.Client
+	// the client reading directly from the cluster, used to confirm the routing doesn't exist before deleting
+	// its configuration, because the cache might not have caught up with the creation of the routing yet
+	apiReader client.Reader
+	recorder  record.EventRecorder
+	interval  time.Duration
+}
+
+var _ crmanager.Runnable = (*configMapSweeper)(nil)
+
+func (s *configMapSweeper) Start(stop <-chan struct{}) error {
+	logger.Info("Starting the sweeper of the orphaned workspace gateway configuration", "interval", s.interval)
+
+	wait.Until(func() {
+		if _, err := s.sweep(context.Background()); err != nil {
+			logger.Error(err, "Failed to delete the orphaned workspace gateway configuration")
+		}
+	}, s.interval, stop)
+
+	return nil
+}
+
+// sweep deletes the gateway configuration of all the workspaces whose routing no longer exists and returns
+// the number of the deleted config maps.
+func (s *configMapSweeper) sweep(ctx context.Context) (int, error) {
+	configMaps := corev1.ConfigMapList{}
+	if err := s.client.List(ctx, &configMaps, client.MatchingLabelsSelector{Selector: defaults.GetWorkspaceGatewayConfigSelector()}); err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for i := range configMaps.Items {
+		cm := &configMaps.Items[i]
+
+		applicable, routingKey := isGatewayWorkspaceConfig(cm)
+		if !applicable {
+			continue
+		}
+
+		err := s.apiReader.Get(ctx, routingKey, &dwo.WorkspaceRouting{})
+		if err == nil {
+			continue
+		}
+		if !errors.IsNotFound(err) {
+			return deleted, err
+		}
+
+		logger.Info("Deleting the gateway configuration of a no longer existing workspace routing", "configmap", client.ObjectKey{Name: cm.Name, Namespace: cm.Namespace}, "routing", routingKey)
+
+		if err = s.client.Delete(ctx, cm); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return deleted, err
+		}
+
+		deleted++
+		metrics.OrphanedConfigMapsDeleted.Inc()
+		s.recordDeletion(ctx, cm, routingKey)
+	}
+
+	return deleted, nil
+}
+
+// recordDeletion records the event about the deleted config map on the che manager it belonged to, since
+// the config map itself is gone. Nothing is recorded if the che manager doesn't exist anymore either.
+func (s *configMapSweeper) recordDeletion(ctx context.Context, cm *corev1.ConfigMap, routingKey client.ObjectKey) {
+	if s.recorder == nil {
+		return
+	}
+
+	cheManager := &v1alpha1.CheManager{}
+	if err := s.client.Get(ctx, client.ObjectKey{Name: defaults.GetAppNameFromLabels(cm.Labels), Namespace: cm.Namespace}, cheManager); err != nil {
+		if !errors.IsNotFound(err) {
+			logger.Error(err, "Failed to find the che manager of the deleted gateway configuration", "configmap", client.ObjectKey{Name: cm.Name, Namespace: cm.Namespace})
+		}
+		return
+	}
+
+	s.recorder.Eventf(cheManager, corev1.EventTypeNormal, "OrphanedConfigDeleted", "Deleted the gateway configuration %s of the no longer existing workspace routing %s", cm.Name, routingKey)
+}
@@ -0,0 +1,79 @@
+package solver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// objectRecorder remembers the objects the events were recorded on.
+type objectRecorder struct {
+	record.FakeRecorder
+	objects []runtime.Object
+}
+
+func (r *objectRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.objects = append(r.objects, object)
+}
+
+func TestSweeperDeletesOrphanedConfigMaps(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	cl, _, _ := getSpecObjects(t, routing)
+
+	recorder := &objectRecorder{}
+	sweeper := &configMapSweeper{client: cl, apiReader: cl, recorder: recorder}
+
+	// the routing was never created in the cluster, so first create it to check its configuration is kept
+	if err := cl.Create(context.TODO(), routing); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := sweeper.sweep(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 0 {
+		t.Errorf("The configuration of the existing routing should have been kept but %d config maps were deleted", deleted)
+	}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "wsid", Namespace: "ns"}, &corev1.ConfigMap{}); err != nil {
+		t.Fatalf("The configuration of the existing routing should have been kept: %s", err)
+	}
+
+	// simulate the routing being force-deleted without its finalizer being run
+	if err = cl.Delete(context.TODO(), routing); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err = sweeper.sweep(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("The configuration of the deleted routing should have been deleted but %d config maps were deleted", deleted)
+	}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "wsid", Namespace: "ns"}, &corev1.ConfigMap{}); !errors.IsNotFound(err) {
+		t.Errorf("The configuration of the deleted routing should have been deleted but got: %v", err)
+	}
+
+	if len(recorder.objects) != 1 {
+		t.Errorf("An event should have been recorded about the deleted config map but there were %d", len(recorder.objects))
+	} else if _, ok := recorder.objects[0].(*v1alpha1.CheManager); !ok {
+		t.Errorf("The event should have been recorded on the che manager but was recorded on %T", recorder.objects[0])
+	}
+
+	// the gateway configuration of the che manager itself must never be touched
+	cms := corev1.ConfigMapList{}
+	if err = cl.List(context.TODO(), &cms, client.InNamespace("ns"), client.MatchingLabels(defaults.GetLabelsForComponent(simpleCheManager(), "gateway-config"))); err != nil {
+		t.Fatal(err)
+	}
+	if len(cms.Items) == 0 {
+		t.Error("The gateway configuration of the che manager should have been kept")
+	}
+}
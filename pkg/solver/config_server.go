@@ -0,0 +1,391 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/envoy"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/gateway"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/manager"
+	dwconfig "github.com/devfile/devworkspace-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmanager "sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/yaml"
+)
+
+// mergeAdditionalConfigs merges the dynamic configuration from the config maps labeled as the gateway configuration
+// of the che manager, which are not the configuration of any workspace, into the config. In the "configmaps" mode,
+// the gateway loads all such config maps, so they are also loaded in the "http" mode. Only the HTTP routers,
+// services and middlewares are taken from them.
+func (s *configServer) mergeAdditionalConfigs(ctx context.Context, manager *v1alpha1.CheManager, config *traefikConfig) error {
+	configMaps := corev1.ConfigMapList{}
+	if err := s.client.List(ctx, &configMaps, client.InNamespace(manager.Namespace), client.MatchingLabels(defaults.GetLabelsForComponent(manager, "gateway-config"))); err != nil {
+		return err
+	}
+
+	for _, cm := range configMaps.Items {
+		if _, ok := cm.Labels[dwconfig.WorkspaceIDLabel]; ok {
+			continue
+		}
+
+		keys := make([]string, 0, len(cm.Data))
+		for k := range cm.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			additional := traefikConfig{}
+			if err := yaml.Unmarshal([]byte(cm.Data[k]), &additional); err != nil {
+				logger.Error(err, "Failed to parse the additional gateway configuration, ignoring it", "namespace", cm.Namespace, "name", cm.Name, "key", k)
+				continue
+			}
+			mergeTraefikConfig(config, &additional)
+		}
+	}
+
+	return nil
+}
+
+func mergeTraefikConfig(dst *traefikConfig, src *traefikConfig) {
+	for k, v := range src.HTTP.Routers {
+		dst.HTTP.Routers[k] = v
+	}
+	for k, v := range src.HTTP.Services {
+		dst.HTTP.Services[k] = v
+	}
+	for k, v := range src.HTTP.Middlewares {
+		dst.HTTP.Middlewares[k] = v
+	}
+}
+
+// configServer serves the dynamic configuration of the gateways over HTTP. The configuration is rendered from
+// the che managers and workspace routings in the cache of the operator manager whenever a gateway asks for it,
+// so the server doesn't keep any state of its own. This also means that it can run in all the replicas of
+// the operator, not just the leader, and the service in front of it can select any of them. Until the cache
+// is synced, it responds with 503 which makes the gateways keep their last known configuration.
+type configServer struct {
+	addr   string
+	client client.Client
+	cache  cache.Cache
+
+	lock  sync.RWMutex
+	ready bool
+}
+
+var _ crmanager.Runnable = (*configServer)(nil)
+var _ crmanager.LeaderElectionRunnable = (*configServer)(nil)
+var _ http.Handler = (*configServer)(nil)
+
+func (s *configServer) Start(stop <-chan struct{}) error {
+	srv := &http.Server{
+		Addr:    s.addr,
+		Handler: s,
+	}
+
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Error(err, "Failed to shut down the gateway config server")
+		}
+	}()
+
+	go func() {
+		if s.cache != nil && !s.cache.WaitForCacheSync(stop) {
+			return
+		}
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.ready = true
+	}()
+
+	logger.Info("Starting the gateway config server", "address", s.addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// NeedLeaderElection returns false so that the gateways can get their configuration from any replica
+// of the operator.
+func (s *configServer) NeedLeaderElection() bool {
+	return false
+}
+
+// getWorkspaceConfigs returns the che manager and the configuration of all its workspaces ordered by the workspace
+// ID. The che manager is nil if it doesn't exist. The workspace routings that cannot be exposed are left out, their
+// problems are reported on them by the routing reconciler.
+func (s *configServer) getWorkspaceConfigs(ctx context.Context, key client.ObjectKey) (*v1alpha1.CheManager, []workspaceGatewayConfig, error) {
+	cheManager := &v1alpha1.CheManager{}
+	if err := s.client.Get(ctx, key, cheManager); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	ret := []workspaceGatewayConfig{}
+	if cheManager.Spec.Routing != v1alpha1.SingleHost || !gateway.UsesHTTPConfigProvider(cheManager) {
+		return cheManager, ret, nil
+	}
+
+	routings, err := manager.ListRoutingsOfCheManager(ctx, s.client, cheManager)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range routings {
+		routing := &routings[i]
+		if routing.DeletionTimestamp != nil {
+			continue
+		}
+
+		config, err := getWorkspaceGatewayConfig(cheManager, routing.Spec.WorkspaceId, routing)
+		if err != nil {
+			continue
+		}
+
+		ret = append(ret, config)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].workspaceID < ret[j].workspaceID
+	})
+
+	return cheManager, ret, nil
+}
+
+func (s *configServer) isReady() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.ready
+}
+
+// isAuthorized checks that the token sent by the gateway is the token generated for the gateway of the che manager.
+func (s *configServer) isAuthorized(ctx context.Context, manager client.ObjectKey, token string) bool {
+	expected, err := gateway.GetConfigServerToken(ctx, s.client, manager)
+	if err != nil {
+		logger.Error(err, "Failed to read the config server token of the gateway", "namespace", manager.Namespace, "name", manager.Name)
+		return false
+	}
+
+	return expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
+
+// ServeHTTP serves the dynamic configuration of the gateway of the che manager. The Traefik gateways get their
+// configuration using GET requests with the path in the form of "/gateway/<namespace>/<name>". The Envoy gateways use
+// the REST variant of the xDS API and identify themselves using the node ID in the discovery requests. The gateways
+// authenticate using the token generated for them, sent in the query or in the node metadata respectively.
+func (s *configServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if typeURL, ok := envoyDiscoveryPaths[r.URL.Path]; ok {
+		s.serveEnvoyDiscovery(w, r, typeURL)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, defaults.GatewayConfigPathPrefix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, defaults.GatewayConfigPathPrefix), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !s.isReady() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if !s.isAuthorized(r.Context(), client.ObjectKey{Namespace: parts[0], Name: parts[1]}, r.URL.Query().Get(gateway.ConfigServerTokenQueryParam)) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	config, err := s.getConfig(r.Context(), client.ObjectKey{Namespace: parts[0], Name: parts[1]})
+	if err != nil {
+		logger.Error(err, "Failed to produce the gateway configuration", "namespace", parts[0], "name", parts[1])
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if config == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(config); err != nil {
+		logger.Error(err, "Failed to send the gateway configuration", "namespace", parts[0], "name", parts[1])
+	}
+}
+
+// serveEnvoyDiscovery responds to the discovery request of an Envoy gateway. If the gateway already has the current
+// version of the resources, it responds with 304.
+func (s *configServer) serveEnvoyDiscovery(w http.ResponseWriter, r *http.Request, typeURL string) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	request := envoy.DiscoveryRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	parts := strings.Split(request.Node.ID, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !s.isReady() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if !s.isAuthorized(r.Context(), client.ObjectKey{Namespace: parts[0], Name: parts[1]}, request.Node.Metadata[gateway.ConfigServerTokenMetadataKey]) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	manager, workspaces, err := s.getWorkspaceConfigs(r.Context(), client.ObjectKey{Namespace: parts[0], Name: parts[1]})
+	if err != nil {
+		logger.Error(err, "Failed to produce the gateway configuration", "namespace", parts[0], "name", parts[1], "type", typeURL)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if manager == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	rendered, err := renderWorkspaces(manager, workspaces)
+	if err != nil {
+		logger.Error(err, "Failed to produce the gateway configuration", "namespace", parts[0], "name", parts[1], "type", typeURL)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if rendered.envoy == nil {
+		// the gateway of the che manager is not Envoy
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	resources, err := getEnvoyResources(rendered.envoy, typeURL)
+	if err != nil {
+		logger.Error(err, "Failed to produce the gateway configuration", "namespace", parts[0], "name", parts[1], "type", typeURL)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(resources)
+	if err != nil {
+		logger.Error(err, "Failed to produce the gateway configuration", "namespace", parts[0], "name", parts[1], "type", typeURL)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	version := fmt.Sprintf("%x", sha256.Sum256(data))
+	if version == request.VersionInfo {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	response, err := json.Marshal(envoy.DiscoveryResponse{
+		VersionInfo: version,
+		Resources:   resources,
+		TypeURL:     typeURL,
+	})
+	if err != nil {
+		logger.Error(err, "Failed to produce the gateway configuration", "namespace", parts[0], "name", parts[1], "type", typeURL)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(response); err != nil {
+		logger.Error(err, "Failed to send the gateway configuration", "namespace", parts[0], "name", parts[1], "type", typeURL)
+	}
+}
+
+// getConfig returns the complete dynamic configuration of the Traefik gateway, including the gateway-wide routes.
+// It returns nil if the che manager doesn't exist or its gateway is not Traefik.
+func (s *configServer) getConfig(ctx context.Context, key client.ObjectKey) ([]byte, error) {
+	manager, workspaces, err := s.getWorkspaceConfigs(ctx, key)
+	if err != nil || manager == nil {
+		return nil, err
+	}
+
+	rendered, err := renderWorkspaces(manager, workspaces)
+	if err != nil || rendered.traefik == nil {
+		return nil, err
+	}
+
+	config := traefikConfig{}
+	if err := yaml.Unmarshal([]byte(gateway.GetGatewayRoutesConfig()), &config); err != nil {
+		return nil, err
+	}
+
+	if err := s.mergeAdditionalConfigs(ctx, manager, &config); err != nil {
+		return nil, err
+	}
+
+	mergeTraefikConfig(&config, rendered.traefik)
+
+	return json.Marshal(config)
+}
+
+// renderWorkspaces renders the configuration of the workspaces using the renderer of the che manager. The workspaces
+// whose configuration cannot be rendered are left out, because a single broken workspace must not break the whole
+// gateway. Their problems are reported on their routings by the routing reconciler.
+func renderWorkspaces(cheManager *v1alpha1.CheManager, workspaces []workspaceGatewayConfig) (renderedGatewayConfig, error) {
+	renderer := getGatewayConfigRenderer(cheManager)
+
+	valid := []workspaceGatewayConfig{}
+	for _, ws := range workspaces {
+		if _, err := renderer.render(cheManager, []workspaceGatewayConfig{ws}); err == nil {
+			valid = append(valid, ws)
+		}
+	}
+
+	return renderer.render(cheManager, valid)
+}
@@ -0,0 +1,318 @@
+package solver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/envoy"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/gateway"
+	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func httpProviderCheManager() *v1alpha1.CheManager {
+	manager := simpleCheManager()
+	manager.Spec.Gateway.ConfigProvider = v1alpha1.HTTPConfigProvider
+	return manager
+}
+
+// configServerToken returns the token of the gateway of the che manager "che" in the namespace "ns".
+func configServerToken(t *testing.T, cl client.Client) string {
+	token, err := gateway.GetConfigServerToken(context.TODO(), cl, client.ObjectKey{Name: "che", Namespace: "ns"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// syncGateway creates the gateway of the che manager, including its config server token.
+func syncGateway(t *testing.T, cl client.Client, manager *v1alpha1.CheManager) {
+	gw := gateway.New(cl, createTestScheme(), nil, "")
+	if _, _, err := gw.Sync(context.TODO(), manager); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// storeRouting creates the routing in the cluster, because the config server renders the configuration of
+// the workspace routings found there.
+func storeRouting(t *testing.T, cl client.Client, routing *dwo.WorkspaceRouting) {
+	if err := cl.Create(context.TODO(), routing); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func serveConfig(t *testing.T, srv *configServer, path string, token string) (int, traefikConfig) {
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path+"?token="+token, nil))
+
+	config := traefikConfig{}
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &config); err != nil {
+			t.Fatalf("Failed to parse the served configuration: %s", err)
+		}
+	}
+
+	return rec.Code, config
+}
+
+func TestHTTPProviderServesWorkspaceConfig(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	cl, slv, _ := getSpecObjectsForManager(t, routing, httpProviderCheManager())
+	storeRouting(t, cl, routing)
+
+	cm := &corev1.ConfigMap{}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "wsid", Namespace: "ns"}, cm); err == nil {
+		t.Error("No workspace configmap should have been created in the HTTP provider mode")
+	}
+
+	srv := &configServer{client: cl, ready: true}
+
+	code, config := serveConfig(t, srv, defaults.GatewayConfigPathPrefix+"ns/che", configServerToken(t, cl))
+	if code != http.StatusOK {
+		t.Fatalf("Unexpected response code: %d", code)
+	}
+
+	if _, ok := config.HTTP.Routers["wsid-m1-9999"]; !ok {
+		t.Error("The configuration of the workspace should have been served")
+	}
+
+	if _, ok := config.HTTP.Routers["che-gateway-not-found"]; !ok {
+		t.Error("The gateway-wide routes should have been served")
+	}
+
+	if err := slv.Finalize(routing); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Delete(context.TODO(), routing); err != nil {
+		t.Fatal(err)
+	}
+
+	_, config = serveConfig(t, srv, defaults.GatewayConfigPathPrefix+"ns/che", configServerToken(t, cl))
+	if _, ok := config.HTTP.Routers["wsid-m1-9999"]; ok {
+		t.Error("The configuration of the workspace should have been removed after the routing was deleted")
+	}
+
+	if code, _ := serveConfig(t, srv, defaults.GatewayConfigPathPrefix+"ns/nonexistent", ""); code != http.StatusUnauthorized {
+		t.Errorf("No configuration should have been served for a nonexistent che manager but the response code was %d", code)
+	}
+}
+
+func TestHTTPProviderNotReadyBeforeCacheSync(t *testing.T) {
+	srv := &configServer{client: fake.NewFakeClientWithScheme(createTestScheme())}
+
+	if code, _ := serveConfig(t, srv, defaults.GatewayConfigPathPrefix+"ns/che", ""); code != http.StatusServiceUnavailable {
+		t.Errorf("The server should not serve any configuration before it is initialized but responded with %d", code)
+	}
+
+	if code, _ := serveConfig(t, srv, "/nonsense", ""); code != http.StatusNotFound {
+		t.Errorf("Unexpected response code for an unknown path: %d", code)
+	}
+}
+
+func TestHTTPProviderServesExistingRoutings(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.Annotations = map[string]string{
+		defaults.ConfigAnnotationCheManagerName:      "che",
+		defaults.ConfigAnnotationCheManagerNamespace: "ns",
+	}
+
+	// the routing has not been solved by this replica of the operator
+	manager := httpProviderCheManager()
+	cl := fake.NewFakeClientWithScheme(createTestScheme(), manager, routing)
+	syncGateway(t, cl, manager)
+
+	srv := &configServer{client: cl, ready: true}
+
+	_, config := serveConfig(t, srv, defaults.GatewayConfigPathPrefix+"ns/che", configServerToken(t, cl))
+	if _, ok := config.HTTP.Routers["wsid-m1-9999"]; !ok {
+		t.Error("The configuration of the existing routing should have been served")
+	}
+
+	if srv.NeedLeaderElection() {
+		t.Error("The config server should run in all the replicas of the operator")
+	}
+}
+
+func TestHTTPProviderServesAdditionalConfigMaps(t *testing.T) {
+	manager := httpProviderCheManager()
+
+	additional := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "additional",
+			Namespace: "ns",
+			Labels:    defaults.GetLabelsForComponent(manager, "gateway-config"),
+		},
+		Data: map[string]string{
+			"additional.yml": "http:\n  routers:\n    dashboard:\n      rule: \"PathPrefix(`/dashboard`)\"\n      service: dashboard\n",
+		},
+	}
+
+	cl := fake.NewFakeClientWithScheme(createTestScheme(), manager, additional)
+	syncGateway(t, cl, manager)
+
+	srv := &configServer{client: cl, ready: true}
+
+	_, config := serveConfig(t, srv, defaults.GatewayConfigPathPrefix+"ns/che", configServerToken(t, cl))
+	if router, ok := config.HTTP.Routers["dashboard"]; !ok || router.Service != "dashboard" {
+		t.Errorf("The routers from the additional config map should have been served but got: %v", config.HTTP.Routers)
+	}
+}
+
+func TestConfigServerRequiresToken(t *testing.T) {
+	cl, _, _ := getSpecObjectsForManager(t, simpleWorkspaceRouting(), httpProviderCheManager())
+
+	srv := &configServer{client: cl, ready: true}
+
+	if code, _ := serveConfig(t, srv, defaults.GatewayConfigPathPrefix+"ns/che", ""); code != http.StatusUnauthorized {
+		t.Errorf("The configuration should not be served without a token but the response code was %d", code)
+	}
+
+	if code, _ := serveConfig(t, srv, defaults.GatewayConfigPathPrefix+"ns/che", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("The configuration should not be served with a wrong token but the response code was %d", code)
+	}
+
+	request, err := json.Marshal(envoy.DiscoveryRequest{Node: envoy.Node{ID: "ns/che"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v3/discovery:clusters", bytes.NewReader(request)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("The discovery should not be served without a token but the response code was %d", rec.Code)
+	}
+}
+
+func discover(t *testing.T, srv *configServer, path string, version string) (int, envoy.DiscoveryResponse) {
+	request, err := json.Marshal(envoy.DiscoveryRequest{
+		VersionInfo: version,
+		Node: envoy.Node{
+			ID:       "ns/che",
+			Metadata: map[string]string{gateway.ConfigServerTokenMetadataKey: configServerToken(t, srv.client)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(request)))
+
+	response := envoy.DiscoveryResponse{}
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse the discovery response: %s", err)
+		}
+	}
+
+	return rec.Code, response
+}
+
+func TestEnvoyDiscovery(t *testing.T) {
+	manager := httpProviderCheManager()
+	manager.Spec.Gateway.Implementation = v1alpha1.EnvoyGatewayImplementation
+
+	routing := simpleWorkspaceRouting()
+	cl, slv, _ := getSpecObjectsForManager(t, routing, manager)
+	storeRouting(t, cl, routing)
+
+	srv := &configServer{client: cl, ready: true}
+
+	code, clusters := discover(t, srv, "/v3/discovery:clusters", "")
+	if code != http.StatusOK {
+		t.Fatalf("Unexpected response code: %d", code)
+	}
+	if clusters.TypeURL != envoy.ClusterTypeURL || len(clusters.Resources) != 1 {
+		t.Fatalf("Unexpected clusters: %v", clusters)
+	}
+	cluster := clusters.Resources[0].(map[string]interface{})
+	if cluster["name"] != "wsid-m1-9999" || cluster["@type"] != envoy.ClusterTypeURL {
+		t.Errorf("Unexpected cluster: %v", cluster)
+	}
+
+	if code, _ := discover(t, srv, "/v3/discovery:clusters", clusters.VersionInfo); code != http.StatusNotModified {
+		t.Errorf("The unchanged clusters should not be sent again but the response code was %d", code)
+	}
+
+	code, listeners := discover(t, srv, "/v3/discovery:listeners", "")
+	if code != http.StatusOK {
+		t.Fatalf("Unexpected response code: %d", code)
+	}
+	if len(listeners.Resources) != 2 {
+		t.Fatalf("Expected the http and https listeners but got: %v", listeners.Resources)
+	}
+
+	data, err := json.Marshal(listeners.Resources[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := envoy.Listener{}
+	if err := json.Unmarshal(data, &listener); err != nil {
+		t.Fatal(err)
+	}
+
+	routes := listener.FilterChains[0].Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes
+	if len(routes) != 2 {
+		t.Fatalf("The stripped prefix should be handled by 2 routes but got: %v", routes)
+	}
+	if routes[0].Match.Prefix != "/wsid/m1/9999/" || routes[1].Match.Path != "/wsid/m1/9999" {
+		t.Errorf("Unexpected route matches: %v, %v", routes[0].Match, routes[1].Match)
+	}
+	for _, r := range routes {
+		if r.Route.Cluster != "wsid-m1-9999" || r.Route.PrefixRewrite != "/" {
+			t.Errorf("Unexpected route action: %v", r.Route)
+		}
+		if len(r.RequestHeadersToAdd) != 1 || r.RequestHeadersToAdd[0].Header.Value != "/wsid/m1/9999" {
+			t.Errorf("The forwarded prefix should have been set: %v", r.RequestHeadersToAdd)
+		}
+	}
+
+	if err := slv.Finalize(routing); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Delete(context.TODO(), routing); err != nil {
+		t.Fatal(err)
+	}
+
+	_, clusters = discover(t, srv, "/v3/discovery:clusters", clusters.VersionInfo)
+	if len(clusters.Resources) != 0 {
+		t.Errorf("The clusters of the workspace should have been removed after the routing was deleted: %v", clusters.Resources)
+	}
+}
+
+func TestConfigServerUsesRendererOfCheManager(t *testing.T) {
+	manager := httpProviderCheManager()
+	manager.Spec.Gateway.Implementation = v1alpha1.EnvoyGatewayImplementation
+
+	cl, _, _ := getSpecObjectsForManager(t, simpleWorkspaceRouting(), manager)
+
+	srv := &configServer{client: cl, ready: true}
+
+	if code, _ := serveConfig(t, srv, defaults.GatewayConfigPathPrefix+"ns/che", configServerToken(t, cl)); code != http.StatusNotFound {
+		t.Errorf("No Traefik configuration should have been served for the Envoy gateway but the response code was %d", code)
+	}
+
+	if _, ok := getGatewayConfigRenderer(manager).(envoyRenderer); !ok {
+		t.Error("The Envoy renderer should have been used for the Envoy gateway")
+	}
+
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "che", Namespace: "ns"}, manager); err != nil {
+		t.Fatal(err)
+	}
+	manager.Spec.Gateway.Implementation = v1alpha1.TraefikGatewayImplementation
+	if err := cl.Update(context.TODO(), manager); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _ := discover(t, srv, "/v3/discovery:clusters", ""); code != http.StatusNotFound {
+		t.Errorf("No Envoy resources should have been served for the Traefik gateway but the response code was %d", code)
+	}
+}
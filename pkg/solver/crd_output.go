@@ -0,0 +1,275 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	dwoche "github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/gateway"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/infrastructure"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/sync"
+	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// we only manage the spec of the traefik objects
+	traefikObjectDiffOpts = cmp.Comparer(func(x, y *unstructured.Unstructured) bool {
+		return equality.Semantic.DeepEqual(x.Object["spec"], y.Object["spec"])
+	})
+)
+
+// kubernetesCRDOutput stores the configuration of the workspace in the Traefik IngressRoute and Middleware objects
+// in the namespace of the workspace, where the Kubernetes CRD provider of the gateway reads them from. The objects
+// are owned by the workspace routing so they're garbage collected together with it.
+type kubernetesCRDOutput struct {
+	client client.Client
+	syncer sync.Syncer
+}
+
+var _ gatewayConfigOutput = (*kubernetesCRDOutput)(nil)
+
+func (o *kubernetesCRDOutput) sync(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting, workspaceConfig workspaceGatewayConfig) error {
+	if !infrastructure.TraefikCRDsAvailable {
+		return fmt.Errorf("the che manager '%s' in namespace '%s' is configured to use the Traefik CRDs but they are not installed in the cluster", cheManager.Name, cheManager.Namespace)
+	}
+
+	config, err := renderTraefikWorkspaceConfig(cheManager, workspaceConfig)
+	if err != nil {
+		return err
+	}
+
+	ingressRoute, middlewares, err := getTraefikObjects(cheManager, routing, config)
+	if err != nil {
+		return err
+	}
+
+	desired := map[string]bool{}
+	for _, mdl := range middlewares {
+		if _, _, err := o.syncer.Sync(context.TODO(), routing, mdl, traefikObjectDiffOpts); err != nil {
+			return err
+		}
+		desired[mdl.GetName()] = true
+	}
+
+	if ingressRoute == nil {
+		if err := o.syncer.Delete(context.TODO(), getIngressRouteStub(routing)); err != nil {
+			return err
+		}
+	} else if _, _, err := o.syncer.Sync(context.TODO(), routing, ingressRoute, traefikObjectDiffOpts); err != nil {
+		return err
+	}
+
+	// delete the middlewares that are no longer needed, e.g. after a change of the middleware profiles
+	existing, err := o.list(cheManager, routing, gateway.MiddlewareGVK)
+	if err != nil {
+		return err
+	}
+
+	for i := range existing {
+		if !desired[existing[i].GetName()] {
+			if err := o.syncer.Delete(context.TODO(), &existing[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (o *kubernetesCRDOutput) delete(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting) error {
+	return gateway.DeleteWorkspaceTraefikObjects(context.TODO(), o.client, &o.syncer, cheManager, routing.Namespace, routing.Spec.WorkspaceId)
+}
+
+// list returns the objects of the given kind with the configuration of the workspace.
+func (o *kubernetesCRDOutput) list(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting, gvk schema.GroupVersionKind) ([]unstructured.Unstructured, error) {
+	return gateway.ListWorkspaceTraefikObjects(context.TODO(), o.client, cheManager, routing.Namespace, routing.Spec.WorkspaceId, gvk)
+}
+
+// getTraefikObjects converts the dynamic configuration of the workspace to the IngressRoute and Middleware objects.
+// The IngressRoute contains all the routers of the workspace and references the Kubernetes services directly.
+func getTraefikObjects(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting, workspaceConfig traefikConfig) (*unstructured.Unstructured, []*unstructured.Unstructured, error) {
+	workspaceID := routing.Spec.WorkspaceId
+	labels := gateway.GetWorkspaceTraefikObjectLabels(cheManager, workspaceID)
+
+	// the error pages backend runs in the gateway pod and is accessible through a dedicated service
+	errorPagesService := map[string]interface{}{
+		"name":      gateway.GetErrorPagesServiceName(cheManager),
+		"namespace": cheManager.Namespace,
+		"port":      int64(gateway.ErrorPagesPort),
+	}
+
+	middlewares := []*unstructured.Unstructured{}
+	for _, name := range sortedKeys(workspaceConfig.HTTP.Middlewares) {
+		spec, err := getMiddlewareSpec(workspaceConfig.HTTP.Middlewares[name], errorPagesService)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		mdl := &unstructured.Unstructured{}
+		mdl.SetGroupVersionKind(gateway.MiddlewareGVK)
+		mdl.SetName(name)
+		mdl.SetNamespace(routing.Namespace)
+		mdl.SetLabels(labels)
+		mdl.Object["spec"] = spec
+
+		middlewares = append(middlewares, mdl)
+	}
+
+	routes := []interface{}{}
+	for _, name := range sortedRouterNames(workspaceConfig.HTTP.Routers) {
+		router := workspaceConfig.HTTP.Routers[name]
+
+		service, ok := workspaceConfig.HTTP.Services[router.Service]
+		if !ok || len(service.LoadBalancer.Servers) == 0 {
+			return nil, nil, fmt.Errorf("the service '%s' of the router '%s' is not defined", router.Service, name)
+		}
+
+		svc, err := getKubernetesServiceReference(service.LoadBalancer.Servers[0].URL)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		mdls := []interface{}{}
+		for _, m := range router.Middlewares {
+			mdls = append(mdls, map[string]interface{}{"name": m})
+		}
+
+		routes = append(routes, map[string]interface{}{
+			"kind":        "Rule",
+			"match":       router.Rule,
+			"priority":    int64(router.Priority),
+			"middlewares": mdls,
+			"services":    []interface{}{svc},
+		})
+	}
+
+	if len(routes) == 0 {
+		// the workspace doesn't expose anything
+		return nil, middlewares, nil
+	}
+
+	ingressRoute := getIngressRouteStub(routing)
+	ingressRoute.SetLabels(labels)
+	ingressRoute.Object["spec"] = map[string]interface{}{
+		"routes": routes,
+	}
+
+	return ingressRoute, middlewares, nil
+}
+
+func getIngressRouteStub(routing *dwo.WorkspaceRouting) *unstructured.Unstructured {
+	ret := &unstructured.Unstructured{}
+	ret.SetGroupVersionKind(gateway.IngressRouteGVK)
+	ret.SetName(routing.Spec.WorkspaceId)
+	ret.SetNamespace(routing.Namespace)
+	return ret
+}
+
+// getMiddlewareSpec converts the middleware from the file provider format to the spec of the Middleware object.
+// The formats only differ in how the other middlewares and services are referenced.
+func getMiddlewareSpec(mdl traefikConfigMiddleware, errorPagesService map[string]interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(mdl)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := map[string]interface{}{}
+	if err = json.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+
+	if mdl.Chain != nil {
+		refs := []interface{}{}
+		for _, name := range mdl.Chain.Middlewares {
+			refs = append(refs, map[string]interface{}{"name": name})
+		}
+		spec["chain"] = map[string]interface{}{"middlewares": refs}
+	}
+
+	if mdl.Errors != nil {
+		spec["errors"].(map[string]interface{})["service"] = errorPagesService
+	}
+
+	// the unstructured objects only support int64 and float64 numbers
+	return normalizeNumbers(spec).(map[string]interface{}), nil
+}
+
+// getKubernetesServiceReference converts the URL of the workspace service in the form of
+// "http://<name>.<namespace>.svc:<port>" to the reference to the service in the IngressRoute.
+func getKubernetesServiceReference(serviceURL string) (map[string]interface{}, error) {
+	u, err := url.Parse(serviceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseInt(u.Port(), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("the URL '%s' doesn't contain a valid port", serviceURL)
+	}
+
+	return map[string]interface{}{
+		"name": strings.SplitN(u.Hostname(), ".", 2)[0],
+		"port": port,
+	}, nil
+}
+
+// normalizeNumbers converts the whole numbers decoded from JSON as float64 to int64 so that they are rendered as
+// integers in the Traefik objects. The fractional numbers are kept as they are.
+func normalizeNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = normalizeNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = normalizeNumbers(e)
+		}
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < math.MaxInt64 {
+			return int64(v)
+		}
+	}
+	return value
+}
+
+func sortedKeys(mdls map[string]traefikConfigMiddleware) []string {
+	ret := make([]string, 0, len(mdls))
+	for k := range mdls {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func sortedRouterNames(rtrs map[string]traefikConfigRouter) []string {
+	ret := make([]string, 0, len(rtrs))
+	for k := range rtrs {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
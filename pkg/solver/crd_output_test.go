@@ -0,0 +1,153 @@
+package solver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/gateway"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/infrastructure"
+	chemanager "github.com/che-incubator/devworkspace-che-operator/pkg/manager"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func kubernetesCRDProviderCheManager() *v1alpha1.CheManager {
+	manager := simpleCheManager()
+	manager.Spec.Gateway.ConfigProvider = v1alpha1.KubernetesCRDConfigProvider
+	return manager
+}
+
+func getTraefikObject(cl client.Client, gvkKind string, name string) (*unstructured.Unstructured, error) {
+	obj := &unstructured.Unstructured{}
+	if gvkKind == gateway.IngressRouteGVK.Kind {
+		obj.SetGroupVersionKind(gateway.IngressRouteGVK)
+	} else {
+		obj.SetGroupVersionKind(gateway.MiddlewareGVK)
+	}
+	err := cl.Get(context.TODO(), client.ObjectKey{Name: name, Namespace: "ws"}, obj)
+	return obj, err
+}
+
+func TestKubernetesCRDProviderCreatesTraefikObjects(t *testing.T) {
+	infrastructure.TraefikCRDsAvailable = true
+	defer func() { infrastructure.TraefikCRDsAvailable = false }()
+
+	routing := simpleWorkspaceRouting()
+	manager := kubernetesCRDProviderCheManager()
+	cl, _, _ := getSpecObjectsForManager(t, routing, manager)
+
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "wsid", Namespace: "ns"}, &corev1.ConfigMap{}); err == nil {
+		t.Error("No workspace configmap should have been created in the Kubernetes CRD provider mode")
+	}
+
+	ingressRoute, err := getTraefikObject(cl, gateway.IngressRouteGVK.Kind, "wsid")
+	if err != nil {
+		t.Fatalf("Failed to get the ingress route of the workspace: %s", err)
+	}
+
+	if len(ingressRoute.GetOwnerReferences()) != 1 || ingressRoute.GetOwnerReferences()[0].Kind != "WorkspaceRouting" {
+		t.Error("The ingress route should be owned by the workspace routing")
+	}
+
+	for k, v := range gateway.GetWorkspaceConfigLabels(manager) {
+		if ingressRoute.GetLabels()[k] != v {
+			t.Errorf("The ingress route should have the label '%s=%s'", k, v)
+		}
+	}
+
+	routes, _, _ := unstructured.NestedSlice(ingressRoute.Object, "spec", "routes")
+	if len(routes) != 1 {
+		t.Fatalf("Expected exactly 1 route but got %d", len(routes))
+	}
+
+	route := routes[0].(map[string]interface{})
+	if route["match"] != "PathPrefix(`/wsid/m1/9999`)" {
+		t.Errorf("Unexpected match of the route: %v", route["match"])
+	}
+
+	services := route["services"].([]interface{})
+	svc := services[0].(map[string]interface{})
+	if svc["name"] != "wsid-service" || svc["port"] != int64(9999) {
+		t.Errorf("Unexpected service of the route: %v", svc)
+	}
+
+	for _, m := range route["middlewares"].([]interface{}) {
+		name := m.(map[string]interface{})["name"].(string)
+		if _, err := getTraefikObject(cl, gateway.MiddlewareGVK.Kind, name); err != nil {
+			t.Errorf("Failed to get the middleware '%s' referenced from the route: %s", name, err)
+		}
+	}
+
+	errorPages, err := getTraefikObject(cl, gateway.MiddlewareGVK.Kind, "wsid-error-pages")
+	if err != nil {
+		t.Fatalf("Failed to get the error pages middleware: %s", err)
+	}
+	errorService, _, _ := unstructured.NestedMap(errorPages.Object, "spec", "errors", "service")
+	if errorService["name"] != gateway.GetErrorPagesServiceName(manager) || errorService["namespace"] != "ns" {
+		t.Errorf("The error pages middleware should point to the error pages service but points to %v", errorService)
+	}
+}
+
+func TestKubernetesCRDProviderCleanedUpAfterSwitch(t *testing.T) {
+	infrastructure.TraefikCRDsAvailable = true
+	defer func() { infrastructure.TraefikCRDsAvailable = false }()
+
+	routing := simpleWorkspaceRouting()
+	cl, slv, _ := getSpecObjectsForManager(t, routing, kubernetesCRDProviderCheManager())
+
+	manager := &v1alpha1.CheManager{}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "che", Namespace: "ns"}, manager); err != nil {
+		t.Fatal(err)
+	}
+	manager.Spec.Gateway.ConfigProvider = v1alpha1.ConfigMapsConfigProvider
+	if err := cl.Update(context.TODO(), manager); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := slv.(*CheRoutingSolver).singlehostSpecObjects(manager, routing, getWorkspaceMeta(routing)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the solver only stores the configuration for the current config provider
+	if _, err := getTraefikObject(cl, gateway.IngressRouteGVK.Kind, "wsid"); err != nil {
+		t.Errorf("The ingress route should only be deleted by the che manager reconciler but got: %s", err)
+	}
+
+	cheRecon := chemanager.New(cl, createTestScheme())
+	if _, err := cheRecon.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "che", Namespace: "ns"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := getTraefikObject(cl, gateway.IngressRouteGVK.Kind, "wsid"); err == nil {
+		t.Error("The ingress route should have been deleted after switching to the configmaps mode")
+	}
+
+	if _, err := getTraefikObject(cl, gateway.MiddlewareGVK.Kind, "wsid-m1-9999-prefix-header"); err == nil {
+		t.Error("The middlewares should have been deleted after switching to the configmaps mode")
+	}
+
+	getWorkspaceTraefikConfig(t, cl)
+}
+
+func TestNormalizeNumbersKeepsFractions(t *testing.T) {
+	normalized := normalizeNumbers(map[string]interface{}{
+		"attempts": float64(3),
+		"ratio":    0.5,
+		"list":     []interface{}{float64(-2), 1.25},
+	}).(map[string]interface{})
+
+	if normalized["attempts"] != int64(3) {
+		t.Errorf("The whole number should have been converted to int64 but got: %#v", normalized["attempts"])
+	}
+	if normalized["ratio"] != 0.5 {
+		t.Errorf("The fractional number should have been kept but got: %#v", normalized["ratio"])
+	}
+	list := normalized["list"].([]interface{})
+	if list[0] != int64(-2) || list[1] != 1.25 {
+		t.Errorf("The numbers in the list should have been normalized but got: %#v", list)
+	}
+}
@@ -0,0 +1,380 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dwoche "github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/envoy"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/gateway"
+)
+
+const (
+	// the retry conditions corresponding to the failures retried by the retry middleware of Traefik
+	envoyRetryOn = "connect-failure,refused-stream,reset"
+)
+
+var (
+	// the paths of the REST xDS API and the types of the resources served on them
+	envoyDiscoveryPaths = map[string]string{
+		"/v3/discovery:listeners": envoy.ListenerTypeURL,
+		"/v3/discovery:clusters":  envoy.ClusterTypeURL,
+	}
+
+	// the status codes for which Envoy serves the error pages in place of the responses it generates itself
+	envoyErrorPageStatusCodes = []int{404, 502, 503, 504}
+)
+
+// getEnvoyResources returns the rendered resources of the given type. The resources have the type set so that
+// they can be directly sent in the discovery response.
+func getEnvoyResources(resources *envoyResources, typeURL string) ([]interface{}, error) {
+	ret := []interface{}{}
+
+	switch typeURL {
+	case envoy.ListenerTypeURL:
+		for _, l := range resources.listeners {
+			l.Type = envoy.ListenerTypeURL
+			ret = append(ret, l)
+		}
+	case envoy.ClusterTypeURL:
+		for _, c := range resources.clusters {
+			c.Type = envoy.ClusterTypeURL
+			ret = append(ret, c)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported resource type '%s'", typeURL)
+	}
+
+	return ret, nil
+}
+
+// renderEnvoyClusters renders a cluster for each route of the workspaces.
+func renderEnvoyClusters(cheManager *dwoche.CheManager, workspaces []workspaceGatewayConfig) ([]envoy.Cluster, error) {
+	ret := []envoy.Cluster{}
+
+	for _, ws := range workspaces {
+		for _, route := range ws.routes {
+			host, port, err := parseBackendURL(route.backendURL)
+			if err != nil {
+				return nil, err
+			}
+
+			cluster := envoy.NewCluster(route.name, "STRICT_DNS", host, port)
+
+			if hc := getHealthCheck(cheManager, route.healthCheckPath); hc != nil {
+				interval, err := toEnvoyDuration(hc.Interval)
+				if err != nil {
+					return nil, err
+				}
+				timeout, err := toEnvoyDuration(hc.Timeout)
+				if err != nil {
+					return nil, err
+				}
+
+				cluster.HealthChecks = []envoy.HealthCheck{
+					{
+						Interval:           interval,
+						Timeout:            timeout,
+						HealthyThreshold:   1,
+						UnhealthyThreshold: 1,
+						HTTPHealthCheck: envoy.HTTPHealthCheck{
+							Path: hc.Path,
+						},
+					},
+				}
+			}
+
+			ret = append(ret, cluster)
+		}
+	}
+
+	return ret, nil
+}
+
+// renderEnvoyListeners renders the listeners on the HTTP and HTTPS ports of the gateway with the routes of all
+// the workspaces.
+func renderEnvoyListeners(cheManager *dwoche.CheManager, workspaces []workspaceGatewayConfig) ([]envoy.Listener, error) {
+	routes := []envoy.Route{}
+	for _, ws := range workspaces {
+		for _, route := range ws.routes {
+			rs, err := renderEnvoyRoutes(cheManager, route)
+			if err != nil {
+				return nil, err
+			}
+			routes = append(routes, rs...)
+		}
+	}
+
+	// Envoy uses the first matching route so the more specific routes need to come first
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].Match.Path+routes[i].Match.Prefix) > len(routes[j].Match.Path+routes[j].Match.Prefix)
+	})
+
+	return []envoy.Listener{
+		envoy.NewHTTPListener("http", gateway.GatewayPort, getEnvoyHTTPConnectionManager(cheManager, "http", routes)),
+		envoy.NewHTTPListener("https", gateway.GatewaySecurePort, getEnvoyHTTPConnectionManager(cheManager, "https", routes)),
+	}, nil
+}
+
+// renderEnvoyRoutes renders the route into the Envoy routes. When the prefix is stripped, the route is split into
+// the route for the exact prefix and the route for the paths under the prefix so that the rewritten path always
+// starts with a slash.
+func renderEnvoyRoutes(cheManager *dwoche.CheManager, route workspaceGatewayRoute) ([]envoy.Route, error) {
+	template := envoy.Route{
+		Route: &envoy.RouteAction{
+			Cluster: route.name,
+			// the workspaces use long-lived connections, e.g. for the websockets
+			Timeout: "0s",
+		},
+	}
+
+	// set the X-Forwarded-Prefix header to the correct value or remove it altogether, in line with the Traefik
+	// implementation
+	if route.stripPrefix {
+		template.RequestHeadersToAdd = []envoy.HeaderValueOption{
+			{
+				Header: envoy.HeaderValue{Key: forwardedPrefixHeader, Value: route.pathPrefix},
+				Append: false,
+			},
+		}
+	} else {
+		template.RequestHeadersToRemove = []string{forwardedPrefixHeader}
+	}
+
+	if cfg := cheManager.Spec.WorkspaceBackends; cfg != nil && cfg.RetryAttempts > 0 {
+		template.Route.RetryPolicy = &envoy.RetryPolicy{
+			RetryOn:    envoyRetryOn,
+			NumRetries: cfg.RetryAttempts,
+		}
+	}
+
+	buffered := false
+	for _, profileName := range route.profiles {
+		profile := findMiddlewareProfile(cheManager, profileName)
+		if profile == nil {
+			return nil, fmt.Errorf("the middleware profile '%s' is not defined in the Che manager '%s' in namespace '%s'", profileName, cheManager.Name, cheManager.Namespace)
+		}
+		applyEnvoyMiddlewareProfile(&template, profile)
+		buffered = buffered || profile.MaxRequestBodyBytes != nil
+	}
+
+	if !buffered {
+		template.TypedPerFilterConfig = map[string]interface{}{
+			envoy.BufferFilterName: map[string]interface{}{
+				"@type":    envoy.BufferPerRouteTypeURL,
+				"disabled": true,
+			},
+		}
+	}
+
+	if !route.stripPrefix {
+		ret := template
+		ret.Name = route.name
+		ret.Match = envoy.RouteMatch{Prefix: route.pathPrefix}
+		return []envoy.Route{ret}, nil
+	}
+
+	exact := template
+	exact.Name = route.name
+	exact.Match = envoy.RouteMatch{Path: route.pathPrefix}
+	exact.Route = copyRouteAction(template.Route)
+	exact.Route.PrefixRewrite = "/"
+
+	prefix := template
+	prefix.Name = route.name + "-prefix"
+	prefix.Match = envoy.RouteMatch{Prefix: strings.TrimSuffix(route.pathPrefix, "/") + "/"}
+	prefix.Route = copyRouteAction(template.Route)
+	prefix.Route.PrefixRewrite = "/"
+
+	return []envoy.Route{exact, prefix}, nil
+}
+
+// applyEnvoyMiddlewareProfile configures the route to do what the middlewares of the profile do in Traefik.
+func applyEnvoyMiddlewareProfile(route *envoy.Route, profile *dwoche.MiddlewareProfile) {
+	if profile.MaxRequestBodyBytes != nil {
+		route.TypedPerFilterConfig = map[string]interface{}{
+			envoy.BufferFilterName: map[string]interface{}{
+				"@type": envoy.BufferPerRouteTypeURL,
+				"buffer": map[string]interface{}{
+					"max_request_bytes": *profile.MaxRequestBodyBytes,
+				},
+			},
+		}
+	}
+
+	// an empty value means the header should be removed, same as in Traefik
+	for _, name := range sortedHeaderNames(profile.RequestHeaders) {
+		if value := profile.RequestHeaders[name]; value == "" {
+			route.RequestHeadersToRemove = append(route.RequestHeadersToRemove, name)
+		} else {
+			route.RequestHeadersToAdd = append(route.RequestHeadersToAdd, envoy.HeaderValueOption{
+				Header: envoy.HeaderValue{Key: name, Value: value},
+			})
+		}
+	}
+
+	for _, name := range sortedHeaderNames(profile.ResponseHeaders) {
+		route.ResponseHeadersToAdd = append(route.ResponseHeadersToAdd, envoy.HeaderValueOption{
+			Header: envoy.HeaderValue{Key: name, Value: profile.ResponseHeaders[name]},
+		})
+	}
+
+	if cors := profile.CORS; cors != nil {
+		origin := envoy.StringMatcher{Exact: cors.AllowOrigin}
+		if cors.AllowOrigin == "*" {
+			origin = envoy.StringMatcher{SafeRegex: &envoy.RegexMatcher{GoogleRE2: map[string]interface{}{}, Regex: ".*"}}
+		}
+
+		policy := &envoy.CORSPolicy{
+			AllowMethods:     strings.Join(cors.AllowMethods, ","),
+			AllowHeaders:     strings.Join(cors.AllowHeaders, ","),
+			AllowCredentials: cors.AllowCredentials,
+		}
+		if cors.AllowOrigin != "" {
+			policy.AllowOriginStringMatch = []envoy.StringMatcher{origin}
+		}
+		if cors.MaxAge > 0 {
+			policy.MaxAge = strconv.FormatInt(cors.MaxAge, 10)
+		}
+
+		route.Route.CORS = policy
+	}
+}
+
+// getEnvoyHTTPConnectionManager returns the HTTP connection manager of the listener. Apart from the routes of
+// the workspaces, it serves the error pages for the errors generated by Envoy itself, e.g. when there is no route
+// for the request or the workspace is not available.
+func getEnvoyHTTPConnectionManager(cheManager *dwoche.CheManager, name string, routes []envoy.Route) *envoy.HTTPConnectionManager {
+	mappers := []envoy.ResponseMapper{}
+	for _, code := range envoyErrorPageStatusCodes {
+		mappers = append(mappers, envoy.ResponseMapper{
+			Filter: envoy.AccessLogFilter{
+				StatusCodeFilter: envoy.StatusCodeFilter{
+					Comparison: envoy.ComparisonFilter{
+						Op: "EQ",
+						Value: envoy.RuntimeUInt32{
+							DefaultValue: code,
+							RuntimeKey:   fmt.Sprintf("che_gateway.local_reply.%d", code),
+						},
+					},
+				},
+			},
+			Body: &envoy.DataSource{InlineString: gateway.GetDefaultErrorPage(code)},
+			BodyFormatOverride: &envoy.SubstitutionFormatString{
+				TextFormat:  "%LOCAL_REPLY_BODY%",
+				ContentType: "text/html; charset=UTF-8",
+			},
+		})
+	}
+
+	hcm := &envoy.HTTPConnectionManager{
+		RouteConfig: envoy.RouteConfiguration{
+			Name: name,
+			VirtualHosts: []envoy.VirtualHost{
+				{
+					Name:    name,
+					Domains: []string{"*"},
+					Routes:  routes,
+				},
+			},
+		},
+		HTTPFilters: []envoy.HTTPFilter{
+			{Name: envoy.CORSFilterName},
+			{
+				Name: envoy.BufferFilterName,
+				TypedConfig: map[string]interface{}{
+					"@type": envoy.BufferTypeURL,
+					// the limit is only used on the routes that don't override it, which are the routes
+					// without any buffering profile where the buffering is disabled
+					"max_request_bytes": 1048576,
+				},
+			},
+			{Name: envoy.RouterFilterName},
+		},
+		UpgradeConfigs:   []envoy.UpgradeConfig{{UpgradeType: "websocket"}},
+		LocalReplyConfig: &envoy.LocalReplyConfig{Mappers: mappers},
+	}
+
+	if cheManager != nil && cheManager.Spec.Gateway.AccessLog != nil {
+		typedConfig := map[string]interface{}{
+			"@type": envoy.StdoutAccessLogTypeURL,
+		}
+		if cheManager.Spec.Gateway.AccessLog.Format == "json" {
+			typedConfig["log_format"] = map[string]interface{}{
+				"json_format": map[string]interface{}{
+					"time":     "%START_TIME%",
+					"method":   "%REQ(:METHOD)%",
+					"path":     "%REQ(X-ENVOY-ORIGINAL-PATH?:PATH)%",
+					"protocol": "%PROTOCOL%",
+					"status":   "%RESPONSE_CODE%",
+					"duration": "%DURATION%",
+					"upstream": "%UPSTREAM_CLUSTER%",
+				},
+			}
+		}
+
+		hcm.AccessLog = []envoy.AccessLog{
+			{
+				Name:        envoy.StdoutAccessLogName,
+				TypedConfig: typedConfig,
+			},
+		}
+	}
+
+	return hcm
+}
+
+// parseBackendURL returns the host and port of the workspace service URL.
+func parseBackendURL(backendURL string) (string, int, error) {
+	u, err := url.Parse(backendURL)
+	if err != nil {
+		return "", 0, err
+	}
+
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return "", 0, fmt.Errorf("the URL '%s' doesn't contain a valid port", backendURL)
+	}
+
+	return u.Hostname(), port, nil
+}
+
+// toEnvoyDuration converts the duration in the Go format as used in the che manager to the JSON format of
+// the protobuf duration used by Envoy, which only supports seconds.
+func toEnvoyDuration(value string) (string, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid duration '%s': %s", value, err)
+	}
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s", nil
+}
+
+func copyRouteAction(action *envoy.RouteAction) *envoy.RouteAction {
+	ret := *action
+	return &ret
+}
+
+func sortedHeaderNames(headers map[string]string) []string {
+	ret := make([]string, 0, len(headers))
+	for k := range headers {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,182 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	dwoche "github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/gateway"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/sync"
+	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	"github.com/devfile/devworkspace-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// gatewayConfigOutput puts the dynamic configuration of a workspace where the gateway reads it from. There is one
+// implementation for each of the config providers the gateway can be configured with.
+type gatewayConfigOutput interface {
+	// sync makes sure the gateway of the che manager gets the configuration of the workspace.
+	sync(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting, workspaceConfig workspaceGatewayConfig) error
+
+	// delete removes the configuration of the workspace from the output. It must succeed even if there is no
+	// configuration of the workspace in the output.
+	delete(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting) error
+}
+
+// getGatewayConfigOutput returns the output matching the config provider of the gateway of the che manager. The che
+// manager reconciler removes the configuration of the workspaces from the other outputs when the config provider
+// changes.
+func (c *CheRoutingSolver) getGatewayConfigOutput(cheManager *dwoche.CheManager) gatewayConfigOutput {
+	return c.getAllGatewayConfigOutputs()[gateway.GetConfigProvider(cheManager)]
+}
+
+// getAllGatewayConfigOutputs returns the outputs of all the config providers the gateway can be configured with.
+func (c *CheRoutingSolver) getAllGatewayConfigOutputs() map[dwoche.GatewayConfigProvider]gatewayConfigOutput {
+	return map[dwoche.GatewayConfigProvider]gatewayConfigOutput{
+		// the config maps are not owned by the routing, so the syncer can't record the events about them
+		dwoche.ConfigMapsConfigProvider: &configMapsOutput{client: c.client, recorder: c.recorder, syncer: c.newSyncer(nil), dryRun: c.dryRun},
+		dwoche.HTTPConfigProvider:       &httpOutput{},
+		// the objects are owned by the routing, so the syncer records the events about them on the routing
+		dwoche.KubernetesCRDConfigProvider: &kubernetesCRDOutput{client: c.client, syncer: c.newSyncer(c.recorder)},
+	}
+}
+
+// newSyncer returns the syncer of the gateway configuration of the workspaces.
+func (c *CheRoutingSolver) newSyncer(recorder record.EventRecorder) sync.Syncer {
+	syncer := sync.New(c.client, c.scheme, recorder, c.fieldManager)
+	if c.dryRun {
+		return syncer.DryRun()
+	}
+	return syncer
+}
+
+// configMapsOutput stores the configuration of the workspace in a config map in the namespace of the che manager.
+// The config maps are synced into the gateway pod by a sidecar and read by the file provider of the gateway.
+type configMapsOutput struct {
+	client   client.Client
+	recorder record.EventRecorder
+	syncer   sync.Syncer
+	dryRun   bool
+}
+
+var _ gatewayConfigOutput = (*configMapsOutput)(nil)
+
+func (o *configMapsOutput) sync(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting, workspaceConfig workspaceGatewayConfig) error {
+	configMaps, err := getGatewayConfigMaps(cheManager, routing.Spec.WorkspaceId, routing, workspaceConfig)
+	if err != nil {
+		return err
+	}
+
+	var changes []string
+	desired := map[string]bool{}
+	for _, cm := range configMaps {
+		report, _, err := o.syncer.SyncAndReport(context.TODO(), nil, &cm, configMapDiffOpts)
+		if err != nil {
+			return err
+		}
+		if report != nil {
+			changes = append(changes, report.String())
+		}
+		desired[cm.Name] = true
+	}
+
+	// delete the parts that are no longer needed after the configuration shrank
+	existing, err := o.list(cheManager, routing)
+	if err != nil {
+		return err
+	}
+
+	for i := range existing {
+		if !desired[existing[i].Name] {
+			report, err := o.syncer.DeleteAndReport(context.TODO(), &existing[i])
+			if err != nil {
+				return err
+			}
+			if report != nil {
+				changes = append(changes, report.String())
+			}
+		}
+	}
+
+	// the syncer doesn't record the events about the config maps, so we record the summary of the changes on
+	// the routing
+	if len(changes) > 0 && o.recorder != nil {
+		reason, verb := "GatewayConfigured", "Updated"
+		if o.dryRun {
+			reason, verb = "DryRunGatewayConfigured", "Would update"
+		}
+		o.recorder.Eventf(routing, corev1.EventTypeNormal, reason, "%s the gateway configuration of the workspace in the namespace %s: %s", verb, cheManager.Namespace, strings.Join(changes, "; "))
+	}
+
+	return nil
+}
+
+func (o *configMapsOutput) delete(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting) error {
+	configs, err := o.list(cheManager, routing)
+	if err != nil {
+		return err
+	}
+
+	for i := range configs {
+		if err = o.syncer.Delete(context.TODO(), &configs[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// list returns all the config maps with the parts of the configuration of the workspace.
+func (o *configMapsOutput) list(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting) ([]corev1.ConfigMap, error) {
+	configs := &corev1.ConfigMapList{}
+
+	selector, err := labels.Parse(fmt.Sprintf("%s=%s", config.WorkspaceIDLabel, routing.Spec.WorkspaceId))
+	if err != nil {
+		return nil, err
+	}
+
+	listOpts := &client.ListOptions{
+		Namespace:     cheManager.Namespace,
+		LabelSelector: selector,
+	}
+
+	if err = o.client.List(context.TODO(), configs, listOpts); err != nil {
+		return nil, err
+	}
+
+	return configs.Items, nil
+}
+
+// httpOutput doesn't store the configuration of the workspace anywhere. The config server renders it from
+// the workspace routing whenever the gateway asks for it.
+type httpOutput struct{}
+
+var _ gatewayConfigOutput = (*httpOutput)(nil)
+
+func (o *httpOutput) sync(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting, workspaceConfig workspaceGatewayConfig) error {
+	// the configuration is rendered only when the gateway asks for it, so check that it can be rendered now
+	// to report any problem on the workspace routing
+	_, err := getGatewayConfigRenderer(cheManager).render(cheManager, []workspaceGatewayConfig{workspaceConfig})
+	return err
+}
+
+func (o *httpOutput) delete(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting) error {
+	// the config server stops serving the configuration once the routing is being deleted
+	return nil
+}
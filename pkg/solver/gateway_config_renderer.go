@@ -0,0 +1,109 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	"fmt"
+
+	dwoche "github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/envoy"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/gateway"
+)
+
+// gatewayConfigRenderer renders the configuration of the workspaces into the dynamic configuration of the gateway.
+// There is one implementation for each of the gateway implementations. The gateway config outputs use the renderer
+// of the che manager to produce what they store or serve.
+type gatewayConfigRenderer interface {
+	// render renders the configuration of the given workspaces together.
+	render(cheManager *dwoche.CheManager, workspaces []workspaceGatewayConfig) (renderedGatewayConfig, error)
+}
+
+// renderedGatewayConfig is the dynamic configuration of the gateway rendered by a renderer. Only the field of
+// the gateway implementation of the renderer is set.
+type renderedGatewayConfig struct {
+	traefik *traefikConfig
+	envoy   *envoyResources
+}
+
+// envoyResources are the resources the Envoy gateways obtain using the xDS API.
+type envoyResources struct {
+	listeners []envoy.Listener
+	clusters  []envoy.Cluster
+}
+
+// getGatewayConfigRenderer returns the renderer for the gateway implementation of the che manager.
+func getGatewayConfigRenderer(cheManager *dwoche.CheManager) gatewayConfigRenderer {
+	if gateway.UsesEnvoy(cheManager) {
+		return envoyRenderer{}
+	}
+	return traefikRenderer{}
+}
+
+// renderTraefikWorkspaceConfig renders the configuration of a single workspace for the outputs that store
+// the Traefik configuration of each workspace separately.
+func renderTraefikWorkspaceConfig(cheManager *dwoche.CheManager, workspaceConfig workspaceGatewayConfig) (traefikConfig, error) {
+	rendered, err := getGatewayConfigRenderer(cheManager).render(cheManager, []workspaceGatewayConfig{workspaceConfig})
+	if err != nil {
+		return traefikConfig{}, err
+	}
+
+	if rendered.traefik == nil {
+		return traefikConfig{}, fmt.Errorf("the gateway of the che manager '%s' in namespace '%s' cannot read the configuration of the workspaces from the '%s' config provider",
+			cheManager.Name, cheManager.Namespace, gateway.GetConfigProvider(cheManager))
+	}
+
+	return *rendered.traefik, nil
+}
+
+type traefikRenderer struct{}
+
+var _ gatewayConfigRenderer = traefikRenderer{}
+
+func (traefikRenderer) render(cheManager *dwoche.CheManager, workspaces []workspaceGatewayConfig) (renderedGatewayConfig, error) {
+	config := traefikConfig{
+		HTTP: traefikConfigHTTP{
+			Routers:     map[string]traefikConfigRouter{},
+			Services:    map[string]traefikConfigService{},
+			Middlewares: map[string]traefikConfigMiddleware{},
+		},
+	}
+
+	for _, ws := range workspaces {
+		// all the names in the workspace configs are prefixed by the workspace ID so there are no conflicts
+		wsConfig, err := renderTraefikConfig(cheManager, ws)
+		if err != nil {
+			return renderedGatewayConfig{}, err
+		}
+		mergeTraefikConfig(&config, &wsConfig)
+	}
+
+	return renderedGatewayConfig{traefik: &config}, nil
+}
+
+type envoyRenderer struct{}
+
+var _ gatewayConfigRenderer = envoyRenderer{}
+
+func (envoyRenderer) render(cheManager *dwoche.CheManager, workspaces []workspaceGatewayConfig) (renderedGatewayConfig, error) {
+	listeners, err := renderEnvoyListeners(cheManager, workspaces)
+	if err != nil {
+		return renderedGatewayConfig{}, err
+	}
+
+	clusters, err := renderEnvoyClusters(cheManager, workspaces)
+	if err != nil {
+		return renderedGatewayConfig{}, err
+	}
+
+	return renderedGatewayConfig{envoy: &envoyResources{listeners: listeners, clusters: clusters}}, nil
+}
@@ -0,0 +1,128 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// the route name parts that can be used verbatim, they can't start or end with a dash or contain two dashes in
+	// a row so that the plain names never look like the hashed ones
+	plainRouteNamePart = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+	// any character that can't be used in the name of a Kubernetes object
+	invalidRouteNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+	// The endpoint names that would make the route name equal to a name derived from the name of another route,
+	// e.g. the name of its retry middleware. The machine names that would make the route name equal to
+	// the workspace-wide names, e.g. the names of the middleware profiles.
+	reservedEndpointNames = map[string]bool{"prefix": true, "retry": true, "circuit": true}
+	reservedMachineNames  = map[string]bool{"profile": true, "error": true}
+)
+
+// workspaceGatewayConfig is the configuration of the gateway for a single workspace independent of the gateway
+// implementation. It is rendered into the configuration format of the implementation by the gateway config outputs
+// or by the config server.
+type workspaceGatewayConfig struct {
+	workspaceID string
+	routes      []workspaceGatewayRoute
+}
+
+// workspaceGatewayRoute is a single public URL of the workspace and the service it is routed to.
+type workspaceGatewayRoute struct {
+	// name is unique within the gateway and is used as the base name for all the objects in the rendered
+	// configuration
+	name string
+
+	// pathPrefix is the path prefix of the public URL
+	pathPrefix string
+
+	// stripPrefix says whether the path prefix should be removed from the requests before passing them to the backend
+	stripPrefix bool
+
+	// errorPages says whether the gateway should serve its error pages instead of the 502, 503 and 504 responses
+	// for the route
+	errorPages bool
+
+	// backendURL is the URL of the workspace service
+	backendURL string
+
+	// healthCheckPath is the path on the backend the gateway should actively check, empty if the backend should
+	// not be checked
+	healthCheckPath string
+
+	// profiles are the names of the middleware profiles of the che manager applied to the route
+	profiles []string
+}
+
+// getRouteName returns the name of the route for the endpoints of the machine on the given port. The endpoint name
+// is only non-empty for the unique endpoints.
+//
+// When all the parts are valid in a Kubernetes name, the name is just the parts joined by dashes so that it is easy
+// to read. Such names never contain two consecutive dashes. The parts containing dashes can make two routes end up
+// with the same name, which is reported by checkRouteCollisions and by the gateway config renderers. The other names
+// contain the sanitized parts followed by two dashes and the hash of the parts.
+func getRouteName(workspaceID string, machineName string, port int32, endpointName string) string {
+	parts := []string{workspaceID, machineName, strconv.Itoa(int(port))}
+	if endpointName != "" {
+		parts = append(parts, endpointName)
+	}
+
+	plain := !reservedMachineNames[machineName] && !reservedEndpointNames[endpointName]
+	for _, p := range parts {
+		plain = plain && plainRouteNamePart.MatchString(p)
+	}
+
+	if plain {
+		return strings.Join(parts, "-")
+	}
+
+	// the parts are separated by a character that can't appear in them, so the hash is unique for each combination
+	hash := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+
+	sanitized := []string{workspaceID}
+	for _, p := range parts[1:] {
+		sanitized = append(sanitized, strings.Trim(invalidRouteNameChars.ReplaceAllString(strings.ToLower(p), "-"), "-"))
+	}
+
+	return fmt.Sprintf("%s--%x", strings.Join(sanitized, "-"), hash[:5])
+}
+
+// checkRouteCollisions returns an error describing the first pair of routes that have the same name or whose path
+// prefixes overlap such that one of them would receive the requests meant for the other.
+func checkRouteCollisions(routes []workspaceGatewayRoute) error {
+	for i := range routes {
+		for j := i + 1; j < len(routes); j++ {
+			a, b := routes[i], routes[j]
+
+			if a.name == b.name {
+				return fmt.Errorf("the routes of the endpoints have the same name '%s'", a.name)
+			}
+
+			if a.pathPrefix == b.pathPrefix {
+				return fmt.Errorf("the routes '%s' and '%s' are exposed on the same path '%s'", a.name, b.name, a.pathPrefix)
+			}
+
+			if strings.HasPrefix(b.pathPrefix, a.pathPrefix+"/") || strings.HasPrefix(a.pathPrefix, b.pathPrefix+"/") {
+				return fmt.Errorf("the path of the route '%s' overlaps with the path of the route '%s'", a.name, b.name)
+			}
+		}
+	}
+
+	return nil
+}
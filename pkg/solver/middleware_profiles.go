@@ -0,0 +1,149 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	dwoche "github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
+)
+
+// parseMiddlewareProfileNames parses the comma-separated list of the middleware profile names as used in
+// the routing annotation and endpoint attribute.
+func parseMiddlewareProfileNames(value string) []string {
+	ret := []string{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+// mergeMiddlewareProfileNames returns the deduplicated list of the profile names in the supplied lists.
+// The profiles required by the routing come first, followed by the profiles of the endpoints in
+// alphabetical order so that the generated configuration is stable.
+func mergeMiddlewareProfileNames(routingProfiles []string, endpointProfiles []string) []string {
+	seen := map[string]bool{}
+	ret := []string{}
+
+	for _, name := range routingProfiles {
+		if !seen[name] {
+			seen[name] = true
+			ret = append(ret, name)
+		}
+	}
+
+	additional := []string{}
+	for _, name := range endpointProfiles {
+		if !seen[name] {
+			seen[name] = true
+			additional = append(additional, name)
+		}
+	}
+	sort.Strings(additional)
+
+	return append(ret, additional...)
+}
+
+// addMiddlewareProfile makes sure the middlewares of the profile with the given name are present in the
+// provided middlewares and returns the name of the middleware that should be referenced by the routers
+// that require the profile. If the profile doesn't define any middleware, an empty string is returned.
+// If the che manager doesn't define a profile with the given name, a RoutingInvalid error is returned.
+func addMiddlewareProfile(cheManager *dwoche.CheManager, workspaceID string, profileName string, mdls map[string]traefikConfigMiddleware) (string, error) {
+	profile := findMiddlewareProfile(cheManager, profileName)
+	if profile == nil {
+		return "", &solvers.RoutingInvalid{Reason: fmt.Sprintf("the middleware profile '%s' is not defined in the Che manager '%s' in namespace '%s'", profileName, cheManager.Name, cheManager.Namespace)}
+	}
+
+	// the middlewares are added again if another router already required this profile, which is fine as long as
+	// they don't collide with any other middlewares
+	name := getMiddlewareProfileName(workspaceID, profileName)
+
+	parts := []string{}
+
+	if profile.MaxRequestBodyBytes != nil {
+		partName := name + "-buffering"
+		err := addTraefikMiddleware(mdls, partName, traefikConfigMiddleware{
+			Buffering: &traefikConfigBuffering{
+				MaxRequestBodyBytes: *profile.MaxRequestBodyBytes,
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, partName)
+	}
+
+	if profile.CORS != nil || len(profile.RequestHeaders) > 0 || len(profile.ResponseHeaders) > 0 {
+		headers := &traefikConfigHeaders{
+			CustomRequestHeaders:  profile.RequestHeaders,
+			CustomResponseHeaders: profile.ResponseHeaders,
+		}
+
+		if profile.CORS != nil {
+			headers.AccessControlAllowOrigin = profile.CORS.AllowOrigin
+			headers.AccessControlAllowMethods = profile.CORS.AllowMethods
+			headers.AccessControlAllowHeaders = profile.CORS.AllowHeaders
+			headers.AccessControlAllowCredentials = profile.CORS.AllowCredentials
+			headers.AccessControlMaxAge = profile.CORS.MaxAge
+			headers.AddVaryHeader = true
+		}
+
+		partName := name + "-headers"
+		if err := addTraefikMiddleware(mdls, partName, traefikConfigMiddleware{Headers: headers}); err != nil {
+			return "", err
+		}
+		parts = append(parts, partName)
+	}
+
+	if len(parts) == 0 {
+		return "", nil
+	}
+
+	err := addTraefikMiddleware(mdls, name, traefikConfigMiddleware{
+		Chain: &traefikConfigChain{
+			Middlewares: parts,
+		},
+	})
+
+	return name, err
+}
+
+// getMiddlewareProfileName returns the name of the middleware of the profile in the workspace. The profile names
+// are chosen by the users, so they are sanitized the same way as the route names. The names that needed sanitizing
+// are suffixed with the hash of the original name so that different profiles never share a middleware.
+func getMiddlewareProfileName(workspaceID string, profileName string) string {
+	if plainRouteNamePart.MatchString(profileName) {
+		return fmt.Sprintf("%s-profile-%s", workspaceID, profileName)
+	}
+
+	hash := sha256.Sum256([]byte(profileName))
+	sanitized := strings.Trim(invalidRouteNameChars.ReplaceAllString(strings.ToLower(profileName), "-"), "-")
+
+	return fmt.Sprintf("%s-profile-%s--%x", workspaceID, sanitized, hash[:5])
+}
+
+func findMiddlewareProfile(cheManager *dwoche.CheManager, name string) *dwoche.MiddlewareProfile {
+	for i := range cheManager.Spec.MiddlewareProfiles {
+		if cheManager.Spec.MiddlewareProfiles[i].Name == name {
+			return &cheManager.Spec.MiddlewareProfiles[i]
+		}
+	}
+	return nil
+}
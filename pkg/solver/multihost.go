@@ -13,21 +13,24 @@
 package solver
 
 import (
-	"fmt"
-
 	dwoche "github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
 	dw "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
 	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
 )
 
+// multihostSpecObjects exposes each endpoint on its own subdomain of the host of the che manager using
+// the ingresses or routes, the same way as the basic routing class does.
 func (c *CheRoutingSolver) multihostSpecObjects(cheManager *dwoche.CheManager, routing *dw.WorkspaceRouting, workspaceMeta solvers.WorkspaceMetadata) (solvers.RoutingObjects, error) {
-	return solvers.RoutingObjects{}, fmt.Errorf("multihost currently not supported")
+	workspaceMeta.RoutingSuffix = cheManager.Spec.Host
+
+	return (&solvers.BasicSolver{}).GetSpecObjects(routing, workspaceMeta)
 }
 
 func (c *CheRoutingSolver) multihostExposedEndpoints(manager *dwoche.CheManager, workspaceID string, endpoints map[string]dw.EndpointList, routingObj solvers.RoutingObjects) (exposedEndpoints map[string]dw.ExposedEndpointList, ready bool, err error) {
-	return nil, false, fmt.Errorf("multihost currently not supported")
+	return (&solvers.BasicSolver{}).GetExposedEndpoints(endpoints, routingObj)
 }
 
 func (c *CheRoutingSolver) multihostFinalize(cheManager *dwoche.CheManager, routing *dw.WorkspaceRouting) error {
-	return nil
+	// the routing might have been switched from the singlehost mode without being solved in the multihost mode yet
+	return c.singlehostFinalize(cheManager, routing)
 }
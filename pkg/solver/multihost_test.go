@@ -0,0 +1,66 @@
+package solver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	chemanager "github.com/che-incubator/devworkspace-che-operator/pkg/manager"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestSwitchFromSinglehostToMultihost(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	cl, slv, _ := getSpecObjects(t, routing)
+
+	manager := &v1alpha1.CheManager{}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "che", Namespace: "ns"}, manager); err != nil {
+		t.Fatal(err)
+	}
+	manager.Spec.Routing = v1alpha1.MultiHost
+	manager.Status.GatewayConfigProvider = v1alpha1.ConfigMapsConfigProvider
+	if err := cl.Update(context.TODO(), manager); err != nil {
+		t.Fatal(err)
+	}
+
+	objs, err := slv.GetSpecObjects(routing, getWorkspaceMeta(routing))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(objs.Ingresses) != 3 {
+		t.Fatalf("There should be an ingress for each of the 3 public endpoints but there were %d", len(objs.Ingresses))
+	}
+
+	for _, ingress := range objs.Ingresses {
+		if !strings.HasSuffix(ingress.Spec.Rules[0].Host, ".over.the.rainbow") {
+			t.Errorf("The ingress '%s' should expose the endpoint on a subdomain of the che host but exposes it on '%s'", ingress.Name, ingress.Spec.Rules[0].Host)
+		}
+	}
+
+	for _, svc := range objs.Services {
+		if svc.Annotations[defaults.ConfigAnnotationCheManagerName] != "che" {
+			t.Errorf("The service '%s' should have been annotated with the che manager", svc.Name)
+		}
+	}
+
+	// the solver only stores the configuration in the singlehost mode, the che manager cleans it up
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "wsid", Namespace: "ns"}, &corev1.ConfigMap{}); err != nil {
+		t.Errorf("The singlehost gateway configuration should only be deleted by the che manager reconciler but got: %s", err)
+	}
+
+	cheRecon := chemanager.New(cl, createTestScheme())
+	if _, err = cheRecon.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "che", Namespace: "ns"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "wsid", Namespace: "ns"}, &corev1.ConfigMap{}); !errors.IsNotFound(err) {
+		t.Errorf("The singlehost gateway configuration of the workspace should have been deleted but got: %v", err)
+	}
+}
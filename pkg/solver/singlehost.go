@@ -13,14 +13,13 @@
 package solver
 
 import (
-	"context"
 	"fmt"
 	"path"
+	"sort"
 	"strings"
 
 	dwoche "github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
-	"github.com/che-incubator/devworkspace-che-operator/pkg/sync"
 	dw "github.com/devfile/api/pkg/apis/workspaces/v1alpha2"
 	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
 	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
@@ -29,22 +28,38 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/yaml"
 )
 
 const (
-	uniqueEndpointAttributeName = "unique"
-	endpointURLPrefixPattern    = "/%s/%s/%d"
+	uniqueEndpointAttributeName     = "unique"
+	middlewareProfilesAttributeName = "middlewareProfiles"
+	stripPrefixAttributeName        = "stripPrefix"
+	healthCheckPathAttributeName    = "healthCheckPath"
+	errorPagesAttributeName         = "errorPages"
+	forwardedPrefixHeader           = "X-Forwarded-Prefix"
+	endpointURLPrefixPattern        = "/%s/%s/%d"
 	// note - che-theia DEPENDS on this format - we should not change this unless crosschecked with the che-theia impl
 	uniqueEndpointURLPrefixPattern = "/%s/%s/%s"
 )
 
 var (
 	configMapDiffOpts = cmpopts.IgnoreFields(corev1.ConfigMap{}, "TypeMeta", "ObjectMeta")
+
+	// The maximum size of the data of a single config map with the gateway configuration. The limit of the size
+	// of the whole config map is 1MiB, we leave some space for the metadata.
+	maxGatewayConfigMapDataSize = 900 * 1024
 )
 
+// gatewayRoute collects the configuration of the gateway route shared by all the endpoints exposed on
+// the same public URL.
+type gatewayRoute struct {
+	profiles        []string
+	stripPrefix     bool
+	errorPages      bool
+	healthCheckPath string
+}
+
 func (c *CheRoutingSolver) singlehostSpecObjects(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting, workspaceMeta solvers.WorkspaceMetadata) (solvers.RoutingObjects, error) {
 	objs := solvers.RoutingObjects{}
 
@@ -55,54 +70,16 @@ func (c *CheRoutingSolver) singlehostSpecObjects(cheManager *dwoche.CheManager,
 		objs.Services = append(objs.Services, *commonService)
 	}
 
-	annos := map[string]string{}
-	annos[defaults.ConfigAnnotationCheManagerName] = cheManager.Name
-	annos[defaults.ConfigAnnotationCheManagerNamespace] = cheManager.Namespace
-
-	additionalLabels := defaults.GetLabelsForComponent(cheManager, "exposure")
-
-	for i := range objs.Services {
-		// need to use a ref otherwise s would be a copy
-		s := &objs.Services[i]
-
-		if s.Labels == nil {
-			s.Labels = map[string]string{}
-		}
-
-		for k, v := range additionalLabels {
-
-			if len(s.Labels[k]) == 0 {
-				s.Labels[k] = v
-			}
-		}
-
-		if s.Annotations == nil {
-			s.Annotations = map[string]string{}
-		}
-
-		for k, v := range annos {
-
-			if len(s.Annotations[k]) == 0 {
-				s.Annotations[k] = v
-			}
-		}
-	}
-
 	// k, now we have to create our own objects for configuring the gateway
-	configMaps, err := c.getGatewayConfigMaps(cheManager, workspaceMeta.WorkspaceId, routing)
+	config, err := getWorkspaceGatewayConfig(cheManager, workspaceMeta.WorkspaceId, routing)
 	if err != nil {
 		return solvers.RoutingObjects{}, err
 	}
 
-	syncer := sync.New(c.client, c.scheme)
-
-	for _, cm := range configMaps {
-		_, _, err := syncer.Sync(context.TODO(), nil, &cm, configMapDiffOpts)
-		if err != nil {
-			return solvers.RoutingObjects{}, err
-		}
-
+	if err = c.getGatewayConfigOutput(cheManager).sync(cheManager, routing, config); err != nil {
+		return solvers.RoutingObjects{}, err
 	}
+
 	return objs, nil
 }
 
@@ -144,6 +121,9 @@ func (c *CheRoutingSolver) singlehostExposedEndpoints(manager *dwoche.CheManager
 				// in the future
 			}
 
+			// The public URL is the same regardless of whether the gateway strips the prefix or not. If it
+			// doesn't, the application is expected to serve its content on the prefixed path, which is
+			// how the gateway passes the requests to it.
 			publicURLPrefix := getPublicURLPrefixForEndpoint(workspaceID, machineName, endpoint)
 
 			publicURL := scheme + "://" + path.Join(host, publicURLPrefix, endpoint.Path)
@@ -171,7 +151,7 @@ func (c *CheRoutingSolver) singlehostExposedEndpoints(manager *dwoche.CheManager
 	return exposed, true, nil
 }
 
-func (c *CheRoutingSolver) getGatewayConfigMaps(cheManager *dwoche.CheManager, workspaceID string, routing *dwo.WorkspaceRouting) ([]corev1.ConfigMap, error) {
+func getGatewayConfigMaps(cheManager *dwoche.CheManager, workspaceID string, routing *dwo.WorkspaceRouting, workspaceConfig workspaceGatewayConfig) ([]corev1.ConfigMap, error) {
 	restrictedAnno, setRestrictedAnno := routing.Annotations[config.WorkspaceRestrictedAccessAnnotation]
 
 	labels := defaults.GetLabelsForComponent(cheManager, "gateway-config")
@@ -180,28 +160,139 @@ func (c *CheRoutingSolver) getGatewayConfigMaps(cheManager *dwoche.CheManager, w
 		labels[config.WorkspaceRestrictedAccessAnnotation] = restrictedAnno
 	}
 
-	configMap := corev1.ConfigMap{
-		ObjectMeta: v1.ObjectMeta{
-			Name:      defaults.GetGatewayWorkpaceConfigMapName(workspaceID),
-			Namespace: cheManager.Namespace,
-			Labels:    labels,
-			Annotations: map[string]string{
-				defaults.ConfigAnnotationWorkspaceRoutingName:      routing.Name,
-				defaults.ConfigAnnotationWorkspaceRoutingNamespace: routing.Namespace,
+	config, err := renderTraefikWorkspaceConfig(cheManager, workspaceConfig)
+	if err != nil {
+		return []corev1.ConfigMap{}, err
+	}
+
+	parts, err := splitTraefikConfig(config)
+	if err != nil {
+		return []corev1.ConfigMap{}, err
+	}
+
+	ret := []corev1.ConfigMap{}
+	for i, contents := range parts {
+		name := defaults.GetGatewayWorkspaceConfigMapPartName(workspaceID, i)
+
+		ret = append(ret, corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      name,
+				Namespace: cheManager.Namespace,
+				Labels:    labels,
+				Annotations: map[string]string{
+					defaults.ConfigAnnotationWorkspaceRoutingName:      routing.Name,
+					defaults.ConfigAnnotationWorkspaceRoutingNamespace: routing.Namespace,
+				},
 			},
+			Data: map[string]string{
+				// the config maps are synced into the same directory so the keys need to be unique, too
+				name + ".yml": contents,
+			},
+		})
+	}
+
+	return ret, nil
+}
+
+// splitTraefikConfig serializes the configuration into one or more parts, each small enough to fit into a config
+// map. The gateway reads all the parts from the same directory and merges them, so the references between
+// the objects in different parts are resolved. Each object is only defined in a single part, because Traefik
+// refuses the objects defined in more than one file.
+func splitTraefikConfig(config traefikConfig) ([]string, error) {
+	contents, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(contents) <= maxGatewayConfigMapDataSize {
+		return []string{string(contents)}, nil
+	}
+
+	parts := []traefikConfig{}
+	current := newTraefikConfig()
+	currentSize := 0
+
+	// adds the object to the current part, starting a new part if the object wouldn't fit
+	add := func(put func(cfg *traefikConfig)) error {
+		// the size of the configuration with just the object is a slight overestimate of how much the object
+		// adds to the size of the part, because of the empty maps of the other objects
+		single := newTraefikConfig()
+		put(&single)
+		data, err := yaml.Marshal(single)
+		if err != nil {
+			return err
+		}
+
+		size := len(data)
+		if currentSize > 0 && currentSize+size > maxGatewayConfigMapDataSize {
+			parts = append(parts, current)
+			current = newTraefikConfig()
+			currentSize = 0
+		}
+
+		put(&current)
+		currentSize += size
+		return nil
+	}
+
+	for _, name := range sortedRouterNames(config.HTTP.Routers) {
+		name := name
+		if err := add(func(cfg *traefikConfig) { cfg.HTTP.Routers[name] = config.HTTP.Routers[name] }); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range sortedServiceNames(config.HTTP.Services) {
+		name := name
+		if err := add(func(cfg *traefikConfig) { cfg.HTTP.Services[name] = config.HTTP.Services[name] }); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range sortedKeys(config.HTTP.Middlewares) {
+		name := name
+		if err := add(func(cfg *traefikConfig) { cfg.HTTP.Middlewares[name] = config.HTTP.Middlewares[name] }); err != nil {
+			return nil, err
+		}
+	}
+
+	parts = append(parts, current)
+
+	ret := []string{}
+	for _, part := range parts {
+		contents, err := yaml.Marshal(part)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, string(contents))
+	}
+
+	return ret, nil
+}
+
+func newTraefikConfig() traefikConfig {
+	return traefikConfig{
+		HTTP: traefikConfigHTTP{
+			Routers:     map[string]traefikConfigRouter{},
+			Services:    map[string]traefikConfigService{},
+			Middlewares: map[string]traefikConfigMiddleware{},
 		},
-		Data: map[string]string{},
 	}
+}
 
-	rtrs := map[string]traefikConfigRouter{}
-	srvcs := map[string]traefikConfigService{}
-	mdls := map[string]traefikConfigMiddleware{}
+// getWorkspaceGatewayConfig returns the implementation-neutral configuration of the gateway for the workspace.
+// It returns a RoutingInvalid error if the endpoints of the workspace cannot be exposed as requested.
+func getWorkspaceGatewayConfig(cheManager *dwoche.CheManager, workspaceID string, routing *dwo.WorkspaceRouting) (workspaceGatewayConfig, error) {
+	ret := workspaceGatewayConfig{workspaceID: workspaceID}
+
+	routingProfiles := parseMiddlewareProfileNames(routing.Annotations[defaults.ConfigAnnotationMiddlewareProfiles])
 
 	for machineName, endpoints := range routing.Spec.Endpoints {
 		// we need to support unique endpoints - so 1 port can actually be accessible
 		// multiple times, each time using a different resulting external URL.
 		// non-unique endpoints are all represented using a single external URL
-		ports := map[int32]map[string]bool{}
+		// and therefore need to agree on how that URL is handled by the gateway.
+		ports := map[int32]map[string]*gatewayRoute{}
 		for _, e := range endpoints {
 			i := int32(e.TargetPort)
 
@@ -211,50 +302,164 @@ func (c *CheRoutingSolver) getGatewayConfigMaps(cheManager *dwoche.CheManager, w
 			}
 
 			if ports[i] == nil {
-				ports[i] = map[string]bool{}
+				ports[i] = map[string]*gatewayRoute{}
+			}
+
+			stripPrefix := isPrefixStripped(e)
+			errorPages := usesErrorPages(e)
+
+			route, ok := ports[i][name]
+			if !ok {
+				route = &gatewayRoute{stripPrefix: stripPrefix, errorPages: errorPages}
+				ports[i][name] = route
+			} else if route.stripPrefix != stripPrefix {
+				return workspaceGatewayConfig{}, &solvers.RoutingInvalid{Reason: fmt.Sprintf("the endpoints on port %d of '%s' are exposed on the same URL but disagree on the value of the '%s' attribute", i, machineName, stripPrefixAttributeName)}
+			} else if route.errorPages != errorPages {
+				return workspaceGatewayConfig{}, &solvers.RoutingInvalid{Reason: fmt.Sprintf("the endpoints on port %d of '%s' are exposed on the same URL but disagree on the value of the '%s' attribute", i, machineName, errorPagesAttributeName)}
+			}
+
+			if healthCheckPath := e.Attributes.GetString(healthCheckPathAttributeName, nil); healthCheckPath != "" {
+				if route.healthCheckPath != "" && route.healthCheckPath != healthCheckPath {
+					return workspaceGatewayConfig{}, &solvers.RoutingInvalid{Reason: fmt.Sprintf("the endpoints on port %d of '%s' are exposed on the same URL but disagree on the value of the '%s' attribute", i, machineName, healthCheckPathAttributeName)}
+				}
+				route.healthCheckPath = healthCheckPath
 			}
 
-			ports[i][name] = true
+			route.profiles = append(route.profiles, parseMiddlewareProfileNames(e.Attributes.GetString(middlewareProfilesAttributeName, nil))...)
 		}
 
 		for port, names := range ports {
-			for endpointName := range names {
-				var name string
-				var prefix string
-				var serviceURL string
-
-				if endpointName == "" {
-					name = fmt.Sprintf("%s-%s-%d", workspaceID, machineName, port)
-				} else {
-					name = fmt.Sprintf("%s-%s-%d-%s", workspaceID, machineName, port, endpointName)
-				}
-				prefix = getPublicURLPrefix(workspaceID, machineName, port, endpointName)
-				serviceURL = getServiceURL(port, workspaceID, routing.Namespace)
-
-				rtrs[name] = traefikConfigRouter{
-					Rule:        fmt.Sprintf("PathPrefix(`%s`)", prefix),
-					Service:     name,
-					Middlewares: []string{name},
-					Priority:    100,
+			for endpointName, route := range names {
+				name := getRouteName(workspaceID, machineName, port, endpointName)
+
+				profiles := mergeMiddlewareProfileNames(routingProfiles, route.profiles)
+				for _, profileName := range profiles {
+					if findMiddlewareProfile(cheManager, profileName) == nil {
+						return workspaceGatewayConfig{}, &solvers.RoutingInvalid{Reason: fmt.Sprintf("the middleware profile '%s' is not defined in the Che manager '%s' in namespace '%s'", profileName, cheManager.Name, cheManager.Namespace)}
+					}
 				}
 
-				srvcs[name] = traefikConfigService{
-					LoadBalancer: traefikConfigLoadbalancer{
-						Servers: []traefikConfigLoadbalancerServer{
-							{
-								URL: serviceURL,
-							},
-						},
-					},
-				}
+				ret.routes = append(ret.routes, workspaceGatewayRoute{
+					name:            name,
+					pathPrefix:      getPublicURLPrefix(workspaceID, machineName, port, endpointName),
+					stripPrefix:     route.stripPrefix,
+					errorPages:      route.errorPages,
+					backendURL:      getServiceURL(port, workspaceID, routing.Namespace),
+					healthCheckPath: route.healthCheckPath,
+					profiles:        profiles,
+				})
+			}
+		}
+	}
 
-				mdls[name] = traefikConfigMiddleware{
-					StripPrefix: traefikConfigStripPrefix{
-						Prefixes: []string{prefix},
-					},
+	// make the order stable so that the rendered configuration doesn't change needlessly
+	sort.Slice(ret.routes, func(i, j int) bool {
+		return ret.routes[i].name < ret.routes[j].name
+	})
+
+	if err := checkRouteCollisions(ret.routes); err != nil {
+		return workspaceGatewayConfig{}, &solvers.RoutingInvalid{Reason: fmt.Sprintf("the endpoints of the workspace collide: %s", err)}
+	}
+
+	return ret, nil
+}
+
+// renderTraefikConfig renders the configuration of the workspace into the dynamic configuration of Traefik.
+// The rendered configuration is validated so that no configuration Traefik would reject reaches the gateway.
+func renderTraefikConfig(cheManager *dwoche.CheManager, workspaceConfig workspaceGatewayConfig) (traefikConfig, error) {
+	rtrs := map[string]traefikConfigRouter{}
+	srvcs := map[string]traefikConfigService{}
+	mdls := map[string]traefikConfigMiddleware{}
+
+	// the name of the error pages middleware, initialized once some route of the workspace uses it
+	errorPages := ""
+
+	for _, route := range workspaceConfig.routes {
+		name := route.name
+		prefix := route.pathPrefix
+
+		if _, ok := rtrs[name]; ok {
+			return traefikConfig{}, &solvers.RoutingInvalid{Reason: fmt.Sprintf("the gateway configuration of the workspace is invalid: the router name '%s' is used more than once", name)}
+		}
+
+		middlewares := []string{}
+
+		// The error pages need to come first so that they can replace the error responses produced by
+		// any of the subsequent middlewares or the backend itself. Traefik cannot tell its own error responses
+		// from the ones produced by the backend, so the endpoints whose applications return meaningful 502,
+		// 503 or 504 responses can opt out of the error pages.
+		if route.errorPages {
+			if errorPages == "" {
+				var err error
+				if errorPages, err = addErrorPages(workspaceConfig.workspaceID, srvcs, mdls); err != nil {
+					return traefikConfig{}, err
 				}
+			}
+			middlewares = append(middlewares, errorPages)
+		}
 
+		// The strip prefix middleware only adds the prefix to the X-Forwarded-Prefix header, keeping
+		// any value sent by the client. We therefore always explicitly set the header to the correct
+		// value after stripping or remove it altogether if the application receives the full path.
+		prefixHeaderName := name + "-prefix-header"
+		forwardedPrefix := ""
+		if route.stripPrefix {
+			err := addTraefikMiddleware(mdls, name, traefikConfigMiddleware{
+				StripPrefix: &traefikConfigStripPrefix{
+					Prefixes: []string{prefix},
+				},
+			})
+			if err != nil {
+				return traefikConfig{}, err
 			}
+			middlewares = append(middlewares, name)
+			forwardedPrefix = prefix
+		}
+		err := addTraefikMiddleware(mdls, prefixHeaderName, traefikConfigMiddleware{
+			Headers: &traefikConfigHeaders{
+				CustomRequestHeaders: map[string]string{forwardedPrefixHeader: forwardedPrefix},
+			},
+		})
+		if err != nil {
+			return traefikConfig{}, err
+		}
+		middlewares = append(middlewares, prefixHeaderName)
+
+		for _, profileName := range route.profiles {
+			profileMiddleware, err := addMiddlewareProfile(cheManager, workspaceConfig.workspaceID, profileName, mdls)
+			if err != nil {
+				return traefikConfig{}, err
+			}
+			if profileMiddleware != "" {
+				middlewares = append(middlewares, profileMiddleware)
+			}
+		}
+
+		failureHandling, err := addBackendFailureHandling(cheManager, name, mdls)
+		if err != nil {
+			return traefikConfig{}, err
+		}
+		middlewares = append(middlewares, failureHandling...)
+
+		rtrs[name] = traefikConfigRouter{
+			Rule:        fmt.Sprintf("PathPrefix(`%s`)", prefix),
+			Service:     name,
+			Middlewares: middlewares,
+			Priority:    100,
+		}
+
+		err = addTraefikService(srvcs, name, traefikConfigService{
+			LoadBalancer: traefikConfigLoadbalancer{
+				Servers: []traefikConfigLoadbalancerServer{
+					{
+						URL: route.backendURL,
+					},
+				},
+				HealthCheck: getHealthCheck(cheManager, route.healthCheckPath),
+			},
+		})
+		if err != nil {
+			return traefikConfig{}, err
 		}
 	}
 
@@ -266,37 +471,18 @@ func (c *CheRoutingSolver) getGatewayConfigMaps(cheManager *dwoche.CheManager, w
 		},
 	}
 
-	contents, err := yaml.Marshal(config)
-	if err != nil {
-		return []corev1.ConfigMap{}, err
+	if err := validateTraefikConfig(workspaceConfig.workspaceID, &config); err != nil {
+		return traefikConfig{}, err
 	}
 
-	configMap.Data[workspaceID+".yml"] = string(contents)
-
-	return []corev1.ConfigMap{configMap}, nil
+	return config, nil
 }
 
 func (c *CheRoutingSolver) singlehostFinalize(cheManager *dwoche.CheManager, routing *dwo.WorkspaceRouting) error {
-	configs := &corev1.ConfigMapList{}
-
-	selector, err := labels.Parse(fmt.Sprintf("%s=%s", config.WorkspaceIDLabel, routing.Spec.WorkspaceId))
-	if err != nil {
-		return err
-	}
-
-	listOpts := &client.ListOptions{
-		Namespace:     cheManager.Namespace,
-		LabelSelector: selector,
-	}
-
-	err = c.client.List(context.TODO(), configs, listOpts)
-	if err != nil {
-		return err
-	}
-
-	for _, cm := range configs.Items {
-		err = c.client.Delete(context.TODO(), &cm)
-		if err != nil {
+	// the config provider of the gateway might have changed during the lifetime of the workspace and the che
+	// manager reconciler might not have cleaned up after the previous one yet
+	for _, o := range c.getAllGatewayConfigOutputs() {
+		if err := o.delete(cheManager, routing); err != nil {
 			return err
 		}
 	}
@@ -310,6 +496,18 @@ func getServiceURL(port int32, workspaceID string, workspaceNamespace string) st
 	return fmt.Sprintf("http://%s.%s.svc:%d", common.ServiceName(workspaceID), workspaceNamespace, port)
 }
 
+// isPrefixStripped returns true unless the endpoint explicitly requests the gateway to pass the full
+// public path to the application using the "stripPrefix" attribute.
+func isPrefixStripped(endpoint dw.Endpoint) bool {
+	return endpoint.Attributes.GetString(stripPrefixAttributeName, nil) != "false"
+}
+
+// usesErrorPages returns true if the gateway should serve its error pages instead of the error responses
+// of the endpoint backend. This is the default but the endpoints can opt out using the "errorPages" attribute.
+func usesErrorPages(endpoint dw.Endpoint) bool {
+	return endpoint.Attributes.GetString(errorPagesAttributeName, nil) != "false"
+}
+
 func getPublicURLPrefixForEndpoint(workspaceID string, machineName string, endpoint dw.Endpoint) string {
 	endpointName := ""
 	if endpoint.Attributes.GetString(uniqueEndpointAttributeName, nil) == "true" {
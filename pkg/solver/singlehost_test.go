@@ -2,13 +2,19 @@ package solver
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/gateway"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/manager"
 	dw "github.com/devfile/api/pkg/apis/workspaces/v1alpha2"
+	"github.com/devfile/api/pkg/attributes"
 	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
 	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
 	"github.com/devfile/devworkspace-operator/pkg/config"
@@ -16,10 +22,14 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
 	rbac "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -35,12 +45,18 @@ func createTestScheme() *runtime.Scheme {
 	utilruntime.Must(rbac.AddToScheme(scheme))
 	utilruntime.Must(dw.AddToScheme(scheme))
 	utilruntime.Must(dwo.AddToScheme(scheme))
+
+	// the fake client needs to know the list kinds of the Traefik objects we work with as unstructured
+	for _, gvk := range []schema.GroupVersionKind{gateway.IngressRouteGVK, gateway.MiddlewareGVK} {
+		scheme.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+		scheme.AddKnownTypeWithName(gvk.GroupVersion().WithKind(gvk.Kind+"List"), &unstructured.UnstructuredList{})
+	}
+
 	return scheme
 }
 
-func getSpecObjects(t *testing.T, routing *dwo.WorkspaceRouting) (client.Client, solvers.RoutingSolver, solvers.RoutingObjects) {
-	scheme := createTestScheme()
-	cheManager := &v1alpha1.CheManager{
+func simpleCheManager() *v1alpha1.CheManager {
+	return &v1alpha1.CheManager{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "che",
 			Namespace: "ns",
@@ -50,6 +66,23 @@ func getSpecObjects(t *testing.T, routing *dwo.WorkspaceRouting) (client.Client,
 			Routing: v1alpha1.SingleHost,
 		},
 	}
+}
+
+func getSpecObjects(t *testing.T, routing *dwo.WorkspaceRouting) (client.Client, solvers.RoutingSolver, solvers.RoutingObjects) {
+	return getSpecObjectsForManager(t, routing, simpleCheManager())
+}
+
+func getSpecObjectsForManager(t *testing.T, routing *dwo.WorkspaceRouting, cheManager *v1alpha1.CheManager) (client.Client, solvers.RoutingSolver, solvers.RoutingObjects) {
+	cl, solver, objs, err := tryGetSpecObjectsForManager(t, routing, cheManager)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cl, solver, objs
+}
+
+func tryGetSpecObjectsForManager(t *testing.T, routing *dwo.WorkspaceRouting, cheManager *v1alpha1.CheManager) (client.Client, solvers.RoutingSolver, solvers.RoutingObjects, error) {
+	scheme := createTestScheme()
 
 	cl := fake.NewFakeClientWithScheme(scheme, cheManager)
 
@@ -58,26 +91,44 @@ func getSpecObjects(t *testing.T, routing *dwo.WorkspaceRouting) (client.Client,
 		t.Fatal(err)
 	}
 
-	meta := solvers.WorkspaceMetadata{
-		WorkspaceId:   routing.Spec.WorkspaceId,
-		Namespace:     routing.GetNamespace(),
-		PodSelector:   routing.Spec.PodSelector,
-		RoutingSuffix: routing.Spec.RoutingSuffix,
-	}
+	meta := getWorkspaceMeta(routing)
 
-	// we need to do 1 round of che manager reconciliation so that the solver gets initialized
+	// we need to do 1 round of che manager reconciliation so that the che manager gets its status
 	cheRecon := manager.New(cl, scheme)
 	cheRecon.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "che", Namespace: "ns"}})
 
 	objs, err := solver.GetSpecObjects(routing, meta)
 	if err != nil {
-		t.Fatal(err)
+		return cl, solver, objs, err
 	}
 
 	// now we need a second round of che manager reconciliation so that it proclaims the che gateway as established
 	cheRecon.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "che", Namespace: "ns"}})
 
-	return cl, solver, objs
+	return cl, solver, objs, nil
+}
+
+func getWorkspaceMeta(routing *dwo.WorkspaceRouting) solvers.WorkspaceMetadata {
+	return solvers.WorkspaceMetadata{
+		WorkspaceId:   routing.Spec.WorkspaceId,
+		Namespace:     routing.GetNamespace(),
+		PodSelector:   routing.Spec.PodSelector,
+		RoutingSuffix: routing.Spec.RoutingSuffix,
+	}
+}
+
+func getWorkspaceTraefikConfig(t *testing.T, cl client.Client) traefikConfig {
+	cm := &corev1.ConfigMap{}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Name: "wsid", Namespace: "ns"}, cm); err != nil {
+		t.Fatalf("traefik configuration for the workspace not found: %s", err)
+	}
+
+	workspaceConfig := traefikConfig{}
+	if err := yaml.Unmarshal([]byte(cm.Data["wsid.yml"]), &workspaceConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	return workspaceConfig
 }
 
 func simpleWorkspaceRouting() *dwo.WorkspaceRouting {
@@ -159,8 +210,8 @@ func TestCreateObjects(t *testing.T) {
 		cms := &corev1.ConfigMapList{}
 		cl.List(context.TODO(), cms)
 
-		if len(cms.Items) != 2 {
-			t.Errorf("there should be 2 configmaps created for the gateway config of the workspace and che but there were: %d", len(cms.Items))
+		if len(cms.Items) != 4 {
+			t.Errorf("there should be 4 configmaps created for the gateway config of the workspace, che, gateway routes and the error pages but there were: %d", len(cms.Items))
 		}
 
 		var cheMgrCfg *corev1.ConfigMap
@@ -261,7 +312,8 @@ func TestFinalize(t *testing.T) {
 	cl, slv, _ := getSpecObjects(t, routing)
 
 	// the create test checks that during the above call, the solver created the 2 traefik configmaps
-	// (1 for the main config and the second for the workspace)
+	// (1 for the main config and the second for the workspace) next to the configmaps with the gateway routes
+	// and the error pages
 
 	// now, let the solver finalize the routing
 	if err := slv.Finalize(routing); err != nil {
@@ -271,12 +323,521 @@ func TestFinalize(t *testing.T) {
 	cms := &corev1.ConfigMapList{}
 	cl.List(context.TODO(), cms)
 
-	if len(cms.Items) != 1 {
-		t.Fatalf("There should be just 1 configmap after routing finalization, but there were %d found", len(cms.Items))
+	if len(cms.Items) != 3 {
+		t.Fatalf("There should be just 3 configmaps after routing finalization, but there were %d found", len(cms.Items))
+	}
+
+	for _, cm := range cms.Items {
+		if cm.Name != "che" && cm.Name != "che-routes" && cm.Name != "che-error-pages" {
+			t.Fatalf("The only configmaps left should be the main traefik config, the gateway routes and the error pages, but found configmap '%s'", cm.Name)
+		}
+	}
+}
+
+func TestMiddlewareProfiles(t *testing.T) {
+	maxBody := int64(1024)
+
+	cheManager := simpleCheManager()
+	cheManager.Spec.MiddlewareProfiles = []v1alpha1.MiddlewareProfile{
+		{
+			Name:                "upload",
+			MaxRequestBodyBytes: &maxBody,
+		},
+		{
+			Name: "preview",
+			CORS: &v1alpha1.CORSPolicy{
+				AllowOrigin: "*",
+			},
+			RequestHeaders: map[string]string{"X-Preview": "true"},
+		},
+	}
+
+	routing := simpleWorkspaceRouting()
+	routing.Annotations = map[string]string{defaults.ConfigAnnotationMiddlewareProfiles: "upload"}
+	routing.Spec.Endpoints["m1"] = append(routing.Spec.Endpoints["m1"], dw.Endpoint{
+		Name:       "e4",
+		TargetPort: 8888,
+		Exposure:   dw.PublicEndpointExposure,
+		Attributes: attributes.Attributes{}.PutString("middlewareProfiles", "preview, upload"),
+	})
+
+	cl, _, _ := getSpecObjectsForManager(t, routing, cheManager)
+
+	workspaceConfig := getWorkspaceTraefikConfig(t, cl)
+
+	expectedMiddlewares := map[string][]string{
+		"wsid-m1-9999": {"wsid-error-pages", "wsid-m1-9999", "wsid-m1-9999-prefix-header", "wsid-profile-upload"},
+		"wsid-m1-8888": {"wsid-error-pages", "wsid-m1-8888", "wsid-m1-8888-prefix-header", "wsid-profile-upload", "wsid-profile-preview"},
+	}
+
+	for routerName, expected := range expectedMiddlewares {
+		router, ok := workspaceConfig.HTTP.Routers[routerName]
+		if !ok {
+			t.Fatalf("Router '%s' not found in the traefik config", routerName)
+		}
+
+		if !reflect.DeepEqual(expected, router.Middlewares) {
+			t.Errorf("Router '%s' should have middlewares %v but had %v", routerName, expected, router.Middlewares)
+		}
+	}
+
+	upload := workspaceConfig.HTTP.Middlewares["wsid-profile-upload"]
+	if upload.Chain == nil || !reflect.DeepEqual(upload.Chain.Middlewares, []string{"wsid-profile-upload-buffering"}) {
+		t.Errorf("Unexpected definition of the upload profile middleware: %v", upload)
+	}
+
+	buffering := workspaceConfig.HTTP.Middlewares["wsid-profile-upload-buffering"]
+	if buffering.Buffering == nil || buffering.Buffering.MaxRequestBodyBytes != maxBody {
+		t.Errorf("Unexpected definition of the buffering middleware: %v", buffering)
+	}
+
+	headers := workspaceConfig.HTTP.Middlewares["wsid-profile-preview-headers"]
+	if headers.Headers == nil || headers.Headers.AccessControlAllowOrigin != "*" || headers.Headers.CustomRequestHeaders["X-Preview"] != "true" {
+		t.Errorf("Unexpected definition of the headers middleware: %v", headers)
+	}
+}
+
+func TestMiddlewareProfileNamesAreSanitized(t *testing.T) {
+	if name := getMiddlewareProfileName("wsid", "upload"); name != "wsid-profile-upload" {
+		t.Errorf("The simple profile names should be used verbatim but got '%s'", name)
+	}
+
+	valid := regexp.MustCompile(`^[a-z0-9-]+$`)
+	seen := map[string]string{}
+	for _, profile := range []string{"upload", "Upload", "upload files", "upload.files", "upload/files", "../x"} {
+		name := getMiddlewareProfileName("wsid", profile)
+		if !valid.MatchString(name) {
+			t.Errorf("The middleware name '%s' of the profile '%s' contains invalid characters", name, profile)
+		}
+		if other, ok := seen[name]; ok {
+			t.Errorf("The profiles '%s' and '%s' have the same middleware name '%s'", other, profile, name)
+		}
+		seen[name] = profile
+	}
+}
+
+func TestUnknownMiddlewareProfileIsInvalid(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.Annotations = map[string]string{defaults.ConfigAnnotationMiddlewareProfiles: "nonexistent"}
+
+	_, _, _, err := tryGetSpecObjectsForManager(t, routing, simpleCheManager())
+
+	var invalid *solvers.RoutingInvalid
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Referencing an unknown middleware profile should have produced RoutingInvalid error but got: %v", err)
+	}
+}
+
+func TestRoutingMarkedAsFailedIsInvalid(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.Annotations = map[string]string{defaults.ConfigAnnotationRoutingFailureReason: "the che manager ns/che has been deleted"}
+
+	_, _, _, err := tryGetSpecObjectsForManager(t, routing, simpleCheManager())
+
+	var invalid *solvers.RoutingInvalid
+	if !errors.As(err, &invalid) || invalid.Reason != "the che manager ns/che has been deleted" {
+		t.Fatalf("The routing marked as failed should have produced RoutingInvalid error with the failure reason but got: %v", err)
+	}
+}
+
+func TestStripPrefixSetsForwardedPrefix(t *testing.T) {
+	cl, _, _ := getSpecObjects(t, simpleWorkspaceRouting())
+
+	workspaceConfig := getWorkspaceTraefikConfig(t, cl)
+
+	router := workspaceConfig.HTTP.Routers["wsid-m1-9999"]
+	if !reflect.DeepEqual(router.Middlewares, []string{"wsid-error-pages", "wsid-m1-9999", "wsid-m1-9999-prefix-header"}) {
+		t.Fatalf("Unexpected middlewares of the router: %v", router.Middlewares)
+	}
+
+	strip := workspaceConfig.HTTP.Middlewares["wsid-m1-9999"]
+	if strip.StripPrefix == nil || !reflect.DeepEqual(strip.StripPrefix.Prefixes, []string{"/wsid/m1/9999"}) {
+		t.Errorf("Unexpected strip prefix middleware: %v", strip)
+	}
+
+	header := workspaceConfig.HTTP.Middlewares["wsid-m1-9999-prefix-header"]
+	if header.Headers == nil || header.Headers.CustomRequestHeaders["X-Forwarded-Prefix"] != "/wsid/m1/9999" {
+		t.Errorf("Unexpected forwarded prefix header middleware: %v", header)
+	}
+}
+
+func TestStripPrefixDisabled(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.Spec.Endpoints["m1"] = dwo.EndpointList{
+		{
+			Name:       "jupyter",
+			TargetPort: 8888,
+			Exposure:   dw.PublicEndpointExposure,
+			Path:       "/lab",
+			Attributes: attributes.Attributes{}.PutString("stripPrefix", "false"),
+		},
+	}
+
+	cl, solver, objs := getSpecObjects(t, routing)
+
+	workspaceConfig := getWorkspaceTraefikConfig(t, cl)
+
+	router := workspaceConfig.HTTP.Routers["wsid-m1-8888"]
+	if !reflect.DeepEqual(router.Middlewares, []string{"wsid-error-pages", "wsid-m1-8888-prefix-header"}) {
+		t.Fatalf("Unexpected middlewares of the router: %v", router.Middlewares)
+	}
+
+	if _, ok := workspaceConfig.HTTP.Middlewares["wsid-m1-8888"]; ok {
+		t.Error("There should be no strip prefix middleware when stripping is disabled")
+	}
+
+	header := workspaceConfig.HTTP.Middlewares["wsid-m1-8888-prefix-header"]
+	if value, ok := header.Headers.CustomRequestHeaders["X-Forwarded-Prefix"]; !ok || value != "" {
+		t.Errorf("The X-Forwarded-Prefix header should be removed from the requests but the middleware is: %v", header)
+	}
+
+	exposed, _, err := solver.GetExposedEndpoints(routing.Spec.Endpoints, objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exposed["m1"][0].Url != "http://over.the.rainbow/wsid/m1/8888/lab" {
+		t.Errorf("Unexpected URL of the endpoint: %s", exposed["m1"][0].Url)
+	}
+}
+
+func TestConflictingStripPrefixIsInvalid(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.Spec.Endpoints["m1"][0].Attributes = attributes.Attributes{}.PutString("stripPrefix", "false")
+
+	_, _, _, err := tryGetSpecObjectsForManager(t, routing, simpleCheManager())
+
+	var invalid *solvers.RoutingInvalid
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Endpoints on the same URL disagreeing on prefix stripping should have produced RoutingInvalid error but got: %v", err)
+	}
+}
+
+func listWorkspaceConfigMaps(t *testing.T, cl client.Client) []corev1.ConfigMap {
+	cms := &corev1.ConfigMapList{}
+	if err := cl.List(context.TODO(), cms, client.InNamespace("ns"), client.MatchingLabels{config.WorkspaceIDLabel: "wsid"}); err != nil {
+		t.Fatal(err)
+	}
+	return cms.Items
+}
+
+func TestLargeConfigSplitAcrossConfigMaps(t *testing.T) {
+	defer func(orig int) { maxGatewayConfigMapDataSize = orig }(maxGatewayConfigMapDataSize)
+	maxGatewayConfigMapDataSize = 2048
+
+	routing := simpleWorkspaceRouting()
+	for i := 0; i < 20; i++ {
+		routing.Spec.Endpoints["m1"] = append(routing.Spec.Endpoints["m1"], dw.Endpoint{
+			Name:       fmt.Sprintf("e%d", 100+i),
+			TargetPort: 10000 + i,
+			Exposure:   dw.PublicEndpointExposure,
+		})
+	}
+
+	cl, slv, _ := getSpecObjects(t, routing)
+
+	cms := listWorkspaceConfigMaps(t, cl)
+	if len(cms) < 2 {
+		t.Fatalf("The configuration should have been split into several config maps but there are %d", len(cms))
+	}
+
+	merged := newTraefikConfig()
+	for _, cm := range cms {
+		if applicable, key := isGatewayWorkspaceConfig(&cm); !applicable || key.Name != "routing" {
+			t.Errorf("The config map '%s' should have been recognized as the configuration of the routing", cm.Name)
+		}
+
+		if len(cm.Data) != 1 || cm.Data[cm.Name+".yml"] == "" {
+			t.Errorf("Unexpected data of the config map '%s': %v", cm.Name, cm.Data)
+		}
+
+		part := traefikConfig{}
+		if err := yaml.Unmarshal([]byte(cm.Data[cm.Name+".yml"]), &part); err != nil {
+			t.Fatal(err)
+		}
+
+		for name, r := range part.HTTP.Routers {
+			if _, ok := merged.HTTP.Routers[name]; ok {
+				t.Errorf("The router '%s' is defined in more than one config map", name)
+			}
+			merged.HTTP.Routers[name] = r
+		}
+		for name, s := range part.HTTP.Services {
+			merged.HTTP.Services[name] = s
+		}
+		for name, m := range part.HTTP.Middlewares {
+			merged.HTTP.Middlewares[name] = m
+		}
+	}
+
+	if err := validateTraefikConfig("wsid", &merged); err != nil {
+		t.Errorf("The merged configuration should be complete: %s", err)
+	}
+	if len(merged.HTTP.Routers) != 21 {
+		t.Errorf("Expected 21 routers in the merged configuration but got %d", len(merged.HTTP.Routers))
+	}
+
+	// when the configuration fits again, the additional parts need to be removed
+	maxGatewayConfigMapDataSize = 1024 * 1024
+	if _, err := slv.GetSpecObjects(routing, getWorkspaceMeta(routing)); err != nil {
+		t.Fatal(err)
+	}
+
+	cms = listWorkspaceConfigMaps(t, cl)
+	if len(cms) != 1 || cms[0].Name != "wsid" {
+		t.Errorf("There should only be the main config map of the workspace but found %d config maps", len(cms))
+	}
+
+	maxGatewayConfigMapDataSize = 2048
+	if _, err := slv.GetSpecObjects(routing, getWorkspaceMeta(routing)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := slv.Finalize(routing); err != nil {
+		t.Fatal(err)
+	}
+
+	if cms = listWorkspaceConfigMaps(t, cl); len(cms) != 0 {
+		t.Errorf("All the parts of the configuration should have been removed after finalization but found %d", len(cms))
+	}
+}
+
+func TestWorkspaceConfigMapNames(t *testing.T) {
+	for name, expected := range map[string]bool{
+		"wsid":    true,
+		"wsid.1":  true,
+		"wsid.12": true,
+		"wsid.0":  false,
+		"wsid.01": false,
+		"wsid.x":  false,
+		"wsid-1":  false,
+		"other":   false,
+	} {
+		if isGatewayWorkspaceConfigMapName("wsid", name) != expected {
+			t.Errorf("Unexpected result for the config map name '%s', expected %t", name, expected)
+		}
+	}
+}
+
+func TestRouteNames(t *testing.T) {
+	for _, c := range []struct {
+		machine  string
+		port     int32
+		endpoint string
+		expected string
+	}{
+		{"m1", 9999, "", "wsid-m1-9999"},
+		{"theia-ide", 3100, "", "wsid-theia-ide-3100"},
+		{"m", 1, "a-b", "wsid-m-1-a-b"},
+	} {
+		if name := getRouteName("wsid", c.machine, c.port, c.endpoint); name != c.expected {
+			t.Errorf("The names valid in Kubernetes should stay readable, expected '%s' but got '%s'", c.expected, name)
+		}
+	}
+
+	for _, c := range []struct {
+		desc     string
+		machine  string
+		endpoint string
+	}{
+		{"uppercase machine", "M", "a"},
+		{"leading dash", "-m", "a"},
+		{"consecutive dashes", "m--a", ""},
+		{"retry endpoint", "m", "retry"},
+		{"profile machine", "profile", ""},
+	} {
+		if name := getRouteName("wsid", c.machine, 1, c.endpoint); !strings.Contains(name, "--") {
+			t.Errorf("The name of the route with %s should be hashed but got '%s'", c.desc, name)
+		}
+	}
+
+	if getRouteName("wsid", "M", 1, "a") == getRouteName("wsid", "m", 1, "a") {
+		t.Error("The sanitized names should not clash with the plain names")
+	}
+}
+
+func TestRouteNamesWithDashesCanCollide(t *testing.T) {
+	routes := []workspaceGatewayRoute{
+		{name: getRouteName("wsid", "m-a", 1, "b"), pathPrefix: "/wsid/m-a/1/b"},
+		{name: getRouteName("wsid", "m", 1, "a-b"), pathPrefix: "/wsid/m/1/a-b"},
+		{name: getRouteName("wsid", "m-a-1", 1, ""), pathPrefix: "/wsid/m-a-1/1"},
+		{name: getRouteName("wsid", "m-a", 1, "1"), pathPrefix: "/wsid/m-a/1/1"},
+	}
+
+	if err := checkRouteCollisions(routes[:3]); err != nil {
+		t.Errorf("The distinct route names should not collide but got: %s", err)
+	}
+
+	if err := checkRouteCollisions(routes[2:]); err == nil {
+		t.Error("The routes with the same name should be reported")
+	}
+}
+
+func TestCollidingEndpointsAreInvalid(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	// the unique endpoint would be exposed on the same path as the other endpoints on the port 9999
+	routing.Spec.Endpoints["m1"] = append(routing.Spec.Endpoints["m1"], dw.Endpoint{
+		Name:       "9999",
+		TargetPort: 8888,
+		Exposure:   dw.PublicEndpointExposure,
+		Attributes: attributes.Attributes{}.PutString("unique", "true"),
+	})
+
+	_, _, _, err := tryGetSpecObjectsForManager(t, routing, simpleCheManager())
+
+	var invalid *solvers.RoutingInvalid
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Endpoints exposed on the same path should have produced RoutingInvalid error but got: %v", err)
+	}
+}
+
+func TestWorkspaceBackendsHandling(t *testing.T) {
+	cheManager := simpleCheManager()
+	cheManager.Spec.WorkspaceBackends = &v1alpha1.WorkspaceBackendsConfig{
+		HealthCheckInterval:      "5s",
+		RetryAttempts:            3,
+		CircuitBreakerExpression: "NetworkErrorRatio() > 0.5",
+	}
+
+	routing := simpleWorkspaceRouting()
+	routing.Spec.Endpoints["m1"][0].Attributes = attributes.Attributes{}.PutString("healthCheckPath", "/healthz")
+
+	cl, _, _ := getSpecObjectsForManager(t, routing, cheManager)
+
+	workspaceConfig := getWorkspaceTraefikConfig(t, cl)
+
+	router := workspaceConfig.HTTP.Routers["wsid-m1-9999"]
+	expectedMiddlewares := []string{"wsid-error-pages", "wsid-m1-9999", "wsid-m1-9999-prefix-header", "wsid-m1-9999-retry", "wsid-m1-9999-circuit-breaker"}
+	if !reflect.DeepEqual(router.Middlewares, expectedMiddlewares) {
+		t.Errorf("Unexpected middlewares of the router: %v", router.Middlewares)
+	}
+
+	healthCheck := workspaceConfig.HTTP.Services["wsid-m1-9999"].LoadBalancer.HealthCheck
+	if healthCheck == nil {
+		t.Fatal("The workspace service should have a health check")
+	}
+	if healthCheck.Path != "/healthz" || healthCheck.Interval != "5s" || healthCheck.Timeout != "3s" {
+		t.Errorf("Unexpected health check configuration: %v", healthCheck)
+	}
+
+	if retry := workspaceConfig.HTTP.Middlewares["wsid-m1-9999-retry"].Retry; retry == nil || retry.Attempts != 3 {
+		t.Errorf("Unexpected retry configuration: %v", retry)
+	}
+
+	if cb := workspaceConfig.HTTP.Middlewares["wsid-m1-9999-circuit-breaker"].CircuitBreaker; cb == nil || cb.Expression != "NetworkErrorRatio() > 0.5" {
+		t.Errorf("Unexpected circuit breaker configuration: %v", cb)
+	}
+
+}
+
+func TestErrorPagesByDefault(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.Spec.Endpoints["m1"] = append(routing.Spec.Endpoints["m1"], dw.Endpoint{
+		Name:       "e4",
+		TargetPort: 7777,
+		Exposure:   dw.PublicEndpointExposure,
+		Attributes: attributes.Attributes{}.PutString("errorPages", "false"),
+	})
+
+	cl, _, _ := getSpecObjects(t, routing)
+
+	workspaceConfig := getWorkspaceTraefikConfig(t, cl)
+
+	if middlewares := workspaceConfig.HTTP.Routers["wsid-m1-9999"].Middlewares; len(middlewares) == 0 || middlewares[0] != "wsid-error-pages" {
+		t.Errorf("The error pages should be the first middleware of the endpoint but got: %v", middlewares)
+	}
+
+	for _, m := range workspaceConfig.HTTP.Routers["wsid-m1-7777"].Middlewares {
+		if m == "wsid-error-pages" {
+			t.Error("The error pages should not replace the error responses of the endpoints that opted out")
+		}
+	}
+
+	errorPages := workspaceConfig.HTTP.Middlewares["wsid-error-pages"].Errors
+	if errorPages == nil || errorPages.Service != "wsid-error-pages" || errorPages.Query != "/{status}.html" {
+		t.Errorf("Unexpected error pages configuration: %v", errorPages)
+	}
+
+	if _, ok := workspaceConfig.HTTP.Services["wsid-error-pages"]; !ok {
+		t.Error("The error pages service should be defined")
+	}
+}
+
+func TestNoErrorPagesWhenAllEndpointsOptOut(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	for i := range routing.Spec.Endpoints["m1"] {
+		routing.Spec.Endpoints["m1"][i].Attributes = attributes.Attributes{}.PutString("errorPages", "false")
+	}
+
+	cl, _, _ := getSpecObjects(t, routing)
+
+	workspaceConfig := getWorkspaceTraefikConfig(t, cl)
+
+	if _, ok := workspaceConfig.HTTP.Middlewares["wsid-error-pages"]; ok {
+		t.Error("There should be no error pages middleware if all endpoints opt out of it")
+	}
+	if _, ok := workspaceConfig.HTTP.Services["wsid-error-pages"]; ok {
+		t.Error("There should be no error pages service if all endpoints opt out of it")
 	}
+}
+
+func TestConflictingErrorPagesIsInvalid(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.Spec.Endpoints["m1"][0].Attributes = attributes.Attributes{}.PutString("errorPages", "false")
+
+	_, _, _, err := tryGetSpecObjectsForManager(t, routing, simpleCheManager())
+
+	var invalid *solvers.RoutingInvalid
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Endpoints on the same URL disagreeing on the error pages should have produced RoutingInvalid error but got: %v", err)
+	}
+}
+
+func TestNoHealthCheckByDefault(t *testing.T) {
+	cl, _, _ := getSpecObjects(t, simpleWorkspaceRouting())
+
+	workspaceConfig := getWorkspaceTraefikConfig(t, cl)
+
+	if workspaceConfig.HTTP.Services["wsid-m1-9999"].LoadBalancer.HealthCheck != nil {
+		t.Error("There should be no health check if no endpoint specifies the health check path")
+	}
+}
+
+func TestDryRunOnlyReportsWorkspaceConfig(t *testing.T) {
+	scheme := createTestScheme()
+	routing := simpleWorkspaceRouting()
 
-	cm := cms.Items[0]
-	if cm.Name != "che" {
-		t.Fatal("The only configmap left should be the main traefik config, but the configmap has unexpected name")
+	cl := fake.NewFakeClientWithScheme(scheme, simpleCheManager())
+
+	recorder := record.NewFakeRecorder(10)
+	getter := Getter(scheme)
+	getter.DryRun = true
+	getter.recorder = recorder
+
+	solver, err := getter.GetSolver(cl, "che")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cheRecon := manager.New(cl, scheme)
+	if _, err = cheRecon.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "che", Namespace: "ns"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = solver.GetSpecObjects(routing, getWorkspaceMeta(routing)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "wsid", Namespace: "ns"}, &corev1.ConfigMap{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("The workspace configuration should not have been stored in the dry-run mode but got: %v", err)
+	}
+
+	select {
+	case e := <-recorder.Events:
+		if !strings.HasPrefix(e, "Normal DryRunGatewayConfigured Would update the gateway configuration of the workspace in the namespace ns: Would create ConfigMap ns/wsid") {
+			t.Errorf("Unexpected event: %s", e)
+		}
+	default:
+		t.Error("The would-be changes of the workspace configuration should have been reported in an event")
 	}
 }
@@ -13,12 +13,17 @@
 package solver
 
 import (
+	"context"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/defaults"
 	"github.com/che-incubator/devworkspace-che-operator/pkg/manager"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/metrics"
 	controllerv1alpha1 "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
 	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
 	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
@@ -27,10 +32,12 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	crmanager "sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
@@ -41,8 +48,15 @@ var (
 
 // CheRoutingSolver is a struct representing the routing solver for Che specific routing of workspaces
 type CheRoutingSolver struct {
-	client client.Client
-	scheme *runtime.Scheme
+	client   client.Client
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
+
+	// used for the server-side apply of the gateway configuration, see sync.New
+	fieldManager string
+	dryRun       bool
+
+	readyObservations *routingReadyObservations
 }
 
 // Magic to ensure we get compile time error right here if our struct doesn't support the interface.
@@ -51,16 +65,62 @@ var _ solvers.RoutingSolver = (*CheRoutingSolver)(nil)
 
 // CheRouterGetter negotiates the solver with the calling code
 type CheRouterGetter struct {
+	// FieldManager is the field manager the gateway configuration of the workspaces is synced with using
+	// the server-side apply. The server-side apply is not used if it is empty. It needs to be set before calling
+	// SetupWithManager.
+	FieldManager string
+	// DryRun makes the solvers only log and report the changes they would make to the gateway configuration of
+	// the workspaces, see sync.Syncer.DryRun.
+	DryRun bool
+
 	scheme *runtime.Scheme
+
+	// the client reading from the cache of the operator manager and the event recorder, set up in SetupWithManager
+	client   client.Client
+	recorder record.EventRecorder
+
+	// shared by all the solvers, which are created for each reconciliation of a routing
+	readyObservations *routingReadyObservations
 }
 
 // Getter creates a new CheRouterGetter
 func Getter(scheme *runtime.Scheme) *CheRouterGetter {
 	return &CheRouterGetter{
-		scheme: scheme,
+		scheme:            scheme,
+		readyObservations: &routingReadyObservations{generations: map[types.UID]int64{}},
+	}
+}
+
+// ConfigServer returns the server that serves the dynamic configuration of the gateways that are configured
+// to obtain it over HTTP. The returned server needs to be added to the operator manager so that it runs along
+// with the controllers.
+func (g *CheRouterGetter) ConfigServer(mgr ctrl.Manager, addr string) crmanager.Runnable {
+	return &configServer{
+		addr:   addr,
+		client: mgr.GetClient(),
+		cache:  mgr.GetCache(),
+	}
+}
+
+// ConfigMapSweeper returns the runnable that periodically deletes the gateway configuration of the workspaces whose
+// workspace routing no longer exists. The returned runnable needs to be added to the operator manager.
+func (g *CheRouterGetter) ConfigMapSweeper(mgr ctrl.Manager, interval time.Duration) crmanager.Runnable {
+	return &configMapSweeper{
+		client:    mgr.GetClient(),
+		apiReader: mgr.GetAPIReader(),
+		recorder:  mgr.GetEventRecorderFor("gateway-config-sweeper"),
+		interval:  interval,
 	}
 }
 
+// SetupWithManager gives the solver access to the client and the event recorder of the operator manager. It needs to be called before
+// the operator manager is started. The solver relies on the indices set up by manager.SetupIndices.
+func (g *CheRouterGetter) SetupWithManager(mgr ctrl.Manager) error {
+	g.client = mgr.GetClient()
+	g.recorder = mgr.GetEventRecorderFor("che-routing-solver")
+	return nil
+}
+
 func (g *CheRouterGetter) HasSolver(routingClass controllerv1alpha1.WorkspaceRoutingClass) bool {
 	return isSupported(routingClass)
 }
@@ -69,15 +129,24 @@ func (g *CheRouterGetter) GetSolver(client client.Client, routingClass controlle
 	if !isSupported(routingClass) {
 		return nil, solvers.RoutingNotSupported
 	}
-	return &CheRoutingSolver{client: client, scheme: g.scheme}, nil
+	return &CheRoutingSolver{
+		client:            client,
+		scheme:            g.scheme,
+		recorder:          g.recorder,
+		fieldManager:      g.FieldManager,
+		dryRun:            g.DryRun,
+		readyObservations: g.readyObservations,
+	}, nil
 }
 
 func (g *CheRouterGetter) SetupControllerManager(mgr *builder.Builder) error {
 
 	// We want to watch configmaps and re-map the reconcile on the workspace routing, if possible
 	// This way we can react on changes of the gateway configmap changes by re-reconciling the corresponding
-	// workspace routing and thus keeping the workspace routing in a functional state
-	// TODO is this going to be performant enough in a big cluster with very many configmaps?
+	// workspace routing and thus keeping the workspace routing in a functional state.
+	// The cache of the operator manager only contains the config maps selected by
+	// defaults.GetCachedConfigMapsSelector() (see the filteredcache package), so we don't get the events about
+	// all the config maps in the cluster here.
 	mgr.Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(func(mo handler.MapObject) []reconcile.Request {
 		applicable, key := isGatewayWorkspaceConfig(mo.Meta)
 
@@ -93,15 +162,41 @@ func (g *CheRouterGetter) SetupControllerManager(mgr *builder.Builder) error {
 		}
 	})})
 
+	// The workspace routings need to be re-reconciled when their che manager changes, e.g. its host or routing mode,
+	// so that their exposed endpoints and gateway configuration follow the che manager.
+	mgr.Watches(&source.Kind{Type: &v1alpha1.CheManager{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(g.routingsOfCheManager)})
+
 	return nil
 }
 
+// routingsOfCheManager maps the che manager to the reconcile requests of all the workspace routings it handles.
+func (g *CheRouterGetter) routingsOfCheManager(mo handler.MapObject) []reconcile.Request {
+	cheManager, ok := mo.Object.(*v1alpha1.CheManager)
+	if !ok || g.client == nil {
+		return []reconcile.Request{}
+	}
+
+	routings, err := manager.ListRoutingsOfCheManager(context.TODO(), g.client, cheManager)
+	if err != nil {
+		logger.Error(err, "Failed to list the workspace routings of the che manager", "namespace", cheManager.Namespace, "name", cheManager.Name)
+		return []reconcile.Request{}
+	}
+
+	ret := make([]reconcile.Request, 0, len(routings))
+	for _, r := range routings {
+		ret = append(ret, reconcile.Request{NamespacedName: types.NamespacedName{Name: r.Name, Namespace: r.Namespace}})
+	}
+
+	return ret
+}
+
 func isGatewayWorkspaceConfig(obj metav1.Object) (bool, types.NamespacedName) {
 	workspaceID := obj.GetLabels()[config.WorkspaceIDLabel]
 	objectName := obj.GetName()
 
-	// bail out quickly if we're not dealing with a configmap with an expected name
-	if objectName != defaults.GetGatewayWorkpaceConfigMapName(workspaceID) {
+	// bail out quickly if we're not dealing with a configmap with an expected name. Large configurations are split
+	// into several config maps, any of them belongs to the routing.
+	if workspaceID == "" || !isGatewayWorkspaceConfigMapName(workspaceID, objectName) {
 		return false, types.NamespacedName{}
 	}
 
@@ -117,13 +212,36 @@ func isGatewayWorkspaceConfig(obj metav1.Object) (bool, types.NamespacedName) {
 	return true, types.NamespacedName{Name: routingName, Namespace: routingNamespace}
 }
 
+// isGatewayWorkspaceConfigMapName checks whether the name is the name of one of the config maps with the parts of
+// the gateway configuration of the workspace.
+func isGatewayWorkspaceConfigMapName(workspaceID string, name string) bool {
+	if name == defaults.GetGatewayWorkpaceConfigMapName(workspaceID) {
+		return true
+	}
+
+	suffix := strings.TrimPrefix(name, defaults.GetGatewayWorkpaceConfigMapName(workspaceID)+".")
+	if suffix == name {
+		return false
+	}
+
+	part, err := strconv.Atoi(suffix)
+	return err == nil && part > 0 && name == defaults.GetGatewayWorkspaceConfigMapPartName(workspaceID, part)
+}
+
 func (c *CheRoutingSolver) FinalizerRequired(routing *controllerv1alpha1.WorkspaceRouting) bool {
 	return true
 }
 
 func (c *CheRoutingSolver) Finalize(routing *controllerv1alpha1.WorkspaceRouting) error {
-	cheManager, err := cheManagerOfRouting(routing)
+	c.readyObservations.forget(routing.UID)
+
+	cheManager, err := findCheManager(context.TODO(), c.client, manager.GetCheManagerKeyOfRouting(routing))
 	if err != nil {
+		if _, ok := err.(*solvers.RoutingNotReady); ok {
+			// the che manager doesn't exist (anymore) and it cleaned up the configuration of its workspaces
+			// when it was deleted, so there's nothing left for us to do
+			return nil
+		}
 		return err
 	}
 
@@ -136,16 +254,101 @@ func (c *CheRoutingSolver) Finalize(routing *controllerv1alpha1.WorkspaceRouting
 
 // GetSpecObjects constructs cluster routing objects which should be applied on the cluster
 func (c *CheRoutingSolver) GetSpecObjects(routing *controllerv1alpha1.WorkspaceRouting, workspaceMeta solvers.WorkspaceMetadata) (solvers.RoutingObjects, error) {
-	cheManager, err := cheManagerOfRouting(routing)
+	objs, err := c.getSpecObjects(routing, workspaceMeta)
+	if err != nil {
+		if _, ok := err.(*solvers.RoutingNotReady); ok {
+			metrics.RoutingNotReadyRetries.Inc()
+		}
+		c.recordError(routing, err)
+	}
+	return objs, err
+}
+
+// recordError records the event about the routing not being solved if the error is caused by something the user
+// can fix.
+func (c *CheRoutingSolver) recordError(routing *controllerv1alpha1.WorkspaceRouting, err error) {
+	if c.recorder == nil {
+		return
+	}
+
+	switch e := err.(type) {
+	case *solvers.RoutingInvalid:
+		c.recorder.Eventf(routing, corev1.EventTypeWarning, "RoutingInvalid", "The workspace routing is invalid: %s", e.Reason)
+	case *solvers.RoutingNotReady:
+		// the only reason for the routing not being ready is the missing che manager
+		key := manager.GetCheManagerKeyOfRouting(routing)
+		if key.Name == "" {
+			c.recorder.Event(routing, corev1.EventTypeWarning, "CheManagerNotFound", "There is no che manager in the cluster")
+		} else {
+			c.recorder.Eventf(routing, corev1.EventTypeWarning, "CheManagerNotFound", "The che manager %s does not exist", key)
+		}
+	}
+}
+
+func (c *CheRoutingSolver) getSpecObjects(routing *controllerv1alpha1.WorkspaceRouting, workspaceMeta solvers.WorkspaceMetadata) (solvers.RoutingObjects, error) {
+	// the routing was marked as failed by the che manager reconciler, e.g. when its che manager was deleted
+	if reason := routing.Annotations[defaults.ConfigAnnotationRoutingFailureReason]; reason != "" {
+		return solvers.RoutingObjects{}, &solvers.RoutingInvalid{Reason: reason}
+	}
+
+	cheManager, err := findCheManager(context.TODO(), c.client, manager.GetCheManagerKeyOfRouting(routing))
 	if err != nil {
 		return solvers.RoutingObjects{}, err
 	}
 
+	if cheManager.DeletionTimestamp != nil {
+		return solvers.RoutingObjects{}, &solvers.RoutingInvalid{Reason: fmt.Sprintf("the che manager %s/%s is being deleted", cheManager.Namespace, cheManager.Name)}
+	}
+
+	var objs solvers.RoutingObjects
 	if cheManager.Spec.Routing == v1alpha1.SingleHost {
-		return c.singlehostSpecObjects(cheManager, routing, workspaceMeta)
+		objs, err = c.singlehostSpecObjects(cheManager, routing, workspaceMeta)
+	} else {
+		objs, err = c.multihostSpecObjects(cheManager, routing, workspaceMeta)
+	}
+	if err != nil {
+		return solvers.RoutingObjects{}, err
+	}
+
+	annotateServices(cheManager, objs.Services)
+
+	return objs, nil
+}
+
+// annotateServices adds the annotations identifying the che manager to the services, so that the che manager can
+// be found when resolving the exposed endpoints.
+func annotateServices(cheManager *v1alpha1.CheManager, services []corev1.Service) {
+	additionalLabels := defaults.GetLabelsForComponent(cheManager, "exposure")
+
+	annos := map[string]string{
+		defaults.ConfigAnnotationCheManagerName:      cheManager.Name,
+		defaults.ConfigAnnotationCheManagerNamespace: cheManager.Namespace,
 	}
 
-	return c.multihostSpecObjects(cheManager, routing, workspaceMeta)
+	for i := range services {
+		// need to use a ref otherwise s would be a copy
+		s := &services[i]
+
+		if s.Labels == nil {
+			s.Labels = map[string]string{}
+		}
+
+		for k, v := range additionalLabels {
+			if len(s.Labels[k]) == 0 {
+				s.Labels[k] = v
+			}
+		}
+
+		if s.Annotations == nil {
+			s.Annotations = map[string]string{}
+		}
+
+		for k, v := range annos {
+			if len(s.Annotations[k]) == 0 {
+				s.Annotations[k] = v
+			}
+		}
+	}
 }
 
 // GetExposedEndpoints retreives the URL for each endpoint in a devfile spec from a set of RoutingObjects.
@@ -161,51 +364,77 @@ func (c *CheRoutingSolver) GetExposedEndpoints(endpoints map[string]controllerv1
 	managerNamespace := routingObj.Services[0].Annotations[defaults.ConfigAnnotationCheManagerNamespace]
 	workspaceID := routingObj.Services[0].Labels[config.WorkspaceIDLabel]
 
-	manager, err := findCheManager(client.ObjectKey{Name: managerName, Namespace: managerNamespace})
+	manager, err := findCheManager(context.TODO(), c.client, client.ObjectKey{Name: managerName, Namespace: managerNamespace})
 	if err != nil {
 		return nil, false, err
 	}
 
 	if manager.Spec.Routing == v1alpha1.SingleHost {
-		return c.singlehostExposedEndpoints(manager, workspaceID, endpoints, routingObj)
+		exposedEndpoints, ready, err = c.singlehostExposedEndpoints(manager, workspaceID, endpoints, routingObj)
+	} else {
+		exposedEndpoints, ready, err = c.multihostExposedEndpoints(manager, workspaceID, endpoints, routingObj)
 	}
 
-	return c.multihostExposedEndpoints(manager, workspaceID, endpoints, routingObj)
-}
+	if err == nil && ready {
+		c.observeRoutingReady(routingObj.Services[0].Namespace, metav1.GetControllerOf(&routingObj.Services[0]))
+	}
 
-func isSupported(routingClass controllerv1alpha1.WorkspaceRoutingClass) bool {
-	return routingClass == "che"
+	return exposedEndpoints, ready, err
 }
 
-func cheManagerOfRouting(routing *controllerv1alpha1.WorkspaceRouting) (*v1alpha1.CheManager, error) {
-	cheName := routing.Annotations[defaults.ConfigAnnotationCheManagerName]
-	cheNamespace := routing.Annotations[defaults.ConfigAnnotationCheManagerNamespace]
-
-	return findCheManager(client.ObjectKey{Name: cheName, Namespace: cheNamespace})
-}
+// observeRoutingReady records the time it took for the routing to become ready. The services in the cluster are
+// owned by the routing, so we can find it through their controller reference. The time is only recorded the first
+// time the routing becomes ready, i.e. while it is not in the ready phase yet, and only once for each generation
+// of the routing, because the routing can be reconciled again before its phase is updated.
+func (c *CheRoutingSolver) observeRoutingReady(namespace string, owner *metav1.OwnerReference) {
+	if owner == nil {
+		return
+	}
 
-func findCheManager(cheManagerKey client.ObjectKey) (*v1alpha1.CheManager, error) {
-	managers := manager.GetCurrentManagers()
-	if len(managers) == 0 {
-		// the CheManager has not been reconciled yet, so let's wait a bit
-		return &v1alpha1.CheManager{}, &solvers.RoutingNotReady{Retry: 1 * time.Second}
+	routing := &dwo.WorkspaceRouting{}
+	if err := c.client.Get(context.TODO(), client.ObjectKey{Name: owner.Name, Namespace: namespace}, routing); err != nil {
+		logger.Error(err, "Failed to find the workspace routing to observe its readiness", "name", owner.Name, "namespace", namespace)
+		return
 	}
 
-	if len(cheManagerKey.Name) == 0 {
-		if len(managers) > 1 {
-			return &v1alpha1.CheManager{}, &solvers.RoutingInvalid{Reason: fmt.Sprintf("the routing does not specify any Che manager in its configuration but there are %d Che managers in the cluster", len(managers))}
-		}
-		for _, m := range managers {
-			return &m, nil
-		}
+	if routing.UID != owner.UID || routing.Status.Phase == dwo.RoutingReady {
+		return
+	}
 
+	if !c.readyObservations.observe(routing.UID, routing.Generation) {
+		return
 	}
 
-	if m, ok := managers[cheManagerKey]; ok {
-		return &m, nil
+	metrics.RoutingReadyDuration.Observe(time.Since(routing.CreationTimestamp.Time).Seconds())
+}
+
+// routingReadyObservations remembers the generations of the workspace routings whose readiness has been observed.
+type routingReadyObservations struct {
+	lock        sync.Mutex
+	generations map[types.UID]int64
+}
+
+// observe returns true if the readiness of the given generation of the routing has not been observed yet and
+// remembers it as observed.
+func (o *routingReadyObservations) observe(uid types.UID, generation int64) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if observed, ok := o.generations[uid]; ok && observed == generation {
+		return false
 	}
+	o.generations[uid] = generation
+	return true
+}
 
-	logger.Info("Routing requires a non-existing che manager. Retrying in 10 seconds.", "key", cheManagerKey)
+// forget forgets the observations of the routing once it is being deleted.
+func (o *routingReadyObservations) forget(uid types.UID) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
 
-	return &v1alpha1.CheManager{}, &solvers.RoutingNotReady{Retry: 10 * time.Second}
+	delete(o.generations, uid)
+}
+
+func isSupported(routingClass controllerv1alpha1.WorkspaceRoutingClass) bool {
+	return routingClass == manager.CheRoutingClass
 }
@@ -0,0 +1,26 @@
+package solver
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestRoutingReadinessObservedOncePerGeneration(t *testing.T) {
+	observations := &routingReadyObservations{generations: map[types.UID]int64{}}
+
+	if !observations.observe("uid", 1) {
+		t.Error("The first generation of the routing should be observed")
+	}
+	if observations.observe("uid", 1) {
+		t.Error("The same generation of the routing should not be observed twice")
+	}
+	if !observations.observe("uid", 2) {
+		t.Error("The new generation of the routing should be observed")
+	}
+
+	observations.forget("uid")
+	if len(observations.generations) != 0 {
+		t.Errorf("The routing should have been forgotten but got %v", observations.generations)
+	}
+}
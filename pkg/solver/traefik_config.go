@@ -23,11 +23,24 @@ type traefikConfigService struct {
 }
 
 type traefikConfigMiddleware struct {
-	StripPrefix traefikConfigStripPrefix `json:"stripPrefix"`
+	StripPrefix    *traefikConfigStripPrefix    `json:"stripPrefix,omitempty"`
+	Buffering      *traefikConfigBuffering      `json:"buffering,omitempty"`
+	Headers        *traefikConfigHeaders        `json:"headers,omitempty"`
+	Chain          *traefikConfigChain          `json:"chain,omitempty"`
+	Retry          *traefikConfigRetry          `json:"retry,omitempty"`
+	CircuitBreaker *traefikConfigCircuitBreaker `json:"circuitBreaker,omitempty"`
+	Errors         *traefikConfigErrors         `json:"errors,omitempty"`
 }
 
 type traefikConfigLoadbalancer struct {
-	Servers []traefikConfigLoadbalancerServer `json:"servers"`
+	Servers     []traefikConfigLoadbalancerServer `json:"servers"`
+	HealthCheck *traefikConfigHealthCheck         `json:"healthCheck,omitempty"`
+}
+
+type traefikConfigHealthCheck struct {
+	Path     string `json:"path"`
+	Interval string `json:"interval,omitempty"`
+	Timeout  string `json:"timeout,omitempty"`
 }
 
 type traefikConfigLoadbalancerServer struct {
@@ -37,3 +50,36 @@ type traefikConfigLoadbalancerServer struct {
 type traefikConfigStripPrefix struct {
 	Prefixes []string `json:"prefixes"`
 }
+
+type traefikConfigBuffering struct {
+	MaxRequestBodyBytes int64 `json:"maxRequestBodyBytes"`
+}
+
+type traefikConfigHeaders struct {
+	CustomRequestHeaders          map[string]string `json:"customRequestHeaders,omitempty"`
+	CustomResponseHeaders         map[string]string `json:"customResponseHeaders,omitempty"`
+	AccessControlAllowOrigin      string            `json:"accessControlAllowOrigin,omitempty"`
+	AccessControlAllowMethods     []string          `json:"accessControlAllowMethods,omitempty"`
+	AccessControlAllowHeaders     []string          `json:"accessControlAllowHeaders,omitempty"`
+	AccessControlAllowCredentials bool              `json:"accessControlAllowCredentials,omitempty"`
+	AccessControlMaxAge           int64             `json:"accessControlMaxAge,omitempty"`
+	AddVaryHeader                 bool              `json:"addVaryHeader,omitempty"`
+}
+
+type traefikConfigChain struct {
+	Middlewares []string `json:"middlewares"`
+}
+
+type traefikConfigRetry struct {
+	Attempts int `json:"attempts"`
+}
+
+type traefikConfigCircuitBreaker struct {
+	Expression string `json:"expression"`
+}
+
+type traefikConfigErrors struct {
+	Status  []string `json:"status"`
+	Service string   `json:"service"`
+	Query   string   `json:"query"`
+}
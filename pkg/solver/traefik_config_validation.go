@@ -0,0 +1,349 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
+)
+
+var (
+	// the matchers Traefik supports in the router rules
+	traefikRuleMatchers = map[string]bool{
+		"Headers":       true,
+		"HeadersRegexp": true,
+		"Host":          true,
+		"HostHeader":    true,
+		"HostRegexp":    true,
+		"Method":        true,
+		"Path":          true,
+		"PathPrefix":    true,
+		"Query":         true,
+	}
+)
+
+// validateTraefikConfig checks the dynamic configuration of the workspace before it is handed over to the gateway.
+// Traefik drops the whole configuration file if it finds any error in it, so we rather report the problem on
+// the workspace routing. All the objects in the configuration need to be named after the workspace so that they
+// don't clash with the configuration of the other workspaces or the gateway itself. Any problem is reported as
+// a RoutingInvalid error.
+func validateTraefikConfig(workspaceID string, config *traefikConfig) error {
+	problems := []string{}
+
+	checkName := func(kind string, name string) {
+		if !strings.HasPrefix(name, workspaceID+"-") {
+			problems = append(problems, fmt.Sprintf("the name of the %s '%s' is not prefixed with the workspace ID", kind, name))
+		}
+	}
+
+	for _, name := range sortedRouterNames(config.HTTP.Routers) {
+		router := config.HTTP.Routers[name]
+		checkName("router", name)
+
+		if err := validateTraefikRule(router.Rule); err != nil {
+			problems = append(problems, fmt.Sprintf("the rule of the router '%s' is invalid: %s", name, err))
+		}
+
+		if _, ok := config.HTTP.Services[router.Service]; !ok {
+			problems = append(problems, fmt.Sprintf("the router '%s' references an unknown service '%s'", name, router.Service))
+		}
+
+		for _, m := range router.Middlewares {
+			if _, ok := config.HTTP.Middlewares[m]; !ok {
+				problems = append(problems, fmt.Sprintf("the router '%s' references an unknown middleware '%s'", name, m))
+			}
+		}
+	}
+
+	for _, name := range sortedServiceNames(config.HTTP.Services) {
+		service := config.HTTP.Services[name]
+		checkName("service", name)
+
+		if len(service.LoadBalancer.Servers) == 0 {
+			problems = append(problems, fmt.Sprintf("the service '%s' has no servers", name))
+		}
+
+		for _, server := range service.LoadBalancer.Servers {
+			if u, err := url.Parse(server.URL); err != nil || u.Scheme == "" || u.Host == "" {
+				problems = append(problems, fmt.Sprintf("the service '%s' has an invalid server URL '%s'", name, server.URL))
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(config.HTTP.Middlewares) {
+		mdl := config.HTTP.Middlewares[name]
+		checkName("middleware", name)
+
+		if count := countMiddlewareTypes(&mdl); count != 1 {
+			problems = append(problems, fmt.Sprintf("the middleware '%s' must define exactly one middleware type but defines %d", name, count))
+		}
+
+		if mdl.Chain != nil {
+			for _, m := range mdl.Chain.Middlewares {
+				if _, ok := config.HTTP.Middlewares[m]; !ok {
+					problems = append(problems, fmt.Sprintf("the chain middleware '%s' references an unknown middleware '%s'", name, m))
+				}
+			}
+		}
+
+		if mdl.Errors != nil {
+			if _, ok := config.HTTP.Services[mdl.Errors.Service]; !ok {
+				problems = append(problems, fmt.Sprintf("the errors middleware '%s' references an unknown service '%s'", name, mdl.Errors.Service))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &solvers.RoutingInvalid{Reason: fmt.Sprintf("the gateway configuration of the workspace is invalid: %s", strings.Join(problems, "; "))}
+	}
+
+	return nil
+}
+
+// addTraefikService adds the service to the configuration of the workspace. The names of the objects in
+// the configuration are derived from the route names and the user-chosen middleware profile names, so two different
+// objects can end up with the same name. Traefik would silently use just one of them, so that is reported as
+// a RoutingInvalid error. Adding the same object again, e.g. one shared by several routes, is fine.
+func addTraefikService(srvcs map[string]traefikConfigService, name string, service traefikConfigService) error {
+	if existing, ok := srvcs[name]; ok && !reflect.DeepEqual(existing, service) {
+		return &solvers.RoutingInvalid{Reason: fmt.Sprintf("the gateway configuration of the workspace is invalid: the service name '%s' is used more than once", name)}
+	}
+	srvcs[name] = service
+	return nil
+}
+
+// addTraefikMiddleware adds the middleware to the configuration of the workspace. Same as with the services,
+// different middlewares with the same name are reported as a RoutingInvalid error.
+func addTraefikMiddleware(mdls map[string]traefikConfigMiddleware, name string, mdl traefikConfigMiddleware) error {
+	if existing, ok := mdls[name]; ok && !reflect.DeepEqual(existing, mdl) {
+		return &solvers.RoutingInvalid{Reason: fmt.Sprintf("the gateway configuration of the workspace is invalid: the middleware name '%s' is used more than once", name)}
+	}
+	mdls[name] = mdl
+	return nil
+}
+
+func countMiddlewareTypes(mdl *traefikConfigMiddleware) int {
+	count := 0
+	for _, defined := range []bool{
+		mdl.StripPrefix != nil,
+		mdl.Buffering != nil,
+		mdl.Headers != nil,
+		mdl.Chain != nil,
+		mdl.Retry != nil,
+		mdl.CircuitBreaker != nil,
+		mdl.Errors != nil,
+	} {
+		if defined {
+			count++
+		}
+	}
+	return count
+}
+
+// validateTraefikRule checks that the router rule is syntactically valid. The rule consists of the matchers, like
+// PathPrefix(`/foo`), combined using the "&&", "||" and "!" operators and the parentheses.
+func validateTraefikRule(rule string) error {
+	tokens, err := tokenizeTraefikRule(rule)
+	if err != nil {
+		return err
+	}
+
+	if len(tokens) == 0 {
+		return fmt.Errorf("the rule is empty")
+	}
+
+	p := &traefikRuleParser{tokens: tokens}
+	if err := p.parseOr(); err != nil {
+		return err
+	}
+
+	if p.pos != len(p.tokens) {
+		return fmt.Errorf("unexpected '%s'", p.tokens[p.pos].value)
+	}
+
+	return nil
+}
+
+type traefikRuleTokenKind int
+
+const (
+	ruleIdentifier traefikRuleTokenKind = iota
+	ruleString
+	ruleOperator
+)
+
+type traefikRuleToken struct {
+	kind  traefikRuleTokenKind
+	value string
+}
+
+func tokenizeTraefikRule(rule string) ([]traefikRuleToken, error) {
+	tokens := []traefikRuleToken{}
+
+	for i := 0; i < len(rule); {
+		c := rule[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')' || c == ',' || c == '!':
+			tokens = append(tokens, traefikRuleToken{kind: ruleOperator, value: string(c)})
+			i++
+		case strings.HasPrefix(rule[i:], "&&") || strings.HasPrefix(rule[i:], "||"):
+			tokens = append(tokens, traefikRuleToken{kind: ruleOperator, value: rule[i : i+2]})
+			i += 2
+		case c == '`' || c == '"':
+			end := strings.IndexByte(rule[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string starting at position %d", i)
+			}
+			tokens = append(tokens, traefikRuleToken{kind: ruleString, value: rule[i+1 : i+1+end]})
+			i += end + 2
+		case c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			start := i
+			for i < len(rule) && (rule[i] >= 'A' && rule[i] <= 'Z' || rule[i] >= 'a' && rule[i] <= 'z') {
+				i++
+			}
+			tokens = append(tokens, traefikRuleToken{kind: ruleIdentifier, value: rule[start:i]})
+		default:
+			return nil, fmt.Errorf("unexpected character '%c' at position %d", c, i)
+		}
+	}
+
+	return tokens, nil
+}
+
+// traefikRuleParser is a recursive descent parser of the router rules.
+type traefikRuleParser struct {
+	tokens []traefikRuleToken
+	pos    int
+}
+
+func (p *traefikRuleParser) peek(value string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == ruleOperator && p.tokens[p.pos].value == value
+}
+
+func (p *traefikRuleParser) expect(value string) error {
+	if !p.peek(value) {
+		return p.unexpected(value)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *traefikRuleParser) unexpected(expected string) error {
+	if p.pos >= len(p.tokens) {
+		return fmt.Errorf("expected '%s' but the rule ended", expected)
+	}
+	return fmt.Errorf("expected '%s' but found '%s'", expected, p.tokens[p.pos].value)
+}
+
+func (p *traefikRuleParser) parseOr() error {
+	if err := p.parseAnd(); err != nil {
+		return err
+	}
+	for p.peek("||") {
+		p.pos++
+		if err := p.parseAnd(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *traefikRuleParser) parseAnd() error {
+	if err := p.parseUnary(); err != nil {
+		return err
+	}
+	for p.peek("&&") {
+		p.pos++
+		if err := p.parseUnary(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *traefikRuleParser) parseUnary() error {
+	if p.peek("!") {
+		p.pos++
+		return p.parseUnary()
+	}
+
+	if p.peek("(") {
+		p.pos++
+		if err := p.parseOr(); err != nil {
+			return err
+		}
+		return p.expect(")")
+	}
+
+	return p.parseMatcher()
+}
+
+func (p *traefikRuleParser) parseMatcher() error {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != ruleIdentifier {
+		return p.unexpected("matcher")
+	}
+
+	matcher := p.tokens[p.pos].value
+	if !traefikRuleMatchers[matcher] {
+		return fmt.Errorf("unknown matcher '%s'", matcher)
+	}
+	p.pos++
+
+	if err := p.expect("("); err != nil {
+		return err
+	}
+
+	args := []string{}
+	for {
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != ruleString {
+			return p.unexpected("string")
+		}
+		args = append(args, p.tokens[p.pos].value)
+		p.pos++
+
+		if !p.peek(",") {
+			break
+		}
+		p.pos++
+	}
+
+	if err := p.expect(")"); err != nil {
+		return err
+	}
+
+	if matcher == "Path" || matcher == "PathPrefix" {
+		for _, arg := range args {
+			if !strings.HasPrefix(arg, "/") {
+				return fmt.Errorf("the path '%s' of the %s matcher doesn't start with '/'", arg, matcher)
+			}
+		}
+	}
+
+	return nil
+}
+
+func sortedServiceNames(srvcs map[string]traefikConfigService) []string {
+	ret := make([]string, 0, len(srvcs))
+	for k := range srvcs {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
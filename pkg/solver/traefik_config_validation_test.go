@@ -0,0 +1,176 @@
+package solver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	dwo "github.com/devfile/devworkspace-operator/apis/controller/v1alpha1"
+	"github.com/devfile/devworkspace-operator/controllers/controller/workspacerouting/solvers"
+)
+
+func validTraefikConfig() *traefikConfig {
+	return &traefikConfig{
+		HTTP: traefikConfigHTTP{
+			Routers: map[string]traefikConfigRouter{
+				"wsid-r": {
+					Rule:        "PathPrefix(`/wsid/r`)",
+					Service:     "wsid-s",
+					Middlewares: []string{"wsid-chain"},
+					Priority:    100,
+				},
+			},
+			Services: map[string]traefikConfigService{
+				"wsid-s": {
+					LoadBalancer: traefikConfigLoadbalancer{
+						Servers: []traefikConfigLoadbalancerServer{{URL: "http://wsid-service.ws.svc:9999"}},
+					},
+				},
+			},
+			Middlewares: map[string]traefikConfigMiddleware{
+				"wsid-chain": {
+					Chain: &traefikConfigChain{Middlewares: []string{"wsid-strip"}},
+				},
+				"wsid-strip": {
+					StripPrefix: &traefikConfigStripPrefix{Prefixes: []string{"/wsid/r"}},
+				},
+			},
+		},
+	}
+}
+
+func TestTraefikConfigValidation(t *testing.T) {
+	if err := validateTraefikConfig("wsid", validTraefikConfig()); err != nil {
+		t.Fatalf("The configuration should be valid but got: %s", err)
+	}
+
+	tests := map[string]func(cfg *traefikConfig){
+		"unknown service": func(cfg *traefikConfig) {
+			r := cfg.HTTP.Routers["wsid-r"]
+			r.Service = "wsid-nonexistent"
+			cfg.HTTP.Routers["wsid-r"] = r
+		},
+		"unknown middleware": func(cfg *traefikConfig) {
+			cfg.HTTP.Middlewares["wsid-chain"] = traefikConfigMiddleware{
+				Chain: &traefikConfigChain{Middlewares: []string{"wsid-nonexistent"}},
+			}
+		},
+		"empty rule": func(cfg *traefikConfig) {
+			r := cfg.HTTP.Routers["wsid-r"]
+			r.Rule = ""
+			cfg.HTTP.Routers["wsid-r"] = r
+		},
+		"foreign name": func(cfg *traefikConfig) {
+			cfg.HTTP.Routers["other-r"] = cfg.HTTP.Routers["wsid-r"]
+		},
+		"no middleware type": func(cfg *traefikConfig) {
+			cfg.HTTP.Middlewares["wsid-empty"] = traefikConfigMiddleware{}
+		},
+	}
+
+	for name, breakConfig := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := validTraefikConfig()
+			breakConfig(cfg)
+
+			var invalid *solvers.RoutingInvalid
+			if err := validateTraefikConfig("wsid", cfg); !errors.As(err, &invalid) {
+				t.Errorf("Expected RoutingInvalid error but got: %v", err)
+			}
+		})
+	}
+}
+
+func TestTraefikRuleValidation(t *testing.T) {
+	valid := []string{
+		"PathPrefix(`/a`)",
+		"Host(`example.com`) && (PathPrefix(`/a`, `/b`) || !Path(\"/c\"))",
+	}
+	for _, rule := range valid {
+		if err := validateTraefikRule(rule); err != nil {
+			t.Errorf("The rule '%s' should be valid but got: %s", rule, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"PathPrefix(`/a`",
+		"PathPrefix(`/a`) &&",
+		"PathPrefix()",
+		"PathPrefix(`a`)",
+		"Unknown(`/a`)",
+		"PathPrefix(`/a`) PathPrefix(`/b`)",
+		"PathPrefix(`/a)",
+	}
+	for _, rule := range invalid {
+		if err := validateTraefikRule(rule); err == nil {
+			t.Errorf("The rule '%s' should be invalid", rule)
+		}
+	}
+}
+
+func TestInvalidGeneratedConfigIsReported(t *testing.T) {
+	routing := simpleWorkspaceRouting()
+	routing.Spec.Endpoints = map[string]dwo.EndpointList{
+		"m`1": routing.Spec.Endpoints["m1"],
+	}
+
+	_, _, _, err := tryGetSpecObjectsForManager(t, routing, simpleCheManager())
+
+	var invalid *solvers.RoutingInvalid
+	if !errors.As(err, &invalid) {
+		t.Fatalf("The configuration with a broken rule should have produced RoutingInvalid error but got: %v", err)
+	}
+	if !strings.Contains(invalid.Reason, "rule") {
+		t.Errorf("The reason should mention the rule: %s", invalid.Reason)
+	}
+}
+
+func TestAddTraefikMiddlewareDetectsCollisions(t *testing.T) {
+	mdls := map[string]traefikConfigMiddleware{}
+	stripPrefix := traefikConfigMiddleware{StripPrefix: &traefikConfigStripPrefix{Prefixes: []string{"/wsid/a"}}}
+
+	if err := addTraefikMiddleware(mdls, "wsid-a", stripPrefix); err != nil {
+		t.Fatal(err)
+	}
+	if err := addTraefikMiddleware(mdls, "wsid-a", stripPrefix); err != nil {
+		t.Errorf("Adding the same middleware again should be fine but got: %s", err)
+	}
+
+	err := addTraefikMiddleware(mdls, "wsid-a", traefikConfigMiddleware{Chain: &traefikConfigChain{Middlewares: []string{"wsid-b"}}})
+	var invalid *solvers.RoutingInvalid
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Adding a different middleware with the same name should have produced RoutingInvalid error but got: %v", err)
+	}
+	if !strings.Contains(invalid.Reason, "wsid-a") {
+		t.Errorf("The reason should mention the colliding name: %s", invalid.Reason)
+	}
+	if mdls["wsid-a"].StripPrefix == nil {
+		t.Error("The original middleware should have been kept")
+	}
+}
+
+func TestAddTraefikServiceDetectsCollisions(t *testing.T) {
+	srvcs := map[string]traefikConfigService{}
+	service := traefikConfigService{
+		LoadBalancer: traefikConfigLoadbalancer{
+			Servers: []traefikConfigLoadbalancerServer{{URL: "http://a.ns.svc:8080"}},
+		},
+	}
+
+	if err := addTraefikService(srvcs, "wsid-a", service); err != nil {
+		t.Fatal(err)
+	}
+	if err := addTraefikService(srvcs, "wsid-a", service); err != nil {
+		t.Errorf("Adding the same service again should be fine but got: %s", err)
+	}
+
+	other := service
+	other.LoadBalancer.Servers = []traefikConfigLoadbalancerServer{{URL: "http://b.ns.svc:8080"}}
+	if err := addTraefikService(srvcs, "wsid-a", other); err == nil {
+		t.Error("Adding a different service with the same name should have failed")
+	}
+	if srvcs["wsid-a"].LoadBalancer.Servers[0].URL != "http://a.ns.svc:8080" {
+		t.Error("The original service should have been kept")
+	}
+}
@@ -0,0 +1,130 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package solver
+
+import (
+	dwoche "github.com/che-incubator/devworkspace-che-operator/apis/che-controller/v1alpha1"
+	"github.com/che-incubator/devworkspace-che-operator/pkg/gateway"
+)
+
+const (
+	defaultHealthCheckInterval = "10s"
+	defaultHealthCheckTimeout  = "3s"
+)
+
+var (
+	// the status codes the gateway returns when the workspace backend is not available (yet)
+	workspaceUnavailableStatusCodes = []string{"502", "503", "504"}
+)
+
+// getErrorPagesName returns the name of the service and middleware used to serve the error pages for
+// the workspace.
+func getErrorPagesName(workspaceID string) string {
+	return workspaceID + "-error-pages"
+}
+
+// addErrorPages adds the service and middleware serving the error pages (e.g. the "workspace starting" page)
+// whenever the workspace backends are not available. It returns the name of the middleware to be used by
+// the routers.
+func addErrorPages(workspaceID string, srvcs map[string]traefikConfigService, mdls map[string]traefikConfigMiddleware) (string, error) {
+	name := getErrorPagesName(workspaceID)
+
+	err := addTraefikService(srvcs, name, traefikConfigService{
+		LoadBalancer: traefikConfigLoadbalancer{
+			Servers: []traefikConfigLoadbalancerServer{
+				{
+					URL: gateway.GetErrorPagesURL(),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = addTraefikMiddleware(mdls, name, traefikConfigMiddleware{
+		Errors: &traefikConfigErrors{
+			Status:  workspaceUnavailableStatusCodes,
+			Service: name,
+			Query:   gateway.ErrorPagePathPattern,
+		},
+	})
+
+	return name, err
+}
+
+// getHealthCheck returns the health check configuration for a backend or nil if the backend should not
+// be health-checked.
+func getHealthCheck(cheManager *dwoche.CheManager, path string) *traefikConfigHealthCheck {
+	if path == "" {
+		return nil
+	}
+
+	interval := defaultHealthCheckInterval
+	timeout := defaultHealthCheckTimeout
+
+	if cfg := cheManager.Spec.WorkspaceBackends; cfg != nil {
+		if cfg.HealthCheckInterval != "" {
+			interval = cfg.HealthCheckInterval
+		}
+		if cfg.HealthCheckTimeout != "" {
+			timeout = cfg.HealthCheckTimeout
+		}
+	}
+
+	return &traefikConfigHealthCheck{
+		Path:     path,
+		Interval: interval,
+		Timeout:  timeout,
+	}
+}
+
+// addBackendFailureHandling adds the retry and circuit breaker middlewares for the router with the given name
+// as configured in the che manager. Returns the names of the added middlewares in the order in which they
+// should be applied.
+func addBackendFailureHandling(cheManager *dwoche.CheManager, name string, mdls map[string]traefikConfigMiddleware) ([]string, error) {
+	cfg := cheManager.Spec.WorkspaceBackends
+	if cfg == nil {
+		return []string{}, nil
+	}
+
+	ret := []string{}
+
+	if cfg.RetryAttempts > 0 {
+		retryName := name + "-retry"
+		err := addTraefikMiddleware(mdls, retryName, traefikConfigMiddleware{
+			Retry: &traefikConfigRetry{
+				Attempts: cfg.RetryAttempts,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, retryName)
+	}
+
+	if cfg.CircuitBreakerExpression != "" {
+		cbName := name + "-circuit-breaker"
+		err := addTraefikMiddleware(mdls, cbName, traefikConfigMiddleware{
+			CircuitBreaker: &traefikConfigCircuitBreaker{
+				Expression: cfg.CircuitBreakerExpression,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, cbName)
+	}
+
+	return ret, nil
+}
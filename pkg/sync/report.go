@@ -0,0 +1,189 @@
+//
+// Copyright (c) 2019-2021 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package sync
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Reason says why the syncer changed an object in the cluster.
+type Reason string
+
+const (
+	// ReasonCreated means the object didn't exist in the cluster.
+	ReasonCreated Reason = "Created"
+	// ReasonUpdated means the object in the cluster differed from the blueprint and was updated in place.
+	ReasonUpdated Reason = "Updated"
+	// ReasonRecreated means the object in the cluster differed from the blueprint and had to be deleted and created
+	// again, because it could not be updated in place.
+	ReasonRecreated Reason = "Recreated"
+	// ReasonDeleted means the object was deleted from the cluster.
+	ReasonDeleted Reason = "Deleted"
+)
+
+// the verbs describing the changes in the dry-run mode, when they were not actually made
+var dryRunVerbs = map[Reason]string{
+	ReasonCreated:   "Would create",
+	ReasonUpdated:   "Would update",
+	ReasonRecreated: "Would re-create",
+	ReasonDeleted:   "Would delete",
+}
+
+// maximum number of the changed fields listed in the event messages, the events have a limited size
+const maxEventChanges = 10
+
+// Report describes a change the syncer made (or would make in the dry-run mode) to an object in the cluster.
+type Report struct {
+	Kind      string
+	Name      string
+	Namespace string
+	Reason    Reason
+	// Changes lists the fields that differ between the object in the cluster and the blueprint. It is empty for
+	// the created and deleted objects.
+	Changes []Change
+	// Diff is the human-readable diff between the object in the cluster and the blueprint as produced by cmp.Diff.
+	Diff string
+	// DryRun is true if the change was not actually made, because the syncer is in the dry-run mode.
+	DryRun bool
+}
+
+// Change is a single field that differs between the object in the cluster and the blueprint.
+type Change struct {
+	// Path is the path to the field in the Go structure of the object, e.g. "Spec.Ports[0].Port".
+	Path string
+	// Actual is the value in the cluster or "<none>" if the field doesn't exist there.
+	Actual string
+	// Desired is the value in the blueprint or "<none>" if the field doesn't exist there.
+	Desired string
+}
+
+// Paths returns the paths of the changed fields.
+func (r *Report) Paths() []string {
+	ret := make([]string, len(r.Changes))
+	for i, c := range r.Changes {
+		ret[i] = c.Path
+	}
+	return ret
+}
+
+// EventReason returns the reason of the event about the change.
+func (r *Report) EventReason() string {
+	if r.DryRun {
+		return "DryRun" + string(r.Reason)
+	}
+	return string(r.Reason)
+}
+
+// String returns a short summary of the change suitable for an event message.
+func (r *Report) String() string {
+	verb := string(r.Reason)
+	if r.DryRun {
+		verb = dryRunVerbs[r.Reason]
+	}
+	summary := fmt.Sprintf("%s %s %s/%s", verb, r.Kind, r.Namespace, r.Name)
+	if len(r.Changes) == 0 {
+		return summary
+	}
+
+	paths := r.Paths()
+	if len(paths) > maxEventChanges {
+		paths = append(paths[:maxEventChanges], fmt.Sprintf("and %d more", len(paths)-maxEventChanges))
+	}
+
+	return summary + ": " + strings.Join(paths, ", ")
+}
+
+// changeReporter is a cmp.Reporter collecting the fields that are not equal.
+type changeReporter struct {
+	path    cmp.Path
+	changes []Change
+}
+
+func (r *changeReporter) PushStep(ps cmp.PathStep) {
+	r.path = append(r.path, ps)
+}
+
+func (r *changeReporter) Report(rs cmp.Result) {
+	if rs.Equal() {
+		return
+	}
+
+	vx, vy := r.path.Last().Values()
+	r.changes = append(r.changes, Change{Path: formatPath(r.path), Actual: formatValue(vx), Desired: formatValue(vy)})
+}
+
+func (r *changeReporter) PopStep() {
+	r.path = r.path[:len(r.path)-1]
+}
+
+// findChanges compares the objects using the same options as cmp.Diff and returns the fields that differ.
+func findChanges(actual interface{}, desired interface{}, diffOpts cmp.Option) []Change {
+	r := &changeReporter{}
+	cmp.Equal(actual, desired, diffOpts, cmp.Reporter(r))
+	return r.changes
+}
+
+func formatPath(path cmp.Path) string {
+	sb := strings.Builder{}
+	for _, step := range path {
+		switch s := step.(type) {
+		case cmp.StructField:
+			sb.WriteString(".")
+			sb.WriteString(s.Name())
+		case cmp.SliceIndex:
+			ix, iy := s.SplitKeys()
+			if iy < 0 {
+				iy = ix
+			}
+			sb.WriteString(fmt.Sprintf("[%d]", iy))
+		case cmp.MapIndex:
+			sb.WriteString(fmt.Sprintf("[%v]", s.Key()))
+		}
+	}
+	return strings.TrimPrefix(sb.String(), ".")
+}
+
+func formatValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return "<none>"
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// applyDiffOpts are used to compare the objects before and after the server-side apply. The metadata maintained
+// by the cluster itself is ignored and the unexported fields are compared so that any typed object can be compared.
+var applyDiffOpts = cmp.Options{
+	cmp.FilterPath(isClusterMaintainedField, cmp.Ignore()),
+	cmp.Comparer(func(x, y resource.Quantity) bool { return x.Cmp(y) == 0 }),
+	cmp.Exporter(func(reflect.Type) bool { return true }),
+}
+
+// clusterMaintainedFields are the paths, as formatted by formatPath, of the metadata the cluster maintains itself,
+// both in the typed and the unstructured objects.
+var clusterMaintainedFields = map[string]bool{
+	"ObjectMeta.ResourceVersion":        true,
+	"ObjectMeta.ManagedFields":          true,
+	"ObjectMeta.Generation":             true,
+	"Object[metadata][resourceVersion]": true,
+	"Object[metadata][managedFields]":   true,
+	"Object[metadata][generation]":      true,
+}
+
+func isClusterMaintainedField(path cmp.Path) bool {
+	return clusterMaintainedFields[formatPath(path)]
+}
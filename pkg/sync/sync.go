@@ -16,12 +16,16 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/che-incubator/devworkspace-che-operator/pkg/metrics"
 	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
@@ -31,20 +35,55 @@ var (
 
 // Syncer synchronized K8s objects with the cluster
 type Syncer struct {
-	client client.Client
-	scheme *runtime.Scheme
+	client       client.Client
+	scheme       *runtime.Scheme
+	recorder     record.EventRecorder
+	fieldManager string
+	dryRun       bool
 }
 
-func New(client client.Client, scheme *runtime.Scheme) Syncer {
-	return Syncer{client: client, scheme: scheme}
+// New returns a new syncer. If the recorder is not nil, the syncer records the events about the created, updated and
+// re-created objects on their owners. If the field manager is not empty, the syncer syncs the objects using
+// the server-side apply with that field manager. Otherwise, it compares the objects with the blueprints itself.
+func New(client client.Client, scheme *runtime.Scheme, recorder record.EventRecorder, fieldManager string) Syncer {
+	return Syncer{client: client, scheme: scheme, recorder: recorder, fieldManager: fieldManager}
+}
+
+// DryRun returns a copy of the syncer that only reports the changes it would make to the cluster without actually
+// making them. The events about the changes are still recorded, with the "DryRun" prefix in their reason, but no
+// metrics are updated in the dry-run mode.
+func (s *Syncer) DryRun() Syncer {
+	ret := *s
+	ret.dryRun = true
+	return ret
 }
 
 // Sync syncs the blueprint to the cluster in a generic (as much as Go allows) manner.
 // Returns true if the object was created or updated, false if there was no change detected.
+//
+// If the syncer uses the server-side apply, the diff options are not used, because the cluster itself figures out
+// whether the object changed or not. Otherwise, the object in the cluster is compared with the blueprint using
+// the diff options and updated if they differ.
 func (s *Syncer) Sync(ctx context.Context, owner metav1.Object, blueprint metav1.Object, diffOpts cmp.Option) (bool, runtime.Object, error) {
+	changed, _, obj, err := s.sync(ctx, owner, blueprint, diffOpts)
+	return changed, obj, err
+}
+
+// SyncAndReport syncs the blueprint to the cluster the same way as Sync but returns the report describing what was
+// changed and why. The report is nil if there was no change detected.
+func (s *Syncer) SyncAndReport(ctx context.Context, owner metav1.Object, blueprint metav1.Object, diffOpts cmp.Option) (*Report, runtime.Object, error) {
+	_, report, obj, err := s.sync(ctx, owner, blueprint, diffOpts)
+	return report, obj, err
+}
+
+func (s *Syncer) sync(ctx context.Context, owner metav1.Object, blueprint metav1.Object, diffOpts cmp.Option) (bool, *Report, runtime.Object, error) {
 	blueprintObject, ok := blueprint.(runtime.Object)
 	if !ok {
-		return false, nil, fmt.Errorf("object %T is not a runtime.Object. Cannot sync it", blueprint)
+		return false, nil, nil, fmt.Errorf("object %T is not a runtime.Object. Cannot sync it", blueprint)
+	}
+
+	if s.fieldManager != "" {
+		return s.apply(ctx, owner, blueprint)
 	}
 
 	key := client.ObjectKey{Name: blueprint.GetName(), Namespace: blueprint.GetNamespace()}
@@ -53,18 +92,25 @@ func (s *Syncer) Sync(ctx context.Context, owner metav1.Object, blueprint metav1
 
 	if getErr := s.client.Get(context.TODO(), key, actual); getErr != nil {
 		if statusErr, ok := getErr.(*errors.StatusError); !ok || statusErr.Status().Reason != metav1.StatusReasonNotFound {
-			return false, nil, getErr
+			return false, nil, nil, getErr
 		}
 		actual = nil
 	}
 
 	if actual == nil {
+		report := s.newReport(ReasonCreated, blueprintObject)
+		if s.dryRun {
+			log.Info("Would create a new object", "kind", report.Kind, "name", report.Name, "namespace", report.Namespace)
+			s.recordEvent(owner, report)
+			return true, report, blueprintObject, nil
+		}
+
 		actual, err := s.create(ctx, owner, key, blueprint)
 		if err != nil {
-			return false, actual, err
+			return false, nil, actual, err
 		}
 
-		return true, actual, nil
+		return true, report, actual, nil
 	}
 
 	return s.update(ctx, owner, actual, blueprint, diffOpts)
@@ -72,23 +118,45 @@ func (s *Syncer) Sync(ctx context.Context, owner metav1.Object, blueprint metav1
 
 // Delete deletes the supplied object from the cluster.
 func (s *Syncer) Delete(ctx context.Context, object metav1.Object) error {
+	_, err := s.DeleteAndReport(ctx, object)
+	return err
+}
+
+// DeleteAndReport deletes the supplied object from the cluster the same way as Delete but returns the report about
+// the deletion. The report is nil if the object didn't exist. The event about the deletion is recorded on
+// the controller of the object, if it has any.
+func (s *Syncer) DeleteAndReport(ctx context.Context, object metav1.Object) (*Report, error) {
 	key := client.ObjectKey{Name: object.GetName(), Namespace: object.GetNamespace()}
 
-	var err error
 	ro, ok := object.(runtime.Object)
 	if !ok {
-		return fmt.Errorf("Could not use the supplied object as kubernetes runtime object. That's unexpected: %s", object)
+		return nil, fmt.Errorf("Could not use the supplied object as kubernetes runtime object. That's unexpected: %s", object)
+	}
+
+	if err := s.client.Get(ctx, key, ro); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
 	}
 
-	if err = s.client.Get(ctx, key, ro); err == nil {
-		err = s.client.Delete(ctx, ro)
+	report := s.newReport(ReasonDeleted, ro)
+	if s.dryRun {
+		log.Info("Would delete the object", "kind", report.Kind, "name", key.Name, "namespace", key.Namespace)
+		s.recordEventOn(controllerOf(object), report)
+		return report, nil
 	}
 
-	if err != nil && !errors.IsNotFound(err) {
-		return err
+	if err := s.client.Delete(ctx, ro); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
 	}
 
-	return nil
+	metrics.SyncedObjects.WithLabelValues(report.Kind, "delete").Inc()
+	s.recordEventOn(controllerOf(object), report)
+	return report, nil
 }
 
 func (s *Syncer) create(ctx context.Context, owner metav1.Object, key client.ObjectKey, blueprint metav1.Object) (runtime.Object, error) {
@@ -107,7 +175,10 @@ func (s *Syncer) create(ctx context.Context, owner metav1.Object, key client.Obj
 	}
 
 	err = s.client.Create(ctx, obj)
-	if err != nil {
+	if err == nil {
+		metrics.SyncedObjects.WithLabelValues(s.kindOf(obj), "create").Inc()
+		s.recordEvent(owner, s.newReport(ReasonCreated, obj))
+	} else {
 		if !errors.IsAlreadyExists(err) {
 			return nil, err
 		}
@@ -123,13 +194,26 @@ func (s *Syncer) create(ctx context.Context, owner metav1.Object, key client.Obj
 	return actual, nil
 }
 
-func (s *Syncer) update(ctx context.Context, owner metav1.Object, actual runtime.Object, blueprint metav1.Object, diffOpts cmp.Option) (bool, runtime.Object, error) {
+func (s *Syncer) update(ctx context.Context, owner metav1.Object, actual runtime.Object, blueprint metav1.Object, diffOpts cmp.Option) (bool, *Report, runtime.Object, error) {
 	actualMeta := actual.(metav1.Object)
 
 	diff := cmp.Diff(actual, blueprint, diffOpts)
 	if len(diff) > 0 {
-		kind := actual.GetObjectKind().GroupVersionKind().Kind
-		log.Info("Updating existing object", "kind", kind, "name", actualMeta.GetName(), "namespace", actualMeta.GetNamespace())
+		recreate := isUpdateUsingDeleteCreate(actual.GetObjectKind().GroupVersionKind().Kind)
+
+		reason := ReasonUpdated
+		if recreate {
+			reason = ReasonRecreated
+		}
+		report := s.newReport(reason, actual)
+		report.Changes = findChanges(actual, blueprint, diffOpts)
+		report.Diff = diff
+
+		s.logReport(report)
+		if s.dryRun {
+			s.recordEvent(owner, report)
+			return true, report, actual, nil
+		}
 
 		// we need to handle labels and annotations specially in case the cluster admin has modified them.
 		// if the current object in the cluster has the same annos/labels, they get overwritten with what's
@@ -154,19 +238,25 @@ func (s *Syncer) update(ctx context.Context, owner metav1.Object, actual runtime
 		blueprint.SetAnnotations(targetAnnos)
 		blueprint.SetLabels(targetLabels)
 
-		if isUpdateUsingDeleteCreate(actual.GetObjectKind().GroupVersionKind().Kind) {
+		if recreate {
 			err := s.client.Delete(ctx, actual)
 			if err != nil {
-				return false, actual, err
+				return false, nil, actual, err
 			}
 
+			metrics.RecreatedObjects.WithLabelValues(s.kindOf(actual)).Inc()
+			s.recordEvent(owner, report)
+
 			key := client.ObjectKey{Name: actualMeta.GetName(), Namespace: actualMeta.GetNamespace()}
 			obj, err := s.create(ctx, owner, key, blueprint)
-			return false, obj, err
+			if err != nil {
+				return false, nil, obj, err
+			}
+			return true, report, obj, nil
 		} else {
 			obj, err := s.setOwnerReferenceAndConvertToRuntime(owner, blueprint)
 			if err != nil {
-				return false, actual, err
+				return false, nil, actual, err
 			}
 
 			// to be able to update, we need to set the resource version of the object that we know of
@@ -174,13 +264,180 @@ func (s *Syncer) update(ctx context.Context, owner metav1.Object, actual runtime
 
 			err = s.client.Update(ctx, obj)
 			if err != nil {
-				return false, obj, err
+				return false, nil, obj, err
 			}
 
-			return true, obj, nil
+			metrics.SyncedObjects.WithLabelValues(s.kindOf(obj), "update").Inc()
+			s.recordEvent(owner, report)
+
+			return true, report, obj, nil
+		}
+	}
+	return false, nil, actual, nil
+}
+
+// apply applies the blueprint to the cluster using the server-side apply. The object returned by the apply is compared
+// with the object last seen by the client, which usually reads from the cache, to find out what the apply changed.
+// The cache might lag behind the cluster, so only the actual differences are considered a change, not just the newer
+// resource version of the object. In the dry-run mode, the apply returns the object as it would be after the change.
+func (s *Syncer) apply(ctx context.Context, owner metav1.Object, blueprint metav1.Object) (bool, *Report, runtime.Object, error) {
+	obj, err := s.setOwnerReferenceAndConvertToRuntime(owner, blueprint)
+	if err != nil {
+		return false, nil, nil, err
+	}
+
+	// the apply patch needs to contain the kind and must not contain the resource version or the managed fields
+	gvk, err := apiutil.GVKForObject(obj, s.scheme)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	objMeta := obj.(metav1.Object)
+	objMeta.SetResourceVersion("")
+	objMeta.SetManagedFields(nil)
+
+	key := client.ObjectKey{Name: objMeta.GetName(), Namespace: objMeta.GetNamespace()}
+
+	actual := obj.DeepCopyObject()
+	existed := true
+	if err = s.client.Get(ctx, key, actual); err != nil {
+		if !errors.IsNotFound(err) {
+			return false, nil, nil, err
+		}
+		existed = false
+	}
+
+	patchOpts := []client.PatchOption{client.FieldOwner(s.fieldManager), client.ForceOwnership}
+	if s.dryRun {
+		patchOpts = append(patchOpts, client.DryRunAll)
+	}
+
+	applied := obj.DeepCopyObject()
+	err = s.client.Patch(ctx, obj, client.Apply, patchOpts...)
+	if err != nil && existed && errors.IsInvalid(err) && isUpdateUsingDeleteCreate(gvk.Kind) {
+		// some fields of these objects are immutable, so we need to re-create them the same way as when not using
+		// the server-side apply
+		report := s.newReport(ReasonRecreated, actual)
+		report.Changes = findChanges(actual, applied, applyDiffOpts)
+		report.Diff = cmp.Diff(actual, applied, applyDiffOpts)
+		s.logReport(report)
+
+		if s.dryRun {
+			s.recordEvent(owner, report)
+			return true, report, applied, nil
+		}
+
+		log.Info("Re-creating the object that could not be applied", "kind", gvk.Kind, "name", key.Name, "namespace", key.Namespace, "error", err)
+		if err = s.client.Delete(ctx, actual); err != nil && !errors.IsNotFound(err) {
+			return false, nil, actual, err
+		}
+
+		metrics.RecreatedObjects.WithLabelValues(gvk.Kind).Inc()
+		s.recordEvent(owner, report)
+
+		obj = applied
+		if err = s.client.Patch(ctx, obj, client.Apply, patchOpts...); err != nil {
+			return false, nil, obj, err
 		}
+
+		metrics.SyncedObjects.WithLabelValues(gvk.Kind, "create").Inc()
+		return true, report, obj, nil
+	}
+	if err != nil {
+		return false, nil, obj, err
+	}
+
+	if !existed {
+		report := s.newReport(ReasonCreated, obj)
+		if s.dryRun {
+			log.Info("Would create a new object", "kind", gvk.Kind, "name", key.Name, "namespace", key.Namespace)
+			s.recordEvent(owner, report)
+			return true, report, obj, nil
+		}
+
+		log.Info("Created a new object", "kind", gvk.Kind, "name", key.Name, "namespace", key.Namespace)
+		metrics.SyncedObjects.WithLabelValues(gvk.Kind, "create").Inc()
+		s.recordEvent(owner, report)
+		return true, report, obj, nil
+	}
+
+	report := s.newReport(ReasonUpdated, obj)
+	report.Changes = findChanges(actual, obj, applyDiffOpts)
+	if len(report.Changes) == 0 {
+		return false, nil, obj, nil
+	}
+
+	report.Diff = cmp.Diff(actual, obj, applyDiffOpts)
+	s.logReport(report)
+	if s.dryRun {
+		s.recordEvent(owner, report)
+		return true, report, obj, nil
+	}
+
+	metrics.SyncedObjects.WithLabelValues(gvk.Kind, "update").Inc()
+	s.recordEvent(owner, report)
+	return true, report, obj, nil
+}
+
+// recordEvent records the event about the change of the object on its owner. Nothing is recorded for the objects
+// without an owner, the callers need to record the events about those on the appropriate objects themselves.
+func (s *Syncer) recordEvent(owner metav1.Object, report *Report) {
+	if s.recorder == nil || owner == nil {
+		return
+	}
+
+	ownerObject, ok := owner.(runtime.Object)
+	if !ok {
+		return
+	}
+
+	s.recordEventOn(ownerObject, report)
+}
+
+// recordEventOn records the event about the change on the provided object, which can also be just a reference to
+// the object, e.g. to the controller of a deleted object.
+func (s *Syncer) recordEventOn(object runtime.Object, report *Report) {
+	if s.recorder == nil || object == nil {
+		return
+	}
+
+	s.recorder.Event(object, corev1.EventTypeNormal, report.EventReason(), report.String())
+}
+
+// logReport logs the summary of the change and, at the debug level, the full diff, which is useful for finding out
+// why an object keeps being updated.
+func (s *Syncer) logReport(report *Report) {
+	msg := "Updating existing object"
+	if s.dryRun {
+		msg = "Would update existing object"
+	}
+	log.Info(msg, "kind", report.Kind, "name", report.Name, "namespace", report.Namespace, "reason", report.Reason, "changes", report.Paths())
+	log.V(1).Info("Difference between the object in the cluster and its blueprint", "kind", report.Kind, "name", report.Name, "namespace", report.Namespace, "changes", report.Changes, "diff", report.Diff)
+}
+
+// controllerOf returns the reference to the controller of the object usable as the object of the events, or nil if
+// the object has no controller.
+func controllerOf(object metav1.Object) runtime.Object {
+	ref := metav1.GetControllerOf(object)
+	if ref == nil {
+		return nil
+	}
+
+	return &corev1.ObjectReference{APIVersion: ref.APIVersion, Kind: ref.Kind, Name: ref.Name, UID: ref.UID, Namespace: object.GetNamespace()}
+}
+
+func (s *Syncer) newReport(reason Reason, obj runtime.Object) *Report {
+	objMeta := obj.(metav1.Object)
+	return &Report{Kind: s.kindOf(obj), Name: objMeta.GetName(), Namespace: objMeta.GetNamespace(), Reason: reason, DryRun: s.dryRun}
+}
+
+func (s *Syncer) kindOf(obj runtime.Object) string {
+	// the typed objects usually don't have the kind set
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	if gvk, err := apiutil.GVKForObject(obj, s.scheme); err == nil {
+		kind = gvk.Kind
 	}
-	return false, actual, nil
+	return kind
 }
 
 func isUpdateUsingDeleteCreate(kind string) bool {
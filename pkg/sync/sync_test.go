@@ -3,14 +3,22 @@ package sync
 import (
 	"context"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/che-incubator/devworkspace-che-operator/pkg/metrics"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 var (
@@ -191,3 +199,524 @@ func TestSyncKeepsAdditionalAnnosAndLabels(t *testing.T) {
 		t.Fatal("Unexpected annotations on the synced object")
 	}
 }
+
+func TestSyncRecordsEventsOnOwner(t *testing.T) {
+	owner := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "owner",
+			Namespace: "default",
+		},
+	}
+
+	obj := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "obj",
+			Namespace: "default",
+		},
+		Data: map[string]string{"a": "b"},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, owner)
+	recorder := record.NewFakeRecorder(10)
+
+	syncer := New(cl, scheme, recorder, "")
+	diffOpts := cmpopts.IgnoreFields(corev1.ConfigMap{}, "TypeMeta", "ObjectMeta")
+
+	if _, _, err := syncer.Sync(context.TODO(), owner, obj.DeepCopy(), diffOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	expectEvent(t, recorder, "Normal Created Created ConfigMap default/obj")
+
+	obj.Data["a"] = "c"
+	if _, _, err := syncer.Sync(context.TODO(), owner, obj.DeepCopy(), diffOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	expectEvent(t, recorder, "Normal Updated Updated ConfigMap default/obj")
+
+	// no event should be recorded when nothing changes
+	if _, _, err := syncer.Sync(context.TODO(), owner, obj.DeepCopy(), diffOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case e := <-recorder.Events:
+		t.Errorf("No event should have been recorded for an unchanged object but got %s", e)
+	default:
+	}
+}
+
+func expectEvent(t *testing.T, recorder *record.FakeRecorder, prefix string) {
+	select {
+	case e := <-recorder.Events:
+		if !strings.HasPrefix(e, prefix) {
+			t.Errorf("Expected an event starting with %q but got %q", prefix, e)
+		}
+	default:
+		t.Errorf("Expected an event starting with %q but got none", prefix)
+	}
+}
+
+func TestSyncCountsObjects(t *testing.T) {
+	obj := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "counted",
+			Namespace: "default",
+		},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, &corev1.Pod{})
+	syncer := New(cl, scheme, nil, "")
+
+	created := testutil.ToFloat64(metrics.SyncedObjects.WithLabelValues("ConfigMap", "create"))
+	deleted := testutil.ToFloat64(metrics.SyncedObjects.WithLabelValues("ConfigMap", "delete"))
+
+	if _, _, err := syncer.Sync(context.TODO(), nil, obj.DeepCopy(), cmp.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := syncer.Delete(context.TODO(), obj.DeepCopy()); err != nil {
+		t.Fatal(err)
+	}
+
+	if testutil.ToFloat64(metrics.SyncedObjects.WithLabelValues("ConfigMap", "create")) != created+1 {
+		t.Error("The creation of the config map should have been counted")
+	}
+	if testutil.ToFloat64(metrics.SyncedObjects.WithLabelValues("ConfigMap", "delete")) != deleted+1 {
+		t.Error("The deletion of the config map should have been counted")
+	}
+}
+
+// applyClient emulates the server-side apply on top of the fake client, which doesn't support it. It only updates
+// the object if it differs from what is in the cluster, like the real server does.
+type applyClient struct {
+	client.Client
+	fieldManagers []string
+	// the number of the apply patches that should fail as invalid before the objects are applied
+	invalid int
+}
+
+func (c *applyClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+
+	patchOpts := &client.PatchOptions{}
+	patchOpts.ApplyOptions(opts)
+	c.fieldManagers = append(c.fieldManagers, patchOpts.FieldManager)
+
+	objMeta := obj.(metav1.Object)
+	key := client.ObjectKey{Name: objMeta.GetName(), Namespace: objMeta.GetNamespace()}
+
+	dryRun := len(patchOpts.DryRun) > 0
+
+	existing := obj.DeepCopyObject()
+	if err := c.Client.Get(ctx, key, existing); err != nil {
+		if errors.IsNotFound(err) {
+			if dryRun {
+				return nil
+			}
+			return c.Client.Create(ctx, obj)
+		}
+		return err
+	}
+
+	if c.invalid > 0 {
+		c.invalid--
+		return errors.NewInvalid(obj.GetObjectKind().GroupVersionKind().GroupKind(), key.Name, nil)
+	}
+
+	objMeta.SetResourceVersion(existing.(metav1.Object).GetResourceVersion())
+	if dryRun || reflect.DeepEqual(existing, obj) {
+		return nil
+	}
+	return c.Client.Update(ctx, obj)
+}
+
+func TestSyncUsingServerSideApply(t *testing.T) {
+	owner := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "owner",
+			Namespace: "default",
+		},
+	}
+
+	obj := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "applied",
+			Namespace: "default",
+		},
+		Data: map[string]string{"a": "b"},
+	}
+
+	cl := &applyClient{Client: fake.NewFakeClientWithScheme(scheme, owner)}
+	recorder := record.NewFakeRecorder(10)
+	syncer := New(cl, scheme, recorder, "test")
+
+	changed, _, err := syncer.Sync(context.TODO(), owner, obj.DeepCopy(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("The creation of the object should have been reported as a change")
+	}
+	expectEvent(t, recorder, "Normal Created Created ConfigMap default/applied")
+
+	changed, _, err = syncer.Sync(context.TODO(), owner, obj.DeepCopy(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Error("Applying the unchanged object should not have been reported as a change")
+	}
+
+	obj.Data["a"] = "c"
+	changed, _, err = syncer.Sync(context.TODO(), owner, obj.DeepCopy(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("The update of the object should have been reported as a change")
+	}
+	expectEvent(t, recorder, "Normal Updated Updated ConfigMap default/applied")
+
+	synced := &corev1.ConfigMap{}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "applied", Namespace: "default"}, synced); err != nil {
+		t.Fatal(err)
+	}
+	if synced.Data["a"] != "c" {
+		t.Error("The object should have been updated")
+	}
+	if len(synced.OwnerReferences) == 0 || synced.OwnerReferences[0].Name != "owner" {
+		t.Error("The object should have been owned by the owner")
+	}
+
+	for _, fm := range cl.fieldManagers {
+		if fm != "test" {
+			t.Errorf("The objects should have been applied using the configured field manager but got %q", fm)
+		}
+	}
+}
+
+// staleCacheClient reads the objects from a cache that lags behind the cluster.
+type staleCacheClient struct {
+	*applyClient
+	cache client.Reader
+}
+
+func (c *staleCacheClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	return c.cache.Get(ctx, key, obj)
+}
+
+func TestSyncUsingServerSideApplyIgnoresStaleCache(t *testing.T) {
+	obj := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "applied",
+			Namespace: "default",
+		},
+		Data: map[string]string{"a": "b"},
+	}
+
+	cluster := &applyClient{Client: fake.NewFakeClientWithScheme(scheme, obj.DeepCopy())}
+	// the cache has only seen the previous resource version of the object
+	cl := &staleCacheClient{applyClient: cluster, cache: fake.NewFakeClientWithScheme(scheme, obj.DeepCopy())}
+
+	updated := &corev1.ConfigMap{}
+	if err := cluster.Get(context.TODO(), client.ObjectKey{Name: "applied", Namespace: "default"}, updated); err != nil {
+		t.Fatal(err)
+	}
+	// e.g. touched by another controller without changing anything the syncer cares about
+	if err := cluster.Update(context.TODO(), updated); err != nil {
+		t.Fatal(err)
+	}
+
+	syncer := New(cl, scheme, nil, "test")
+
+	changed, _, err := syncer.Sync(context.TODO(), nil, obj.DeepCopy(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Error("Applying the object unchanged in the cluster should not have been reported as a change")
+	}
+}
+
+func TestSyncUsingServerSideApplyRecreatesServices(t *testing.T) {
+	obj := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{Port: 8080}},
+		},
+	}
+
+	cl := &applyClient{Client: fake.NewFakeClientWithScheme(scheme, obj.DeepCopy()), invalid: 1}
+	syncer := New(cl, scheme, nil, "test")
+
+	obj.Spec.Ports[0].Port = 9090
+	changed, _, err := syncer.Sync(context.TODO(), nil, obj.DeepCopy(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("The re-creation of the service should have been reported as a change")
+	}
+
+	synced := &corev1.Service{}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "svc", Namespace: "default"}, synced); err != nil {
+		t.Fatal(err)
+	}
+	if synced.Spec.Ports[0].Port != 9090 {
+		t.Error("The service should have been re-created with the new port")
+	}
+}
+
+func TestSyncReportsChanges(t *testing.T) {
+	obj := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "reported",
+			Namespace: "default",
+		},
+		Data: map[string]string{"a": "b", "c": "d"},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, obj.DeepCopy())
+	syncer := New(cl, scheme, nil, "")
+	diffOpts := cmpopts.IgnoreFields(corev1.ConfigMap{}, "TypeMeta", "ObjectMeta")
+
+	obj.Data["a"] = "x"
+	delete(obj.Data, "c")
+
+	report, _, err := syncer.SyncAndReport(context.TODO(), nil, obj.DeepCopy(), diffOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report == nil {
+		t.Fatal("The update should have been reported")
+	}
+
+	if report.Reason != ReasonUpdated || report.Kind != "ConfigMap" || report.Name != "reported" || report.Namespace != "default" {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	expectedChanges := []Change{
+		{Path: "Data[a]", Actual: "b", Desired: "x"},
+		{Path: "Data[c]", Actual: "d", Desired: "<none>"},
+	}
+	if d := cmp.Diff(expectedChanges, report.Changes, cmpopts.SortSlices(func(x, y Change) bool { return x.Path < y.Path })); d != "" {
+		t.Errorf("Unexpected changes: %s", d)
+	}
+
+	if report.Diff == "" {
+		t.Error("The report should have contained the diff")
+	}
+
+	if report.String() != "Updated ConfigMap default/reported: Data[a], Data[c]" {
+		t.Errorf("Unexpected summary of the report: %s", report.String())
+	}
+
+	report, _, err = syncer.SyncAndReport(context.TODO(), nil, obj.DeepCopy(), diffOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != nil {
+		t.Errorf("No change should have been reported for an up-to-date object but got %+v", report)
+	}
+}
+
+func TestSyncDryRun(t *testing.T) {
+	owner := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "owner",
+			Namespace: "default",
+		},
+	}
+
+	existing := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "existing",
+			Namespace: "default",
+		},
+		Data: map[string]string{"a": "b"},
+	}
+
+	new := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "new",
+			Namespace: "default",
+		},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, owner, existing.DeepCopy())
+	recorder := record.NewFakeRecorder(10)
+	syncer := New(cl, scheme, recorder, "")
+	dryRun := syncer.DryRun()
+	diffOpts := cmpopts.IgnoreFields(corev1.ConfigMap{}, "TypeMeta", "ObjectMeta")
+
+	changed, _, err := dryRun.Sync(context.TODO(), owner, new.DeepCopy(), diffOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("The creation should have been reported in the dry-run mode")
+	}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "new", Namespace: "default"}, &corev1.ConfigMap{}); !errors.IsNotFound(err) {
+		t.Errorf("The object should not have been created in the dry-run mode but got: %v", err)
+	}
+
+	existing.Data["a"] = "c"
+	report, _, err := dryRun.SyncAndReport(context.TODO(), owner, existing.DeepCopy(), diffOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report == nil || report.Reason != ReasonUpdated || len(report.Changes) != 1 || report.Changes[0].Path != "Data[a]" {
+		t.Errorf("The update of the data should have been reported in the dry-run mode but got %+v", report)
+	}
+
+	synced := &corev1.ConfigMap{}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "existing", Namespace: "default"}, synced); err != nil {
+		t.Fatal(err)
+	}
+	if synced.Data["a"] != "b" {
+		t.Error("The object should not have been updated in the dry-run mode")
+	}
+
+	expectEvent(t, recorder, "Normal DryRunCreated Would create ConfigMap default/new")
+	expectEvent(t, recorder, "Normal DryRunUpdated Would update ConfigMap default/existing: Data[a]")
+
+	owned := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "owned",
+			Namespace: "default",
+		},
+	}
+	if err = controllerutil.SetControllerReference(owner, owned, scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.Create(context.TODO(), owned); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = dryRun.DeleteAndReport(context.TODO(), owned.DeepCopy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report == nil || report.Reason != ReasonDeleted || !report.DryRun {
+		t.Errorf("The deletion should have been reported in the dry-run mode but got %+v", report)
+	}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "owned", Namespace: "default"}, &corev1.ConfigMap{}); err != nil {
+		t.Errorf("The object should not have been deleted in the dry-run mode but got: %v", err)
+	}
+	expectEvent(t, recorder, "Normal DryRunDeleted Would delete ConfigMap default/owned")
+
+	if report, err = dryRun.DeleteAndReport(context.TODO(), new.DeepCopy()); err != nil || report != nil {
+		t.Errorf("Nothing should have been reported for the object that doesn't exist, report: %+v, err: %v", report, err)
+	}
+
+	// the original syncer is not affected by the dry-run mode
+	if changed, _, err = syncer.Sync(context.TODO(), owner, existing.DeepCopy(), diffOpts); err != nil || !changed {
+		t.Errorf("The object should have been updated by the original syncer, changed: %v, err: %v", changed, err)
+	}
+	expectEvent(t, recorder, "Normal Updated Updated ConfigMap default/existing: Data[a]")
+}
+
+func TestSyncUsingServerSideApplyDryRun(t *testing.T) {
+	obj := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "applied",
+			Namespace: "default",
+		},
+		Data: map[string]string{"a": "b"},
+	}
+
+	cl := &applyClient{Client: fake.NewFakeClientWithScheme(scheme, obj.DeepCopy())}
+	syncer := New(cl, scheme, nil, "test")
+	dryRun := syncer.DryRun()
+
+	report, _, err := dryRun.SyncAndReport(context.TODO(), nil, obj.DeepCopy(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != nil {
+		t.Errorf("No change should have been reported for an up-to-date object but got %+v", report)
+	}
+
+	obj.Data["a"] = "c"
+	report, _, err = dryRun.SyncAndReport(context.TODO(), nil, obj.DeepCopy(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report == nil || report.Reason != ReasonUpdated || len(report.Changes) != 1 || report.Changes[0].Path != "Data[a]" {
+		t.Errorf("The update of the data should have been reported in the dry-run mode but got %+v", report)
+	}
+
+	synced := &corev1.ConfigMap{}
+	if err = cl.Get(context.TODO(), client.ObjectKey{Name: "applied", Namespace: "default"}, synced); err != nil {
+		t.Fatal(err)
+	}
+	if synced.Data["a"] != "b" {
+		t.Error("The object should not have been updated in the dry-run mode")
+	}
+}
+
+func TestSyncReportsDataNamedLikeClusterMaintainedFields(t *testing.T) {
+	obj := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "applied",
+			Namespace: "default",
+		},
+		Data: map[string]string{"generation": "1"},
+	}
+
+	cl := &applyClient{Client: fake.NewFakeClientWithScheme(scheme, obj.DeepCopy())}
+	syncer := New(cl, scheme, nil, "test")
+	dryRun := syncer.DryRun()
+
+	obj.Data["generation"] = "2"
+	report, _, err := dryRun.SyncAndReport(context.TODO(), nil, obj.DeepCopy(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report == nil || len(report.Changes) != 1 || report.Changes[0].Path != "Data[generation]" {
+		t.Errorf("Only the metadata maintained by the cluster should be ignored but got %+v", report)
+	}
+}
+
+func TestSyncReportsRecreateAsChange(t *testing.T) {
+	obj := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{Port: 8080}},
+		},
+	}
+
+	cl := fake.NewFakeClientWithScheme(scheme, obj.DeepCopy())
+	syncer := New(cl, scheme, nil, "")
+	diffOpts := cmpopts.IgnoreFields(corev1.Service{}, "TypeMeta", "ObjectMeta")
+
+	obj.Spec.Ports[0].Port = 9090
+
+	dryRun := syncer.DryRun()
+	report, _, err := dryRun.SyncAndReport(context.TODO(), nil, obj.DeepCopy(), diffOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report == nil || report.Reason != ReasonRecreated {
+		t.Errorf("The re-creation of the service should have been reported in the dry-run mode but got %+v", report)
+	}
+
+	changed, _, err := dryRun.Sync(context.TODO(), nil, obj.DeepCopy(), diffOpts)
+	if err != nil || !changed {
+		t.Errorf("The re-creation of the service should have been reported as a change in the dry-run mode, changed: %v, err: %v", changed, err)
+	}
+
+	changed, _, err = syncer.Sync(context.TODO(), nil, obj.DeepCopy(), diffOpts)
+	if err != nil || !changed {
+		t.Errorf("The re-creation of the service should have been reported as a change, changed: %v, err: %v", changed, err)
+	}
+}